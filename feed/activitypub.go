@@ -0,0 +1,280 @@
+package feed
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/mmcdole/gofeed"
+)
+
+// activityStreamsPublic is the ActivityPub audience of public posts.
+const activityStreamsPublic = "https://www.w3.org/ns/activitystreams#Public"
+
+// activityJSON is the Accept header for ActivityPub documents.
+const activityJSON = `application/activity+json, application/ld+json; profile="https://www.w3.org/ns/activitystreams"`
+
+// apObject is the subset of an ActivityPub object (actor, collection,
+// activity or note) which is needed to bridge an account.
+type apObject struct {
+	ID                string          `json:"id"`
+	Type              string          `json:"type"`
+	URL               json.RawMessage `json:"url"`
+	Name              string          `json:"name"`
+	PreferredUsername string          `json:"preferredUsername"`
+	Summary           string          `json:"summary"`
+	Content           string          `json:"content"`
+	MediaType         string          `json:"mediaType"`
+	Published         string          `json:"published"`
+	InReplyTo         json.RawMessage `json:"inReplyTo"`
+	To                json.RawMessage `json:"to"`
+	Cc                json.RawMessage `json:"cc"`
+	Outbox            string          `json:"outbox"`
+	Icon              *apObject       `json:"icon"`
+	Attachment        []apObject      `json:"attachment"`
+	Tag               []apObject      `json:"tag"`
+	Object            json.RawMessage `json:"object"`
+	First             json.RawMessage `json:"first"`
+	OrderedItems      []apObject      `json:"orderedItems"`
+}
+
+// apStrings returns the URLs of an ActivityPub field which can be a string,
+// a link object or an array of either.
+func apStrings(raw json.RawMessage) []string {
+	var single string
+	if err := json.Unmarshal(raw, &single); err == nil {
+		return []string{single}
+	}
+
+	var link struct {
+		Href string `json:"href"`
+		ID   string `json:"id"`
+	}
+	if err := json.Unmarshal(raw, &link); err == nil {
+		if link.Href != "" {
+			return []string{link.Href}
+		}
+		if link.ID != "" {
+			return []string{link.ID}
+		}
+		return nil
+	}
+
+	var many []json.RawMessage
+	if err := json.Unmarshal(raw, &many); err != nil {
+		return nil
+	}
+
+	var values []string
+	for _, value := range many {
+		values = append(values, apStrings(value)...)
+	}
+
+	return values
+}
+
+// apGet retrieves an ActivityPub document.
+func apGet(ctx context.Context, client *http.Client, docURL, accept string, doc interface{}) error {
+	request, err := http.NewRequestWithContext(ctx, "GET", docURL, nil)
+	if err != nil {
+		return fmt.Errorf("apGet: unable to create request for %s: %w", docURL, err)
+	}
+	request.Header.Set("Accept", accept)
+
+	response, err := client.Do(request)
+	if err != nil {
+		return fmt.Errorf("apGet: unable to retrieve %s: %w", docURL, err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != 200 {
+		return fmt.Errorf("apGet: unable to retrieve %s: HTTP %d", docURL, response.StatusCode)
+	}
+
+	if err := json.NewDecoder(response.Body).Decode(doc); err != nil {
+		return fmt.Errorf("apGet: unable to decode %s: %w", docURL, err)
+	}
+
+	return nil
+}
+
+// apActorURL resolves an account (@user@instance) to its actor URL with
+// WebFinger. URLs are returned as is.
+func apActorURL(ctx context.Context, client *http.Client, account string) (string, error) {
+	if strings.HasPrefix(account, "https://") || strings.HasPrefix(account, "http://") {
+		return account, nil
+	}
+
+	user, host, ok := strings.Cut(strings.TrimPrefix(account, "@"), "@")
+	if !ok || user == "" || host == "" {
+		return "", fmt.Errorf("apActorURL: %s is not an account, expected @user@instance", account)
+	}
+
+	var webfinger struct {
+		Links []struct {
+			Rel  string `json:"rel"`
+			Type string `json:"type"`
+			Href string `json:"href"`
+		} `json:"links"`
+	}
+
+	webfingerURL := fmt.Sprintf("https://%s/.well-known/webfinger?resource=%s", host, url.QueryEscape("acct:"+user+"@"+host))
+	if err := apGet(ctx, client, webfingerURL, "application/jrd+json", &webfinger); err != nil {
+		return "", fmt.Errorf("apActorURL: %w", err)
+	}
+
+	for _, link := range webfinger.Links {
+		if link.Rel == "self" && (strings.Contains(link.Type, "activity+json") || strings.Contains(link.Type, "activitystreams")) {
+			return link.Href, nil
+		}
+	}
+
+	return "", fmt.Errorf("apActorURL: no actor found for %s", account)
+}
+
+// fetchActivityPubFeed turns the latest public posts of a fediverse account
+// into feed items, newest first. Boosts and replies are left out.
+func fetchActivityPubFeed(ctx context.Context, account string, client *http.Client) (gofeed.Feed, error) {
+	var feed gofeed.Feed
+
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	defer cancel()
+
+	actorURL, err := apActorURL(ctx, client, account)
+	if err != nil {
+		return feed, fmt.Errorf("fetchActivityPubFeed: %w", err)
+	}
+
+	var actor apObject
+	if err := apGet(ctx, client, actorURL, activityJSON, &actor); err != nil {
+		return feed, fmt.Errorf("fetchActivityPubFeed: %w", err)
+	}
+
+	feed.Title = actor.Name
+	if feed.Title == "" {
+		feed.Title = actor.PreferredUsername
+	}
+	feed.Description = actor.Summary
+	if links := apStrings(actor.URL); len(links) > 0 {
+		feed.Link = links[0]
+	}
+	if actor.Icon != nil {
+		if icons := apStrings(actor.Icon.URL); len(icons) > 0 {
+			feed.Image = &gofeed.Image{URL: icons[0]}
+		}
+	}
+
+	var outbox apObject
+	if err := apGet(ctx, client, actor.Outbox, activityJSON, &outbox); err != nil {
+		return feed, fmt.Errorf("fetchActivityPubFeed: %w", err)
+	}
+
+	page := outbox
+	if len(outbox.OrderedItems) == 0 && len(outbox.First) > 0 {
+		if err := json.Unmarshal(outbox.First, &page); err != nil || len(page.OrderedItems) == 0 {
+			firstURLs := apStrings(outbox.First)
+			if len(firstURLs) == 0 {
+				return feed, fmt.Errorf("fetchActivityPubFeed: unable to find the first page of %s", actor.Outbox)
+			}
+			if err := apGet(ctx, client, firstURLs[0], activityJSON, &page); err != nil {
+				return feed, fmt.Errorf("fetchActivityPubFeed: %w", err)
+			}
+		}
+	}
+
+	for _, activity := range page.OrderedItems {
+		if activity.Type != "Create" {
+			continue
+		}
+
+		var note apObject
+		if err := json.Unmarshal(activity.Object, &note); err != nil {
+			continue
+		}
+
+		if len(apStrings(note.InReplyTo)) > 0 {
+			continue
+		}
+
+		public := false
+		for _, audience := range append(apStrings(note.To), apStrings(note.Cc)...) {
+			if audience == activityStreamsPublic || audience == "as:Public" || audience == "Public" {
+				public = true
+			}
+		}
+		if !public {
+			continue
+		}
+
+		feed.Items = append(feed.Items, apItem(note, feed.Title))
+	}
+
+	return feed, nil
+}
+
+// apItem converts an ActivityPub note into a feed item. Media attachments
+// are turned into images (uploaded as blobs later on) or links.
+func apItem(note apObject, author string) *gofeed.Item {
+	item := &gofeed.Item{
+		GUID:   note.ID,
+		Link:   note.ID,
+		Author: &gofeed.Person{Name: author},
+	}
+	item.Authors = []*gofeed.Person{item.Author}
+
+	if links := apStrings(note.URL); len(links) > 0 {
+		item.Link = links[0]
+	}
+
+	if published, err := time.Parse(time.RFC3339, note.Published); err == nil {
+		item.PublishedParsed = &published
+	}
+
+	item.Title = note.Summary
+	if item.Title == "" {
+		doc, err := goquery.NewDocumentFromReader(strings.NewReader(note.Content))
+		if err == nil {
+			item.Title = strings.Join(strings.Fields(doc.Text()), " ")
+		}
+		if runes := []rune(item.Title); len(runes) > apTitleLength {
+			item.Title = strings.TrimSpace(string(runes[:apTitleLength])) + "…"
+		}
+	}
+
+	var content strings.Builder
+	content.WriteString(note.Content)
+
+	for _, attachment := range note.Attachment {
+		urls := apStrings(attachment.URL)
+		if len(urls) == 0 {
+			continue
+		}
+
+		attachmentURL := html.EscapeString(urls[0])
+		if strings.HasPrefix(attachment.MediaType, "image/") || attachment.Type == "Image" {
+			content.WriteString(`<p><img src="` + attachmentURL + `" alt="` + html.EscapeString(attachment.Name) + `"></p>`)
+		} else {
+			title := attachment.Name
+			if title == "" {
+				title = path.Base(urls[0])
+			}
+			content.WriteString(`<p>&#128206; <a href="` + attachmentURL + `">` + html.EscapeString(title) + `</a></p>`)
+		}
+	}
+	item.Content = content.String()
+
+	for _, tag := range note.Tag {
+		if tag.Type == "Hashtag" {
+			item.Categories = append(item.Categories, strings.TrimPrefix(tag.Name, "#"))
+		}
+	}
+
+	return item
+}
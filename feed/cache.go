@@ -0,0 +1,161 @@
+package feed
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// imageCacheMaxAge is how long a cached image is kept after it was last used.
+const imageCacheMaxAge = 30 * 24 * time.Hour
+
+// cachedImage are the validators of a cached image, which are sent along
+// when the image is downloaded again so that an unchanged image isn't
+// transferred twice.
+type cachedImage struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+}
+
+// NewImages returns the image state of the feeds, caching downloaded images
+// in cacheDir unless it's empty. The cached images which weren't used for a
+// while are removed.
+func NewImages(cacheDir string) (*Images, error) {
+	images := &Images{cacheDir: cacheDir, missing: make(map[string]int)}
+	if cacheDir == "" {
+		return images, nil
+	}
+
+	if err := os.MkdirAll(cacheDir, 0700); err != nil {
+		return nil, fmt.Errorf("NewImages: unable to create %s: %w", cacheDir, err)
+	}
+
+	entries, err := os.ReadDir(cacheDir)
+	if err != nil {
+		return nil, fmt.Errorf("NewImages: unable to read %s: %w", cacheDir, err)
+	}
+
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || time.Since(info.ModTime()) < imageCacheMaxAge {
+			continue
+		}
+
+		if err := os.Remove(filepath.Join(cacheDir, entry.Name())); err != nil {
+			log.Printf("NewImages: %s", err)
+		}
+	}
+
+	return images, nil
+}
+
+// imageCachePath returns the path of the cached image body of url, its
+// validators are stored next to it with a .json extension. It returns an
+// empty path when the cache is disabled.
+func (i *Images) imageCachePath(url string) string {
+	if i == nil || i.cacheDir == "" {
+		return ""
+	}
+
+	hash := sha256.Sum256([]byte(url))
+
+	return filepath.Join(i.cacheDir, hex.EncodeToString(hash[:]))
+}
+
+// loadCachedImage returns the validators and the body of a cached image.
+func (i *Images) loadCachedImage(url string) (cachedImage, []byte, bool) {
+	var cached cachedImage
+
+	path := i.imageCachePath(url)
+	if path == "" {
+		return cached, nil, false
+	}
+
+	contents, err := os.ReadFile(path + ".json")
+	if err != nil {
+		return cached, nil, false
+	}
+
+	if err := json.Unmarshal(contents, &cached); err != nil || cached.URL != url {
+		return cached, nil, false
+	}
+
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return cached, nil, false
+	}
+
+	return cached, body, true
+}
+
+// touchCachedImage marks a cached image as used, so that it isn't removed.
+func (i *Images) touchCachedImage(url string) {
+	path := i.imageCachePath(url)
+	if path == "" {
+		return
+	}
+
+	now := time.Now()
+	for _, name := range []string{path, path + ".json"} {
+		if err := os.Chtimes(name, now, now); err != nil {
+			log.Printf("touchCachedImage: %s", err)
+		}
+	}
+}
+
+// storeCachedImage caches an image body with its validators. Images without
+// validators are not cached, there's no way to tell whether they changed.
+func (i *Images) storeCachedImage(cached cachedImage, body []byte) {
+	path := i.imageCachePath(cached.URL)
+	if path == "" || (cached.ETag == "" && cached.LastModified == "") {
+		return
+	}
+
+	contents, err := json.Marshal(cached)
+	if err != nil {
+		log.Printf("storeCachedImage: unable to marshal validators: %s", err)
+		return
+	}
+
+	if err := writeFileAtomic(path, body); err != nil {
+		log.Printf("storeCachedImage: %s", err)
+		return
+	}
+
+	if err := writeFileAtomic(path+".json", contents); err != nil {
+		log.Printf("storeCachedImage: %s", err)
+	}
+}
+
+// writeFileAtomic writes a file through a temporary file, so that concurrent
+// readers never see a partially written file.
+func writeFileAtomic(path string, contents []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("writeFileAtomic: unable to create temporary file: %w", err)
+	}
+
+	if _, err := tmp.Write(contents); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("writeFileAtomic: unable to write %s: %w", tmp.Name(), err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("writeFileAtomic: unable to close %s: %w", tmp.Name(), err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("writeFileAtomic: unable to rename %s: %w", tmp.Name(), err)
+	}
+
+	return nil
+}
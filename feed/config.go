@@ -0,0 +1,632 @@
+// Package feed fetches feeds (RSS, Atom, JSON Feed, IMAP mailboxes and
+// ActivityPub outboxes) and converts their items into SSB messages.
+package feed
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	refs "github.com/ssbc/go-ssb-refs"
+)
+
+// Config is the configuration of a single RSS feed.
+type Config struct {
+	Feed       string        `yaml:"feed,omitempty"`
+	Source     string        `yaml:"source,omitempty"`
+	IMAP       IMAPConfig    `yaml:"imap,omitempty"`
+	Poll       int           `yaml:"poll,omitempty"`
+	PollEvery  time.Duration `yaml:"poll-every,omitempty"`
+	Schedule   string        `yaml:"schedule,omitempty"`
+	PollJitter time.Duration `yaml:"poll-jitter,omitempty"`
+	DeadAfter  time.Duration `yaml:"dead-after,omitempty"`
+	DeadPost   bool          `yaml:"dead-post,omitempty"`
+
+	UserAgent string            `yaml:"user-agent,omitempty"`
+	Headers   map[string]string `yaml:"headers,omitempty"`
+	Auth      AuthConfig        `yaml:"auth,omitempty"`
+
+	MaxBlobSize    int64 `yaml:"max-blob-size,omitempty"`
+	ImageMaxWidth  int   `yaml:"image-max-width,omitempty"`
+	ImageMaxHeight int   `yaml:"image-max-height,omitempty"`
+	ImageQuality   int   `yaml:"image-quality,omitempty"`
+
+	ImageTimeout        time.Duration `yaml:"image-timeout,omitempty"`
+	ImageConnectTimeout time.Duration `yaml:"image-connect-timeout,omitempty"`
+	ImageAttempts       int           `yaml:"image-attempts,omitempty"`
+	ImageConcurrency    int           `yaml:"image-concurrency,omitempty"`
+
+	ImagePolicies    map[string]string `yaml:"image-policies,omitempty"`
+	MaxAnimationSize int64             `yaml:"max-animation-size,omitempty"`
+
+	KeepImageMetadata bool `yaml:"keep-image-metadata,omitempty"`
+	EmbedThumbnails   bool `yaml:"embed-thumbnails,omitempty"`
+	OGImage           bool `yaml:"og-image,omitempty"`
+
+	Conversion     ConversionRules `yaml:"conversion,omitempty"`
+	TrackingParams []string        `yaml:"tracking-params,omitempty"`
+	FetchFullText  bool            `yaml:"fetch-full-text,omitempty"`
+
+	SummaryParagraphs int `yaml:"summary-paragraphs,omitempty"`
+	SummaryCharacters int `yaml:"summary-characters,omitempty"`
+
+	LongPosts   string `yaml:"long-posts,omitempty"`
+	Template    string `yaml:"template,omitempty"`
+	Byline      string `yaml:"byline,omitempty"`
+	PostProcess string `yaml:"post-process,omitempty"`
+
+	DateFormat string `yaml:"date-format,omitempty"`
+	Timezone   string `yaml:"timezone,omitempty"`
+
+	Channel    string        `yaml:"channel,omitempty"`
+	Categories CategoryRules `yaml:"categories,omitempty"`
+	Filters    FilterRules   `yaml:"filters,omitempty"`
+	Language   LanguageRules `yaml:"language,omitempty"`
+
+	Translate TranslateConfig `yaml:"translate,omitempty"`
+
+	ContentWarning  string            `yaml:"content-warning,omitempty"`
+	ContentWarnings map[string]string `yaml:"content-warnings,omitempty"`
+
+	// contentWarnings are the compiled ContentWarnings, sorted by pattern
+	contentWarnings []contentWarning
+
+	MaxItemAge           time.Duration `yaml:"max-item-age,omitempty"`
+	Since                string        `yaml:"since,omitempty"`
+	InitialBackfillLimit int           `yaml:"initial-backfill-limit,omitempty"`
+	PublishUpdates       bool          `yaml:"publish-updates,omitempty"`
+
+	Moderate bool `yaml:"moderate,omitempty"`
+
+	MaxPostsPerCycle int           `yaml:"max-posts-per-cycle,omitempty"`
+	PublishSpacing   time.Duration `yaml:"publish-spacing,omitempty"`
+	PublishWindow    string        `yaml:"publish-window,omitempty"`
+
+	Digest string `yaml:"digest,omitempty"`
+
+	Nostr NostrConfig `yaml:"nostr,omitempty"`
+
+	PublishWebhook string `yaml:"publish-webhook,omitempty"`
+
+	Matrix MatrixConfig `yaml:"matrix,omitempty"`
+	XMPP   XMPPConfig   `yaml:"xmpp,omitempty"`
+
+	PrivateGroup GroupConfig `yaml:"private-group,omitempty"`
+	Recipients   []string    `yaml:"recipients,omitempty"`
+
+	Description string `yaml:"description,omitempty"`
+	Operator    string `yaml:"operator,omitempty"`
+
+	Introduction         bool   `yaml:"introduction,omitempty"`
+	IntroductionTemplate string `yaml:"introduction-template,omitempty"`
+
+	Summary         bool   `yaml:"summary,omitempty"`
+	SummaryTemplate string `yaml:"summary-template,omitempty"`
+
+	// Images is shared by the feeds of a pub, it's not configured
+	Images *Images `yaml:"-"`
+}
+
+// FilterRules decide which RSS items get published. Include and Exclude are
+// regular expressions matched against the item title and body.
+type FilterRules struct {
+	Include         []string `yaml:"include,omitempty"`
+	Exclude         []string `yaml:"exclude,omitempty"`
+	AllowCategories []string `yaml:"allow-categories,omitempty"`
+	DenyCategories  []string `yaml:"deny-categories,omitempty"`
+	AllowAuthors    []string `yaml:"allow-authors,omitempty"`
+	DenyAuthors     []string `yaml:"deny-authors,omitempty"`
+
+	// include and exclude are compiled by ValidateConfig
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+}
+
+// LanguageRules configure what's done with the detected language of RSS
+// items: Hashtag adds it as a hashtag starting with Prefix ("lang-" by
+// default) and Allow only publishes items in these languages (ISO 639-1 codes,
+// e.g. de).
+type LanguageRules struct {
+	Hashtag bool     `yaml:"hashtag,omitempty"`
+	Prefix  string   `yaml:"prefix,omitempty"`
+	Allow   []string `yaml:"allow,omitempty"`
+}
+
+// CategoryRules configure how RSS item categories are turned into SSB
+// hashtags and channels.
+type CategoryRules struct {
+	Hashtags bool              `yaml:"hashtags,omitempty"`
+	Channel  bool              `yaml:"channel,omitempty"`
+	Mapping  map[string]string `yaml:"mapping,omitempty"`
+	Allow    []string          `yaml:"allow,omitempty"`
+	Deny     []string          `yaml:"deny,omitempty"`
+}
+
+// PostData is the data available to the post template. Byline is only set
+// when byline is "line". Published is the formatted publication date of the
+// item, empty when the feed doesn't provide one.
+type PostData struct {
+	Title      string
+	Image      string
+	Content    string
+	Link       string
+	Hashtags   string
+	Author     string
+	AuthorLink string
+	Byline     string
+	Published  string
+}
+
+// ConversionRules are user defined tweaks to the HTML -> Markdown conversion,
+// applied on top of the built-in rules.
+type ConversionRules struct {
+	Remove              []string `yaml:"remove,omitempty"`
+	Unwrap              []string `yaml:"unwrap,omitempty"`
+	BlockedImageDomains []string `yaml:"blocked-image-domains,omitempty"`
+}
+
+// IMAPConfig is the configuration of an IMAP mailbox source.
+type IMAPConfig struct {
+	Host     string `yaml:"host,omitempty"`
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+	Folder   string `yaml:"folder,omitempty"`
+	Limit    int    `yaml:"limit,omitempty"`
+}
+
+// AuthConfig are the credentials of a private feed, either basic auth or a
+// bearer token. Secrets can be read from an environment variable or a file
+// instead of being written into the config file.
+type AuthConfig struct {
+	Username     string `yaml:"username,omitempty"`
+	Password     string `yaml:"password,omitempty"`
+	PasswordEnv  string `yaml:"password-env,omitempty"`
+	PasswordFile string `yaml:"password-file,omitempty"`
+	Token        string `yaml:"token,omitempty"`
+	TokenEnv     string `yaml:"token-env,omitempty"`
+	TokenFile    string `yaml:"token-file,omitempty"`
+}
+
+// NostrConfig is the configuration of the Nostr output. Published posts are
+// also broadcast to the relays, signed with the hex encoded private key.
+type NostrConfig struct {
+	PrivateKey string   `yaml:"private-key,omitempty"`
+	Relays     []string `yaml:"relays,omitempty"`
+}
+
+// MatrixConfig is a Matrix room which is notified of every new item, through
+// the client-server API of the homeserver with the access token of a (bot)
+// user in the room. Room is a room ID or alias.
+type MatrixConfig struct {
+	Homeserver      string `yaml:"homeserver,omitempty"`
+	Room            string `yaml:"room,omitempty"`
+	AccessToken     string `yaml:"access-token,omitempty"`
+	AccessTokenEnv  string `yaml:"access-token-env,omitempty"`
+	AccessTokenFile string `yaml:"access-token-file,omitempty"`
+}
+
+// XMPPConfig is a XMPP account which sends one-line notices to a contact (To)
+// or to a multi-user chat (Room), which it joins as Nick. Server is the
+// host:port to connect to, it's looked up in DNS when not configured.
+type XMPPConfig struct {
+	JID          string `yaml:"jid,omitempty"`
+	Password     string `yaml:"password,omitempty"`
+	PasswordEnv  string `yaml:"password-env,omitempty"`
+	PasswordFile string `yaml:"password-file,omitempty"`
+	Server       string `yaml:"server,omitempty"`
+	To           string `yaml:"to,omitempty"`
+	Room         string `yaml:"room,omitempty"`
+	Nick         string `yaml:"nick,omitempty"`
+}
+
+// Enabled reports whether the XMPP account is configured.
+func (cfg XMPPConfig) Enabled() bool {
+	return cfg.JID != ""
+}
+
+// ResolveXMPP checks a XMPP account, if configured, and reads its password.
+func ResolveXMPP(cfg *XMPPConfig) error {
+	if !cfg.Enabled() {
+		return nil
+	}
+
+	local, domain, ok := strings.Cut(cfg.JID, "@")
+	if !ok || local == "" || domain == "" || strings.Contains(domain, "/") {
+		return fmt.Errorf("ResolveXMPP: %s is not a bare JID (user@domain)", cfg.JID)
+	}
+
+	if (cfg.To == "") == (cfg.Room == "") {
+		return fmt.Errorf("ResolveXMPP: configure either a contact (to) or a multi-user chat (room)")
+	}
+
+	password, err := ResolveSecret(cfg.Password, cfg.PasswordEnv, cfg.PasswordFile)
+	if err != nil {
+		return fmt.Errorf("ResolveXMPP: password: %w", err)
+	}
+	if password == "" {
+		return fmt.Errorf("ResolveXMPP: %s has no password", cfg.JID)
+	}
+	cfg.Password = password
+
+	if cfg.Nick == "" {
+		cfg.Nick = local
+	}
+
+	return nil
+}
+
+// GroupConfig is a SSB private group (box2) which the posts of a feed are
+// published to instead of publicly. The group is created by the pub, which
+// adds the members to it.
+type GroupConfig struct {
+	Name    string   `yaml:"name,omitempty"`
+	Members []string `yaml:"members,omitempty"`
+}
+
+// ResolveSecret returns a secret which is configured directly, through an
+// environment variable or in a file.
+func ResolveSecret(value, env, file string) (string, error) {
+	switch {
+	case value != "":
+		return value, nil
+	case env != "":
+		secret := os.Getenv(env)
+		if secret == "" {
+			return "", fmt.Errorf("ResolveSecret: environment variable %s is not set", env)
+		}
+		return secret, nil
+	case file != "":
+		contents, err := os.ReadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("ResolveSecret: unable to read %s: %w", file, err)
+		}
+		return strings.TrimSpace(string(contents)), nil
+	}
+
+	return "", nil
+}
+
+// resolveAuth reads the secrets of the credentials of a feed.
+func resolveAuth(auth *AuthConfig) error {
+	password, err := ResolveSecret(auth.Password, auth.PasswordEnv, auth.PasswordFile)
+	if err != nil {
+		return fmt.Errorf("resolveAuth: password: %w", err)
+	}
+
+	token, err := ResolveSecret(auth.Token, auth.TokenEnv, auth.TokenFile)
+	if err != nil {
+		return fmt.Errorf("resolveAuth: token: %w", err)
+	}
+
+	if auth.Username != "" && token != "" {
+		return fmt.Errorf("resolveAuth: configure either a username and password or a token, not both")
+	}
+
+	if password != "" && auth.Username == "" {
+		return fmt.Errorf("resolveAuth: password configured without a username")
+	}
+
+	auth.Password, auth.Token = password, token
+
+	return nil
+}
+
+// MaxPostLength is a post limit set by rss-butt-plug which is smaller than the
+// actual max post length of 8192, to allow some buffer. Any RSS post with a
+// greater length will be split up into threads.
+const MaxPostLength = 7000
+
+// MaxPrivatePostLength is the post limit of feeds which publish encrypted
+// posts, to a private group or to recipients. The encrypted message is base64
+// encoded, which grows it by a third.
+const MaxPrivatePostLength = 4000
+
+// MaxRecipients is the number of recipients a feed may publish to, clients
+// decrypt messages with up to 7 recipients and the pub is one of them.
+const MaxRecipients = 6
+
+// PostLimit returns the post limit of a feed, posts which are longer are
+// split up into threads.
+func PostLimit(cfg Config) int {
+	if cfg.PrivateGroup.Name != "" || len(cfg.Recipients) > 0 {
+		return MaxPrivatePostLength
+	}
+	return MaxPostLength
+}
+
+// defaultMaxBlobSize is the default upper limit (in bytes) of images which are
+// uploaded as blobs. It matches the blob size limit of most SSB clients.
+const defaultMaxBlobSize = 5 * 1024 * 1024
+
+// defaultImageTimeout is how long an image download may take in total and
+// defaultImageConnectTimeout how long connecting to the image host may take,
+// when image-timeout and image-connect-timeout are not configured.
+const (
+	defaultImageTimeout        = 60 * time.Second
+	defaultImageConnectTimeout = 10 * time.Second
+)
+
+// defaultImageAttempts is how often an image download or blob upload is
+// attempted when image-attempts is not configured.
+const defaultImageAttempts = 3
+
+// defaultImageConcurrency is how many images of an item are downloaded at the
+// same time when image-concurrency is not configured.
+const defaultImageConcurrency = 4
+
+// defaultMaxAnimationSize is the default size (in bytes) of animated GIFs
+// above which only their first frame is stored as a blob.
+const defaultMaxAnimationSize = 1024 * 1024
+
+// defaultImageQuality is the JPEG quality used when recompressing images and
+// no image-quality is configured.
+const defaultImageQuality = 85
+
+// blogSummaryLength is the length of the summary of blog messages.
+const blogSummaryLength = 280
+
+// defaultTemplate is the default post layout, see PostData for the available
+// fields.
+const defaultTemplate = `# {{ .Title }}
+{{ if .Byline }}
+{{ .Byline }}
+{{ end }}{{ if .Image }}
+{{ .Image }}
+{{ end }}{{ .Content }}
+---
+[Clearnet link]({{ .Link }}){{ if .Published }} (published {{ .Published }}){{ end }}
+{{ if .Hashtags }}
+{{ .Hashtags }}
+{{ end }}`
+
+// defaultDateFormat is the default Go time layout of the publication date in
+// posts.
+const defaultDateFormat = "2 January 2006 15:04 MST"
+
+// sinceLayouts are the accepted layouts of the since option.
+var sinceLayouts = []string{time.RFC3339, "2006-01-02T15:04", "2006-01-02 15:04", "2006-01-02"}
+
+// maxTableWidth is the widest (in characters) a markdown table line may be
+// before the table is rendered as a preformatted block instead. Wide markdown
+// tables wrap into an unreadable mess in most SSB clients.
+const maxTableWidth = 100
+
+// defaultIMAPLimit is the default number of most recent messages of an IMAP
+// folder which are considered on every poll.
+const defaultIMAPLimit = 50
+
+// apTitleLength is the length of the titles made up for fediverse posts.
+const apTitleLength = 80
+
+// DigestExcerptLength is the length of the excerpts of the items of a digest.
+const DigestExcerptLength = 140
+
+// ValidateConfig validates the options of a feed and fills in defaults.
+func ValidateConfig(cfg *Config) error {
+	switch cfg.Source {
+	case "":
+		cfg.Source = "rss"
+	case "rss", "activitypub":
+	case "imap":
+		if cfg.IMAP.Host == "" || cfg.IMAP.Username == "" {
+			return fmt.Errorf("ValidateConfig: imap source requires a host and username")
+		}
+		if cfg.IMAP.Folder == "" {
+			cfg.IMAP.Folder = "INBOX"
+		}
+		if cfg.IMAP.Limit == 0 {
+			cfg.IMAP.Limit = defaultIMAPLimit
+		}
+		if cfg.Feed == "" {
+			cfg.Feed = fmt.Sprintf("imaps://%s@%s/%s", cfg.IMAP.Username, cfg.IMAP.Host, cfg.IMAP.Folder)
+		}
+	default:
+		return fmt.Errorf("ValidateConfig: unknown source %s, expected rss, imap or activitypub", cfg.Source)
+	}
+
+	if cfg.Feed == "" {
+		return fmt.Errorf("ValidateConfig: missing feed URL")
+	}
+
+	if cfg.MaxBlobSize == 0 {
+		cfg.MaxBlobSize = defaultMaxBlobSize
+	}
+
+	if cfg.ImageTimeout == 0 {
+		cfg.ImageTimeout = defaultImageTimeout
+	}
+
+	if cfg.ImageConnectTimeout == 0 {
+		cfg.ImageConnectTimeout = defaultImageConnectTimeout
+	}
+
+	if cfg.ImageAttempts == 0 {
+		cfg.ImageAttempts = defaultImageAttempts
+	}
+
+	if cfg.ImageConcurrency <= 0 {
+		cfg.ImageConcurrency = defaultImageConcurrency
+	}
+
+	policies, err := validateImagePolicies(cfg.ImagePolicies)
+	if err != nil {
+		return fmt.Errorf("ValidateConfig: %s: %w", cfg.Feed, err)
+	}
+	cfg.ImagePolicies = policies
+
+	if cfg.MaxAnimationSize == 0 {
+		cfg.MaxAnimationSize = defaultMaxAnimationSize
+	}
+
+	if err := resolveAuth(&cfg.Auth); err != nil {
+		return fmt.Errorf("ValidateConfig: %s: %w", cfg.Feed, err)
+	}
+
+	cfg.Channel = strings.TrimPrefix(cfg.Channel, "#")
+
+	switch cfg.Byline {
+	case "", "line", "title":
+	default:
+		return fmt.Errorf("ValidateConfig: %s: unknown byline value %s, expected line or title", cfg.Feed, cfg.Byline)
+	}
+
+	switch cfg.LongPosts {
+	case "":
+		cfg.LongPosts = "thread"
+	case "thread", "blog":
+	default:
+		return fmt.Errorf("ValidateConfig: %s: unknown long-posts value %s, expected thread or blog", cfg.Feed, cfg.LongPosts)
+	}
+
+	switch cfg.Digest {
+	case "daily", "weekly":
+		cfg.Digest = "@" + cfg.Digest
+	}
+
+	if cfg.Digest != "" {
+		if _, err := cron.ParseStandard(cfg.Digest); err != nil {
+			return fmt.Errorf("ValidateConfig: %s: invalid digest schedule %s: %w", cfg.Feed, cfg.Digest, err)
+		}
+	}
+
+	if cfg.Schedule != "" {
+		if _, err := cron.ParseStandard(cfg.Schedule); err != nil {
+			return fmt.Errorf("ValidateConfig: %s: invalid schedule %s: %w", cfg.Feed, cfg.Schedule, err)
+		}
+	}
+
+	if cfg.DateFormat == "" {
+		cfg.DateFormat = defaultDateFormat
+	}
+
+	if _, err := time.LoadLocation(cfg.Timezone); err != nil {
+		return fmt.Errorf("ValidateConfig: %s: unknown timezone %s: %w", cfg.Feed, cfg.Timezone, err)
+	}
+
+	if cfg.Since != "" {
+		if _, err := parseSince(cfg.Since, cfg.Timezone); err != nil {
+			return fmt.Errorf("ValidateConfig: %s: %w", cfg.Feed, err)
+		}
+	}
+
+	if cfg.PublishWindow != "" {
+		if _, err := parsePublishWindow(cfg.PublishWindow, cfg.Timezone); err != nil {
+			return fmt.Errorf("ValidateConfig: %s: %w", cfg.Feed, err)
+		}
+	}
+
+	if cfg.PublishWebhook != "" {
+		if webhook, err := url.Parse(cfg.PublishWebhook); err != nil || (webhook.Scheme != "http" && webhook.Scheme != "https") {
+			return fmt.Errorf("ValidateConfig: %s: publish-webhook %s is not a HTTP(S) URL", cfg.Feed, cfg.PublishWebhook)
+		}
+	}
+
+	if cfg.Matrix.Homeserver != "" || cfg.Matrix.Room != "" {
+		if homeserver, err := url.Parse(cfg.Matrix.Homeserver); err != nil || (homeserver.Scheme != "http" && homeserver.Scheme != "https") {
+			return fmt.Errorf("ValidateConfig: %s: matrix homeserver %s is not a HTTP(S) URL", cfg.Feed, cfg.Matrix.Homeserver)
+		}
+
+		if !strings.HasPrefix(cfg.Matrix.Room, "!") && !strings.HasPrefix(cfg.Matrix.Room, "#") {
+			return fmt.Errorf("ValidateConfig: %s: matrix room %s is not a room ID (!...) or alias (#...)", cfg.Feed, cfg.Matrix.Room)
+		}
+
+		token, err := ResolveSecret(cfg.Matrix.AccessToken, cfg.Matrix.AccessTokenEnv, cfg.Matrix.AccessTokenFile)
+		if err != nil {
+			return fmt.Errorf("ValidateConfig: %s: matrix access token: %w", cfg.Feed, err)
+		}
+		if token == "" {
+			return fmt.Errorf("ValidateConfig: %s: matrix requires an access token", cfg.Feed)
+		}
+		cfg.Matrix.AccessToken = token
+	}
+
+	if err := ResolveXMPP(&cfg.XMPP); err != nil {
+		return fmt.Errorf("ValidateConfig: %s: %w", cfg.Feed, err)
+	}
+
+	if cfg.PrivateGroup.Name == "" && len(cfg.PrivateGroup.Members) > 0 {
+		return fmt.Errorf("ValidateConfig: %s: private-group requires a name", cfg.Feed)
+	}
+
+	if cfg.PrivateGroup.Name != "" {
+		for _, member := range cfg.PrivateGroup.Members {
+			if _, err := refs.ParseFeedRef(member); err != nil {
+				return fmt.Errorf("ValidateConfig: %s: private-group member %s is not a SSB identity: %w", cfg.Feed, member, err)
+			}
+		}
+
+		if len(cfg.Nostr.Relays) > 0 {
+			return fmt.Errorf("ValidateConfig: %s: posts of a private-group can't be mirrored to nostr", cfg.Feed)
+		}
+	}
+
+	if len(cfg.Recipients) > 0 {
+		if cfg.PrivateGroup.Name != "" {
+			return fmt.Errorf("ValidateConfig: %s: recipients can't be used together with private-group", cfg.Feed)
+		}
+
+		if len(cfg.Recipients) > MaxRecipients {
+			return fmt.Errorf("ValidateConfig: %s: %d recipients configured, at most %d are supported", cfg.Feed, len(cfg.Recipients), MaxRecipients)
+		}
+
+		for _, recipient := range cfg.Recipients {
+			if _, err := refs.ParseFeedRef(recipient); err != nil {
+				return fmt.Errorf("ValidateConfig: %s: recipient %s is not a SSB identity: %w", cfg.Feed, recipient, err)
+			}
+		}
+
+		if len(cfg.Nostr.Relays) > 0 {
+			return fmt.Errorf("ValidateConfig: %s: posts to recipients can't be mirrored to nostr", cfg.Feed)
+		}
+	}
+
+	if _, err := renderPost(*cfg, PostData{}); err != nil {
+		return fmt.Errorf("ValidateConfig: %s: %w", cfg.Feed, err)
+	}
+
+	if _, err := RenderIntroduction(*cfg, IntroData{}); err != nil {
+		return fmt.Errorf("ValidateConfig: %s: %w", cfg.Feed, err)
+	}
+
+	if err := validatePostProcess(cfg.PostProcess); err != nil {
+		return fmt.Errorf("ValidateConfig: %s: %w", cfg.Feed, err)
+	}
+
+	if err := validateSelectors(cfg.Conversion); err != nil {
+		return fmt.Errorf("ValidateConfig: %s: %w", cfg.Feed, err)
+	}
+
+	if err := validateLanguages(&cfg.Language); err != nil {
+		return fmt.Errorf("ValidateConfig: %s: %w", cfg.Feed, err)
+	}
+
+	if err := validateTranslate(&cfg.Translate); err != nil {
+		return fmt.Errorf("ValidateConfig: %s: %w", cfg.Feed, err)
+	}
+
+	if err := validateFilters(&cfg.Filters); err != nil {
+		return fmt.Errorf("ValidateConfig: %s: %w", cfg.Feed, err)
+	}
+
+	var patterns []string
+	for pattern := range cfg.ContentWarnings {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+
+	cfg.contentWarnings = nil
+	for _, pattern := range patterns {
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("ValidateConfig: %s: invalid content warning regular expression %s: %w", cfg.Feed, pattern, err)
+		}
+		cfg.contentWarnings = append(cfg.contentWarnings, contentWarning{compiled, cfg.ContentWarnings[pattern]})
+	}
+
+	return nil
+}
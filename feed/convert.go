@@ -0,0 +1,730 @@
+package feed
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	md "github.com/JohannesKaufmann/html-to-markdown"
+	"github.com/JohannesKaufmann/html-to-markdown/plugin"
+	"github.com/PuerkitoBio/goquery"
+	"github.com/andybalholm/cascadia"
+	"github.com/mmcdole/gofeed"
+	"golang.org/x/net/html"
+)
+
+// embedProvider is a well known iframe embed which can be turned into a
+// clearnet link.
+type embedProvider struct {
+	name    string
+	pattern *regexp.Regexp
+	link    string
+	oembed  string
+}
+
+// embedProviders are the iframe embeds which rss-butt-plug knows about. The
+// first submatch of the pattern is interpolated into the link.
+var embedProviders = []embedProvider{
+	{
+		name:    "YouTube",
+		pattern: regexp.MustCompile(`youtube(?:-nocookie)?\.com/embed/([^&?/]+)`),
+		link:    "https://www.youtube.com/watch?v=%s",
+		oembed:  "https://www.youtube.com/oembed",
+	},
+	{
+		name:    "Vimeo",
+		pattern: regexp.MustCompile(`player\.vimeo\.com/video/(\d+)`),
+		link:    "https://vimeo.com/%s",
+		oembed:  "https://vimeo.com/api/oembed.json",
+	},
+	{
+		name:    "Spotify",
+		pattern: regexp.MustCompile(`open\.spotify\.com/embed(?:-podcast)?/(\w+/\w+)`),
+		link:    "https://open.spotify.com/%s",
+		oembed:  "https://open.spotify.com/oembed",
+	},
+}
+
+// oEmbed is the subset of an oEmbed response which rss-butt-plug uses.
+type oEmbed struct {
+	Title        string `json:"title"`
+	ThumbnailURL string `json:"thumbnail_url"`
+}
+
+// getOEmbed retrieves oEmbed metadata for a link from a provider endpoint.
+func getOEmbed(ctx context.Context, endpoint, link string) (oEmbed, error) {
+	var embed oEmbed
+
+	request, err := http.NewRequestWithContext(ctx, "GET", endpoint+"?format=json&url="+url.QueryEscape(link), nil)
+	if err != nil {
+		return embed, fmt.Errorf("getOEmbed: unable to create request for %s: %w", link, err)
+	}
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return embed, fmt.Errorf("getOEmbed: unable to retrieve %s: %w", link, err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != 200 {
+		return embed, fmt.Errorf("getOEmbed: unable to retrieve %s: HTTP %d", link, response.StatusCode)
+	}
+
+	if err := json.NewDecoder(response.Body).Decode(&embed); err != nil {
+		return embed, fmt.Errorf("getOEmbed: unable to decode response for %s: %w", link, err)
+	}
+
+	return embed, nil
+}
+
+// embedMarkdown turns an iframe or embed element into a titled clearnet link.
+// When embed-thumbnails is enabled and blobs are posted, the thumbnail of
+// known embeds is uploaded as a blob and shown above the link.
+func embedMarkdown(ctx context.Context, selec *goquery.Selection, baseURL string, blobs BlobPutter, cfg Config, postBlobs bool) string {
+	src := selec.AttrOr("src", selec.AttrOr("data-src", ""))
+	if src == "" {
+		return ""
+	}
+
+	link, err := resolveURL(baseURL, src)
+	if err != nil {
+		return ""
+	}
+
+	title := strings.TrimSpace(selec.AttrOr("title", ""))
+	name := "Embedded content"
+
+	var thumbnail string
+	for _, provider := range embedProviders {
+		match := provider.pattern.FindStringSubmatch(link)
+		if match == nil {
+			continue
+		}
+
+		name = provider.name
+		link = fmt.Sprintf(provider.link, match[1])
+
+		if !postBlobs || !cfg.EmbedThumbnails || cfg.Images.BlobsPaused() {
+			break
+		}
+
+		embed, err := getOEmbed(ctx, provider.oembed, link)
+		if err != nil {
+			log.Printf("embedMarkdown: %s", err)
+			break
+		}
+
+		if title == "" {
+			title = embed.Title
+		}
+
+		if embed.ThumbnailURL != "" {
+			srcReader, err := GetImage(ctx, embed.ThumbnailURL, cfg)
+			if err != nil {
+				log.Printf("embedMarkdown: %s", err)
+				break
+			}
+
+			ref, err := PutBlob(ctx, blobs, srcReader, cfg)
+			if err != nil {
+				log.Printf("embedMarkdown: %s", err)
+				break
+			}
+
+			thumbnail = ref.String()
+		}
+
+		break
+	}
+
+	text := name
+	if title != "" {
+		text = fmt.Sprintf("%s (%s)", title, name)
+	}
+	text = strings.NewReplacer("[", "\\[", "]", "\\]").Replace(text)
+
+	if thumbnail != "" {
+		return fmt.Sprintf("\n\n[%s](%s)\n\n[%s](%s)\n\n", imageMarkdown(title, thumbnail, ""), link, text, link)
+	}
+
+	return fmt.Sprintf("\n\n[%s](%s)\n\n", text, link)
+}
+
+// preformattedTable renders a table as an aligned plain text block.
+func preformattedTable(selec *goquery.Selection) string {
+	var rows [][]string
+	var widths []int
+
+	selec.Find("tr").Each(func(_ int, row *goquery.Selection) {
+		var cells []string
+		row.Children().Each(func(idx int, cell *goquery.Selection) {
+			text := strings.Join(strings.Fields(cell.Text()), " ")
+			cells = append(cells, text)
+
+			if idx >= len(widths) {
+				widths = append(widths, 0)
+			}
+			if width := utf8.RuneCountInString(text); width > widths[idx] {
+				widths[idx] = width
+			}
+		})
+		rows = append(rows, cells)
+	})
+
+	var lines []string
+	for _, cells := range rows {
+		var line string
+		for idx, cell := range cells {
+			line += cell + strings.Repeat(" ", widths[idx]-utf8.RuneCountInString(cell)+2)
+		}
+		lines = append(lines, strings.TrimRight(line, " "))
+	}
+
+	return "\n\n```\n" + strings.Join(lines, "\n") + "\n```\n\n"
+}
+
+// validateSelectors checks that the CSS selectors of the conversion rules can
+// be compiled, goquery panics on invalid selectors otherwise.
+func validateSelectors(rules ConversionRules) error {
+	for _, selector := range append(rules.Remove, rules.Unwrap...) {
+		if _, err := cascadia.Compile(selector); err != nil {
+			return fmt.Errorf("validateSelectors: invalid selector %s: %w", selector, err)
+		}
+	}
+
+	return nil
+}
+
+// applyConversionRules removes and unwraps the elements matched by the
+// configured CSS selectors.
+func applyConversionRules(selec *goquery.Selection, rules ConversionRules) {
+	for _, selector := range rules.Remove {
+		selec.Find(selector).Remove()
+	}
+
+	for _, selector := range rules.Unwrap {
+		selec.Find(selector).Each(func(_ int, s *goquery.Selection) {
+			if s.Contents().Length() == 0 {
+				s.Remove()
+				return
+			}
+			s.Contents().Unwrap()
+		})
+	}
+}
+
+// maxContentLength is the length (in bytes) of the HTML content of an item
+// above which it is cut off before the conversion.
+const maxContentLength = 1024 * 1024
+
+// unsafeElements are the elements which never carry readable content and are
+// removed before the conversion.
+const unsafeElements = "script, style, link, meta, template, [hidden]"
+
+// hiddenStyleRegex matches inline styles which hide an element.
+var hiddenStyleRegex = regexp.MustCompile(`(?i)(display\s*:\s*none|visibility\s*:\s*hidden)`)
+
+// isTrackingPixel reports whether an img element is sized to be invisible,
+// e.g. 1x1 pixel tracking images.
+func isTrackingPixel(selec *goquery.Selection) bool {
+	width, widthErr := strconv.Atoi(strings.TrimSuffix(selec.AttrOr("width", ""), "px"))
+	height, heightErr := strconv.Atoi(strings.TrimSuffix(selec.AttrOr("height", ""), "px"))
+
+	if (widthErr == nil && width == 0) || (heightErr == nil && height == 0) {
+		return true
+	}
+
+	return widthErr == nil && heightErr == nil && width <= 1 && height <= 1
+}
+
+// sanitizeHTML removes scripts, styles, hidden elements and tracking pixels
+// and strips event handler attributes and javascript: links, so that hostile
+// or sloppy feeds can't sneak junk into posts.
+func sanitizeHTML(selec *goquery.Selection) {
+	selec.Find(unsafeElements).Remove()
+
+	selec.Find("[style]").Each(func(_ int, s *goquery.Selection) {
+		if hiddenStyleRegex.MatchString(s.AttrOr("style", "")) {
+			s.Remove()
+		}
+	})
+
+	selec.Find("img").Each(func(_ int, s *goquery.Selection) {
+		if isTrackingPixel(s) {
+			s.Remove()
+		}
+	})
+
+	selec.Find("*").Each(func(_ int, s *goquery.Selection) {
+		for _, node := range s.Nodes {
+			var attrs []html.Attribute
+			for _, attr := range node.Attr {
+				key := strings.ToLower(attr.Key)
+				if strings.HasPrefix(key, "on") {
+					continue
+				}
+
+				value := strings.ToLower(strings.TrimSpace(attr.Val))
+				if (key == "href" || key == "src") && strings.HasPrefix(value, "javascript:") {
+					continue
+				}
+
+				attrs = append(attrs, attr)
+			}
+			node.Attr = attrs
+		}
+	})
+}
+
+// prepareHTML sanitizes HTML content and applies the conversion rules.
+func prepareHTML(selec *goquery.Selection, cfg Config) {
+	sanitizeHTML(selec)
+	applyConversionRules(selec, cfg.Conversion)
+}
+
+// limitContent cuts HTML content off at maxContentLength.
+func limitContent(content string) string {
+	if len(content) <= maxContentLength {
+		return content
+	}
+
+	log.Printf("limitContent: cutting off content of %d bytes at %d bytes", len(content), maxContentLength)
+
+	end := maxContentLength
+	for end > 0 && !utf8.RuneStart(content[end]) {
+		end--
+	}
+
+	return content[:end]
+}
+
+// trackerDomains are well known hosts of tracking pixels, their images are
+// always skipped.
+var trackerDomains = []string{
+	"pixel.wp.com",
+	"stats.wordpress.com",
+	"feeds.feedburner.com",
+	"feedproxy.google.com",
+	"google-analytics.com",
+	"doubleclick.net",
+	"pixel.quantserve.com",
+	"scorecardresearch.com",
+	"pixel.mathtag.com",
+	"px.ads.linkedin.com",
+	"ct.pinterest.com",
+	"bat.bing.com",
+	"analytics.twitter.com",
+}
+
+// imageBlocked reports whether an image URL is hosted on one of the blocked
+// image domains or tracker domains (or one of their subdomains).
+func imageBlocked(src string, rules ConversionRules) bool {
+	imageURL, err := url.Parse(src)
+	if err != nil {
+		return false
+	}
+
+	host := strings.ToLower(imageURL.Hostname())
+	for _, domain := range append(rules.BlockedImageDomains, trackerDomains...) {
+		domain = strings.ToLower(domain)
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// imageSources returns the candidate sources of an img element (or the
+// poster of a video element) which are not on a blocked image domain.
+func imageSources(selec *goquery.Selection, baseURL string, cfg Config) []string {
+	sources := imageCandidates(selec)
+	if goquery.NodeName(selec) == "video" {
+		sources = nil
+		if poster := selec.AttrOr("poster", ""); poster != "" {
+			sources = []string{poster}
+		}
+	}
+
+	var candidates []string
+	for _, candidate := range sources {
+		resolved, err := resolveURL(baseURL, candidate)
+		if err != nil || imageBlocked(resolved, cfg.Conversion) {
+			continue
+		}
+		candidates = append(candidates, candidate)
+	}
+
+	return candidates
+}
+
+// storedImage is the blob ref of an image (or its clearnet URL when its
+// format is linked), or the error of storing it. skip is set when all
+// candidates are tracking images or skipped formats. original is the URL of
+// an animation of which only the first frame was stored.
+type storedImage struct {
+	ref      string
+	original string
+	skip     bool
+	err      error
+}
+
+// markdown renders a stored image, an animation is linked through its first
+// frame.
+func (s storedImage) markdown(alt, title string) string {
+	if s.original != "" {
+		return "[" + imageMarkdown(alt, s.ref, title) + "](" + s.original + ")"
+	}
+
+	return imageMarkdown(alt, s.ref, title)
+}
+
+// storeImage retrieves the first available candidate of an image and stores
+// it as a blob.
+func storeImage(ctx context.Context, candidates []string, baseURL string, blobs BlobPutter, cfg Config) storedImage {
+	var srcReader io.Reader
+	var src string
+	var err error
+	for _, src = range candidates {
+		srcReader, err = resolveImage(ctx, src, baseURL, cfg)
+		if err == nil {
+			break
+		}
+		log.Printf("storeImage: skipping image candidate: %s", err)
+	}
+	if errors.Is(err, errTrackingImage) || errors.Is(err, errSkippedImage) {
+		return storedImage{skip: true}
+	}
+	if errors.Is(err, errLinkedImage) {
+		link, err := resolveURL(baseURL, src)
+		if err != nil {
+			return storedImage{err: fmt.Errorf("storeImage: %w", err)}
+		}
+		return storedImage{ref: link}
+	}
+	if err != nil {
+		return storedImage{err: fmt.Errorf("storeImage: %w", err)}
+	}
+
+	var original string
+	if isStillImage(srcReader) {
+		if original, err = resolveURL(baseURL, src); err != nil {
+			return storedImage{err: fmt.Errorf("storeImage: %w", err)}
+		}
+		log.Printf("storeImage: storing the first frame of %s", original)
+	}
+
+	ref, err := PutBlob(ctx, blobs, srcReader, cfg)
+	if err != nil {
+		return storedImage{err: fmt.Errorf("storeImage: %w", err)}
+	}
+
+	log.Printf("storeImage: successfully posted %s as blob", src)
+
+	return storedImage{ref: ref.String(), original: original}
+}
+
+// videoMarkdown turns a video element into its poster image, linking the
+// video, or a plain link when it has no poster.
+func videoMarkdown(ctx context.Context, selec *goquery.Selection, baseURL string, blobs BlobPutter, cfg Config, stored map[string]storedImage, storeBlobs bool) string {
+	src := selec.AttrOr("src", "")
+	if src == "" {
+		src = selec.Find("source[src]").First().AttrOr("src", "")
+	}
+
+	var link string
+	if src != "" {
+		if resolved, err := resolveURL(baseURL, src); err == nil {
+			link = resolved
+		}
+	}
+
+	var poster string
+	if candidates := imageSources(selec, baseURL, cfg); len(candidates) > 0 {
+		if !storeBlobs {
+			poster, _ = resolveURL(baseURL, candidates[0])
+		} else {
+			image, ok := stored[strings.Join(candidates, "\n")]
+			if !ok {
+				image = storeImage(ctx, candidates, baseURL, blobs, cfg)
+			}
+			if image.err != nil {
+				log.Printf("videoMarkdown: leaving out poster: %s", image.err)
+			} else if !image.skip {
+				poster = image.ref
+			}
+		}
+	}
+
+	title := strings.TrimSpace(selec.AttrOr("title", ""))
+
+	switch {
+	case poster != "" && link != "":
+		return fmt.Sprintf("\n\n[%s](%s)\n\n", imageMarkdown(title, poster, ""), link)
+	case poster != "":
+		return fmt.Sprintf("\n\n%s\n\n", imageMarkdown(title, poster, ""))
+	case link != "":
+		text := "Video"
+		if title != "" {
+			text = strings.NewReplacer("[", "\\[", "]", "\\]").Replace(title)
+		}
+		return fmt.Sprintf("\n\n[%s](%s)\n\n", text, link)
+	}
+
+	return ""
+}
+
+// prefetchImages stores the images of HTML content as blobs, with at most
+// image-concurrency downloads at the same time. The results are keyed by the
+// newline-joined candidates of each image.
+func prefetchImages(ctx context.Context, content, baseURL string, blobs BlobPutter, cfg Config) map[string]storedImage {
+	stored := make(map[string]storedImage)
+
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(content))
+	if err != nil {
+		return stored
+	}
+
+	prepareHTML(doc.Selection, cfg)
+
+	var keys []string
+	pending := make(map[string][]string)
+	doc.Find("img, video").Each(func(_ int, selec *goquery.Selection) {
+		candidates := imageSources(selec, baseURL, cfg)
+		key := strings.Join(candidates, "\n")
+		if _, ok := pending[key]; ok || len(candidates) == 0 {
+			return
+		}
+		pending[key] = candidates
+		keys = append(keys, key)
+	})
+
+	concurrency := cfg.ImageConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, concurrency)
+	for _, key := range keys {
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func(key string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			image := storeImage(ctx, pending[key], baseURL, blobs, cfg)
+
+			mu.Lock()
+			stored[key] = image
+			mu.Unlock()
+		}(key)
+	}
+
+	wg.Wait()
+
+	return stored
+}
+
+// htmlToMarkdown converts HTML to Markdown. Image links are processed into
+// blob refs for SSB client readers, the images are fetched concurrently
+// before the conversion. Relative image links are resolved against baseURL.
+func htmlToMarkdown(ctx context.Context, content, baseURL string, blobs BlobPutter, cfg Config, postBlobs bool) (string, error) {
+	var markdown string
+
+	content = limitContent(content)
+	storeBlobs := postBlobs && !cfg.Images.BlobsPaused()
+
+	var stored map[string]storedImage
+	if storeBlobs {
+		stored = prefetchImages(ctx, content, baseURL, blobs, cfg)
+	}
+
+	converter := md.NewConverter("", true, nil)
+
+	converter.Use(plugin.Table())
+
+	converter.Before(func(selec *goquery.Selection) {
+		prepareHTML(selec, cfg)
+	})
+
+	converter.AddRules(
+		md.Rule{
+			Filter: []string{"img"},
+			Replacement: func(content string, selec *goquery.Selection, opt *md.Options) *string {
+				candidates := imageSources(selec, baseURL, cfg)
+				if len(candidates) == 0 {
+					return md.String("")
+				}
+
+				if !storeBlobs {
+					src, err := resolveURL(baseURL, candidates[0])
+					if err != nil {
+						return nil
+					}
+					return md.String(imageMarkdown(selec.AttrOr("alt", ""), src, selec.AttrOr("title", "")))
+				}
+
+				image, ok := stored[strings.Join(candidates, "\n")]
+				if !ok {
+					image = storeImage(ctx, candidates, baseURL, blobs, cfg)
+				}
+				if image.err != nil {
+					// a missing image doesn't hold back the post, it's linked
+					// on the clearnet instead
+					log.Printf("htmlToMarkdown: linking image instead: %s", image.err)
+					src, err := resolveURL(baseURL, candidates[0])
+					if err != nil {
+						return md.String("")
+					}
+					return md.String(imageMarkdown(selec.AttrOr("alt", ""), src, selec.AttrOr("title", "")))
+				}
+
+				if image.skip {
+					return md.String("")
+				}
+
+				return md.String(image.markdown(selec.AttrOr("alt", ""), selec.AttrOr("title", "")))
+			},
+		},
+		md.Rule{
+			Filter: []string{"video"},
+			Replacement: func(content string, selec *goquery.Selection, opt *md.Options) *string {
+				return md.String(videoMarkdown(ctx, selec, baseURL, blobs, cfg, stored, storeBlobs))
+			},
+		},
+		md.Rule{
+			Filter: []string{"iframe", "embed"},
+			Replacement: func(content string, selec *goquery.Selection, opt *md.Options) *string {
+				return md.String(embedMarkdown(ctx, selec, baseURL, blobs, cfg, postBlobs))
+			},
+		},
+		md.Rule{
+			Filter: []string{"table"},
+			Replacement: func(content string, selec *goquery.Selection, opt *md.Options) *string {
+				for _, line := range strings.Split(content, "\n") {
+					if utf8.RuneCountInString(line) > maxTableWidth {
+						return md.String(preformattedTable(selec))
+					}
+				}
+
+				// fall back to the GitHub-flavored markdown table plugin
+				return nil
+			},
+		},
+	)
+
+	markdown, err := converter.ConvertString(content)
+	if err != nil {
+		return markdown, fmt.Errorf("htmlToMarkdown: unable to convert html to markdown: %w", err)
+	}
+
+	return markdown, nil
+}
+
+// articleSelectors are well known containers of the main article content,
+// tried in order before falling back to scoring paragraphs.
+var articleSelectors = []string{
+	"[itemprop=articleBody]",
+	"article .entry-content",
+	"article .post-content",
+	".entry-content",
+	".post-content",
+	"article",
+	"main",
+}
+
+// extractArticle is a (very) simple readability-style extractor. It looks for
+// well known article containers and otherwise picks the element with the most
+// paragraph text.
+func extractArticle(page io.Reader) (string, error) {
+	doc, err := goquery.NewDocumentFromReader(page)
+	if err != nil {
+		return "", fmt.Errorf("extractArticle: unable to parse HTML: %w", err)
+	}
+
+	doc.Find("script, style, noscript, nav, header, footer, aside, form").Remove()
+
+	for _, selector := range articleSelectors {
+		if found := doc.Find(selector).First(); found.Length() > 0 && strings.TrimSpace(found.Text()) != "" {
+			return found.Html()
+		}
+	}
+
+	var best *goquery.Selection
+	var bestScore int
+	doc.Find("p").Each(func(_ int, p *goquery.Selection) {
+		parent := p.Parent()
+
+		score := 0
+		parent.ChildrenFiltered("p").Each(func(_ int, sibling *goquery.Selection) {
+			score += len(strings.TrimSpace(sibling.Text()))
+		})
+
+		if score > bestScore {
+			best, bestScore = parent, score
+		}
+	})
+
+	if best == nil {
+		return "", fmt.Errorf("extractArticle: unable to find article content")
+	}
+
+	return best.Html()
+}
+
+// fetchFullText retrieves an item link and extracts the article HTML.
+func fetchFullText(ctx context.Context, link string, cfg Config) (string, error) {
+	request, err := http.NewRequestWithContext(ctx, "GET", link, nil)
+	if err != nil {
+		return "", fmt.Errorf("fetchFullText: unable to create request for %s: %w", link, err)
+	}
+
+	response, err := httpClient(cfg).Do(request)
+	if err != nil {
+		return "", fmt.Errorf("fetchFullText: unable to retrieve %s: %w", link, err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != 200 {
+		return "", fmt.Errorf("fetchFullText: unable to retrieve %s: HTTP %d", link, response.StatusCode)
+	}
+
+	article, err := extractArticle(response.Body)
+	if err != nil {
+		return "", fmt.Errorf("fetchFullText: %s: %w", link, err)
+	}
+
+	return article, nil
+}
+
+// itemContent retrieves the HTML content of a RSS item. The full text is
+// fetched from the item link when fetch-full-text is enabled, otherwise (or
+// when that fails) the content or description of the item is used.
+func itemContent(ctx context.Context, item *gofeed.Item, cfg Config) string {
+	if cfg.FetchFullText && item.Link != "" {
+		article, err := fetchFullText(ctx, item.Link, cfg)
+		if err == nil {
+			return article
+		}
+		log.Printf("itemContent: falling back to feed content: %s", err)
+	}
+
+	if item.Content == "" {
+		return item.Description
+	}
+
+	return item.Content
+}
@@ -0,0 +1,565 @@
+package feed
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/mmcdole/gofeed"
+	"github.com/mmcdole/gofeed/atom"
+	jsonfeed "github.com/mmcdole/gofeed/json"
+	"github.com/mmcdole/gofeed/rss"
+)
+
+// rssHintsTranslator translates RSS feeds like the default gofeed translator
+// but carries the ttl, skipHours and skipDays hints over into the Custom
+// fields of the feed, which are otherwise lost.
+type rssHintsTranslator struct {
+	gofeed.DefaultRSSTranslator
+}
+
+// Translate converts a rss.Feed into a gofeed.Feed.
+func (t *rssHintsTranslator) Translate(feed interface{}) (*gofeed.Feed, error) {
+	translated, err := t.DefaultRSSTranslator.Translate(feed)
+	if err != nil {
+		return nil, err
+	}
+
+	rssFeed, ok := feed.(*rss.Feed)
+	if !ok {
+		return translated, nil
+	}
+
+	if translated.Custom == nil {
+		translated.Custom = make(map[string]string)
+	}
+
+	translated.Custom["ttl"] = strings.TrimSpace(rssFeed.TTL)
+	translated.Custom["skipHours"] = strings.Join(rssFeed.SkipHours, ",")
+	translated.Custom["skipDays"] = strings.Join(rssFeed.SkipDays, ",")
+
+	return translated, nil
+}
+
+// atomAuthorTranslator translates Atom feeds like the default gofeed
+// translator but carries the homepage (uri) of the entry author, or else the
+// feed author, over into the "authorURI" Custom field of the items.
+type atomAuthorTranslator struct {
+	gofeed.DefaultAtomTranslator
+}
+
+// Translate converts an atom.Feed into a gofeed.Feed.
+func (t *atomAuthorTranslator) Translate(feed interface{}) (*gofeed.Feed, error) {
+	translated, err := t.DefaultAtomTranslator.Translate(feed)
+	if err != nil {
+		return nil, err
+	}
+
+	atomFeed, ok := feed.(*atom.Feed)
+	if !ok || len(atomFeed.Entries) != len(translated.Items) {
+		return translated, nil
+	}
+
+	for idx, entry := range atomFeed.Entries {
+		authors := entry.Authors
+		if len(authors) == 0 {
+			authors = atomFeed.Authors
+		}
+
+		for _, author := range authors {
+			if author != nil && author.URI != "" {
+				setCustom(translated.Items[idx], "authorURI", author.URI)
+				break
+			}
+		}
+	}
+
+	return translated, nil
+}
+
+// setCustom sets a Custom field of an item.
+func setCustom(item *gofeed.Item, key, value string) {
+	if item.Custom == nil {
+		item.Custom = make(map[string]string)
+	}
+
+	item.Custom[key] = value
+}
+
+// jsonFeedTranslator translates JSON Feeds like the default gofeed translator
+// but carries over what would otherwise be lost: external_url links,
+// banner_image headers, attachments, plain text content and the feed author.
+type jsonFeedTranslator struct {
+	gofeed.DefaultJSONTranslator
+}
+
+// Translate translates a JSON Feed into a gofeed.Feed.
+func (t *jsonFeedTranslator) Translate(feed interface{}) (*gofeed.Feed, error) {
+	translated, err := t.DefaultJSONTranslator.Translate(feed)
+	if err != nil {
+		return nil, err
+	}
+
+	jsonFeed, ok := feed.(*jsonfeed.Feed)
+	if !ok || len(jsonFeed.Items) != len(translated.Items) {
+		return translated, nil
+	}
+
+	for idx, jsonItem := range jsonFeed.Items {
+		item := translated.Items[idx]
+
+		var before, after strings.Builder
+
+		if jsonItem.ContentHTML == "" && jsonItem.ContentText != "" {
+			item.Content = textToHTML(jsonItem.ContentText)
+		}
+
+		if jsonItem.ExternalURL != "" {
+			if item.Link == "" {
+				item.Link = jsonItem.ExternalURL
+			} else {
+				externalURL := html.EscapeString(jsonItem.ExternalURL)
+				before.WriteString(`<p>&rarr; <a href="` + externalURL + `">` + externalURL + `</a></p>`)
+			}
+		}
+
+		item.Image = nil
+		if jsonItem.BannerImage != "" {
+			item.Image = &gofeed.Image{URL: jsonItem.BannerImage}
+		} else if jsonItem.Image != "" && !strings.Contains(item.Content, jsonItem.Image) {
+			item.Image = &gofeed.Image{URL: jsonItem.Image}
+		}
+
+		if jsonItem.Attachments != nil {
+			item.Enclosures = nil
+			for _, attachment := range *jsonItem.Attachments {
+				item.Enclosures = append(item.Enclosures, &gofeed.Enclosure{
+					URL:    attachment.URL,
+					Type:   attachment.MimeType,
+					Length: strconv.FormatInt(attachment.SizeInBytes, 10),
+				})
+
+				title := attachment.Title
+				if title == "" {
+					title = path.Base(attachment.URL)
+				}
+
+				attachmentURL := html.EscapeString(attachment.URL)
+				if strings.HasPrefix(attachment.MimeType, "image/") {
+					after.WriteString(`<p><img src="` + attachmentURL + `" alt="` + html.EscapeString(title) + `"></p>`)
+				} else {
+					after.WriteString(`<p>&#128206; <a href="` + attachmentURL + `">` + html.EscapeString(title) + `</a> (` + html.EscapeString(attachment.MimeType) + `)</p>`)
+				}
+			}
+		}
+
+		if before.Len() > 0 || after.Len() > 0 {
+			content := item.Content
+			if content == "" {
+				content = html.EscapeString(item.Description)
+			}
+			item.Content = before.String() + content + after.String()
+		}
+
+		if item.Author == nil && translated.Author != nil {
+			item.Author = translated.Author
+			item.Authors = translated.Authors
+		}
+
+		author := jsonItem.Author
+		if author == nil {
+			author = jsonFeed.Author
+		}
+		if author != nil && author.URL != "" {
+			setCustom(item, "authorURI", author.URL)
+		}
+	}
+
+	return translated, nil
+}
+
+// textToHTML turns plain text into HTML paragraphs.
+func textToHTML(text string) string {
+	var paragraphs []string
+	for _, paragraph := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n\n") {
+		paragraph = strings.ReplaceAll(html.EscapeString(paragraph), "\n", "<br>")
+		paragraphs = append(paragraphs, "<p>"+paragraph+"</p>")
+	}
+
+	return strings.Join(paragraphs, "\n")
+}
+
+// headerTransport sets the configured User-Agent and headers of a feed on
+// every request. The credentials of a private feed are only sent to the host
+// of the feed.
+type headerTransport struct {
+	userAgent string
+	headers   map[string]string
+	authHost  string
+	auth      AuthConfig
+	base      http.RoundTripper
+}
+
+// RoundTrip executes a single HTTP transaction.
+func (t *headerTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	request = request.Clone(request.Context())
+
+	if t.userAgent != "" {
+		request.Header.Set("User-Agent", t.userAgent)
+	}
+
+	for key, value := range t.headers {
+		request.Header.Set(key, value)
+	}
+
+	if t.authHost != "" && strings.EqualFold(request.URL.Hostname(), t.authHost) {
+		if t.auth.Token != "" {
+			request.Header.Set("Authorization", "Bearer "+t.auth.Token)
+		} else if t.auth.Username != "" {
+			request.SetBasicAuth(t.auth.Username, t.auth.Password)
+		}
+	}
+
+	if t.base != nil {
+		return t.base.RoundTrip(request)
+	}
+
+	return http.DefaultTransport.RoundTrip(request)
+}
+
+// newHeaderTransport returns the transport which sends the configured
+// User-Agent, headers and credentials of a feed.
+func newHeaderTransport(cfg Config) *headerTransport {
+	transport := &headerTransport{userAgent: cfg.UserAgent, headers: cfg.Headers, auth: cfg.Auth}
+
+	if cfg.Auth.Token != "" || cfg.Auth.Username != "" {
+		if feedURL, err := url.Parse(cfg.Feed); err == nil {
+			transport.authHost = feedURL.Hostname()
+		}
+	}
+
+	return transport
+}
+
+// httpClient returns the HTTP client for the requests of a feed, which sends
+// the configured User-Agent, headers and credentials.
+func httpClient(cfg Config) *http.Client {
+	transport := newHeaderTransport(cfg)
+
+	if transport.userAgent == "" && len(transport.headers) == 0 && transport.authHost == "" {
+		return http.DefaultClient
+	}
+
+	return &http.Client{Transport: transport}
+}
+
+// notFeedError is the error of a source which was retrieved, but which isn't
+// a (valid) feed.
+type notFeedError struct {
+	err error
+}
+
+func (e notFeedError) Error() string {
+	return e.err.Error()
+}
+
+func (e notFeedError) Unwrap() error {
+	return e.err
+}
+
+// classifyParseError marks the errors of a parse which aren't network or HTTP
+// errors as notFeedError.
+func classifyParseError(ctx context.Context, err error) error {
+	var httpErr gofeed.HTTPError
+	var urlErr *url.Error
+	if errors.As(err, &httpErr) || errors.As(err, &urlErr) || ctx.Err() != nil {
+		return err
+	}
+
+	return notFeedError{err}
+}
+
+// IsDeadError reports whether a feed failed with an error which suggests that
+// it's gone: 404 Not Found, 410 Gone or a response which isn't a feed (any
+// more). Network errors and other HTTP errors are considered temporary.
+func IsDeadError(err error) bool {
+	var httpErr gofeed.HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.StatusCode == http.StatusNotFound || httpErr.StatusCode == http.StatusGone
+	}
+
+	var notFeed notFeedError
+	return errors.As(err, &notFeed)
+}
+
+// maxRedirects is the number of redirects which are followed, like the
+// default of net/http.
+const maxRedirects = 10
+
+// Parse parses an entire RSS feed into memory. The request is cancelled with
+// ctx. When the feed URL redirects permanently (301 or 308), the URL which the
+// permanent redirects lead to is the "permanentRedirect" Custom field of the
+// feed.
+func Parse(ctx context.Context, url string, cfg Config) (gofeed.Feed, error) {
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	defer cancel()
+
+	var moved string
+	permanent := true
+
+	client := *httpClient(cfg)
+	client.CheckRedirect = func(request *http.Request, via []*http.Request) error {
+		if len(via) >= maxRedirects {
+			return fmt.Errorf("stopped after %d redirects", maxRedirects)
+		}
+
+		status := request.Response.StatusCode
+		if permanent && (status == http.StatusMovedPermanently || status == http.StatusPermanentRedirect) {
+			moved = request.URL.String()
+		} else {
+			permanent = false
+		}
+
+		return nil
+	}
+
+	feedParser := gofeed.NewParser()
+	feedParser.Client = &client
+	feedParser.RSSTranslator = &rssHintsTranslator{}
+	feedParser.AtomTranslator = &atomAuthorTranslator{}
+	feedParser.JSONTranslator = &jsonFeedTranslator{}
+	feed, err := feedParser.ParseURLWithContext(url, ctx)
+	if errors.Is(err, gofeed.ErrFeedTypeNotDetected) {
+		discovered, discoverErr := discoverFeed(ctx, &client, url)
+		if discoverErr != nil {
+			return gofeed.Feed{}, fmt.Errorf("unable to parse %s: %w", url, classifyParseError(ctx, discoverErr))
+		}
+
+		log.Printf("Parse: %s is not a feed, using discovered feed %s", url, discovered)
+
+		// a redirect of the page isn't one of the feed
+		moved, permanent = "", false
+		feed, err = feedParser.ParseURLWithContext(discovered, ctx)
+	}
+	if err != nil {
+		return gofeed.Feed{}, fmt.Errorf("unable to parse %s: %w", url, classifyParseError(ctx, err))
+	}
+
+	if moved != "" {
+		if feed.Custom == nil {
+			feed.Custom = make(map[string]string)
+		}
+		feed.Custom["permanentRedirect"] = moved
+	}
+
+	// the language of the feed is the fallback of the language detection
+	if feed.Language != "" {
+		for _, item := range feed.Items {
+			setCustom(item, "feedLanguage", feed.Language)
+		}
+	}
+
+	return *feed, nil
+}
+
+// Fetch retrieves the items of a feed from its source. A RSS feed is fetched
+// from url, which differs from the configured one once the feed moved.
+func Fetch(ctx context.Context, cfg Config, url string) (gofeed.Feed, error) {
+	switch cfg.Source {
+	case "imap":
+		return fetchIMAPFeed(cfg.IMAP)
+	case "activitypub":
+		return fetchActivityPubFeed(ctx, cfg.Feed, httpClient(cfg))
+	}
+
+	return Parse(ctx, url, cfg)
+}
+
+// feedLinkTypes are the feed MIME types which are discovered in web pages,
+// most preferred first.
+var feedLinkTypes = []string{
+	"application/rss+xml",
+	"application/atom+xml",
+	"application/feed+json",
+	"application/json",
+}
+
+// discoverFeed discovers the feed of a website from the <link
+// rel="alternate"> elements of a page. RSS is preferred over Atom and JSON
+// Feed, and comment feeds are only picked when nothing else is available.
+func discoverFeed(ctx context.Context, client *http.Client, pageURL string) (string, error) {
+	request, err := http.NewRequestWithContext(ctx, "GET", pageURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("discoverFeed: unable to create request for %s: %w", pageURL, err)
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return "", fmt.Errorf("discoverFeed: unable to retrieve %s: %w", pageURL, err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return "", fmt.Errorf("discoverFeed: unable to retrieve %s: %w", pageURL,
+			gofeed.HTTPError{StatusCode: response.StatusCode, Status: response.Status})
+	}
+
+	doc, err := goquery.NewDocumentFromReader(response.Body)
+	if err != nil {
+		return "", fmt.Errorf("discoverFeed: unable to parse %s: %w", pageURL, err)
+	}
+
+	var best string
+	bestScore := -1
+	doc.Find("link[rel][href]").Each(func(_ int, selec *goquery.Selection) {
+		rel, _ := selec.Attr("rel")
+		if !containsFold(strings.Fields(rel), "alternate") {
+			return
+		}
+
+		linkType, _ := selec.Attr("type")
+		linkType = strings.ToLower(strings.TrimSpace(strings.Split(linkType, ";")[0]))
+
+		score := -1
+		for idx, feedType := range feedLinkTypes {
+			if linkType == feedType {
+				score = idx
+			}
+		}
+		if score < 0 {
+			return
+		}
+
+		href, _ := selec.Attr("href")
+		title, _ := selec.Attr("title")
+		if strings.Contains(strings.ToLower(href+" "+title), "comment") {
+			score += len(feedLinkTypes)
+		}
+
+		resolved, err := resolveURL(pageURL, href)
+		if err != nil {
+			return
+		}
+
+		if bestScore < 0 || score < bestScore {
+			best, bestScore = resolved, score
+		}
+	})
+
+	if best == "" {
+		return "", fmt.Errorf("discoverFeed: no feed found in %s", pageURL)
+	}
+
+	return best, nil
+}
+
+// iconRels are the rel values of the <link> elements which point to the icon
+// of a website, most preferred (largest) first.
+var iconRels = []string{"apple-touch-icon", "apple-touch-icon-precomposed", "icon"}
+
+// SiteIcons returns the icon URLs of a website, most preferred first, from
+// the <link rel="apple-touch-icon"> and <link rel="icon"> elements of the
+// page. The /favicon.ico of the site is always the last one.
+func SiteIcons(ctx context.Context, pageURL string, cfg Config) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	defer cancel()
+
+	favicon, err := resolveURL(pageURL, "/favicon.ico")
+	if err != nil {
+		return nil, fmt.Errorf("SiteIcons: %w", err)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, "GET", pageURL, nil)
+	if err != nil {
+		return []string{favicon}, fmt.Errorf("SiteIcons: unable to create request for %s: %w", pageURL, err)
+	}
+
+	response, err := httpClient(cfg).Do(request)
+	if err != nil {
+		return []string{favicon}, fmt.Errorf("SiteIcons: unable to retrieve %s: %w", pageURL, err)
+	}
+	defer response.Body.Close()
+
+	doc, err := goquery.NewDocumentFromReader(response.Body)
+	if err != nil {
+		return []string{favicon}, fmt.Errorf("SiteIcons: unable to parse %s: %w", pageURL, err)
+	}
+
+	found := make([][]string, len(iconRels))
+	doc.Find("link[rel][href]").Each(func(_ int, selec *goquery.Selection) {
+		rel, _ := selec.Attr("rel")
+		rels := strings.Fields(strings.ToLower(rel))
+
+		for idx, iconRel := range iconRels {
+			if !containsFold(rels, iconRel) {
+				continue
+			}
+
+			href, _ := selec.Attr("href")
+			if resolved, err := resolveURL(pageURL, href); err == nil {
+				found[idx] = append(found[idx], resolved)
+			}
+			return
+		}
+	})
+
+	var icons []string
+	for _, urls := range found {
+		icons = append(icons, urls...)
+	}
+
+	return append(icons, favicon), nil
+}
+
+// ogImage returns the Open Graph image (<meta property="og:image">) of a
+// page, or an empty string when it has none.
+func ogImage(ctx context.Context, pageURL string, cfg Config) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, "GET", pageURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("ogImage: unable to create request for %s: %w", pageURL, err)
+	}
+
+	response, err := httpClient(cfg).Do(request)
+	if err != nil {
+		return "", fmt.Errorf("ogImage: unable to retrieve %s: %w", pageURL, err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != 200 {
+		return "", fmt.Errorf("ogImage: unable to retrieve %s: HTTP %d", pageURL, response.StatusCode)
+	}
+
+	doc, err := goquery.NewDocumentFromReader(io.LimitReader(response.Body, maxContentLength))
+	if err != nil {
+		return "", fmt.Errorf("ogImage: unable to parse %s: %w", pageURL, err)
+	}
+
+	for _, property := range []string{"og:image", "og:image:url", "og:image:secure_url"} {
+		content := strings.TrimSpace(doc.Find(fmt.Sprintf("meta[property='%s'][content]", property)).First().AttrOr("content", ""))
+		if content == "" {
+			continue
+		}
+
+		src, err := resolveURL(pageURL, content)
+		if err != nil {
+			return "", fmt.Errorf("ogImage: %w", err)
+		}
+
+		return src, nil
+	}
+
+	return "", nil
+}
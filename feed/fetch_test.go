@@ -0,0 +1,35 @@
+package feed
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDiscoverFeedHTTPError(t *testing.T) {
+	tests := []struct {
+		status int
+		dead   bool
+	}{
+		{http.StatusGone, true},
+		{http.StatusServiceUnavailable, false},
+	}
+
+	for _, test := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(test.status)
+		}))
+
+		_, err := discoverFeed(context.Background(), http.DefaultClient, server.URL)
+		server.Close()
+
+		if err == nil {
+			t.Fatalf("discoverFeed() with status %d succeeded", test.status)
+		}
+
+		if dead := IsDeadError(err); dead != test.dead {
+			t.Errorf("IsDeadError(%s) = %t, want %t", err, dead, test.dead)
+		}
+	}
+}
@@ -0,0 +1,672 @@
+package feed
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	refs "github.com/ssbc/go-ssb-refs"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// Images is the state of the image handling which outlives a single poll:
+// the on-disk image cache, the consecutive "404 Not Found" responses per
+// image URL and whether storing blobs is paused. The feeds share it through
+// their Config, a nil Images caches nothing and never pauses.
+type Images struct {
+	cacheDir    string
+	mu          sync.Mutex
+	missing     map[string]int
+	blobsPaused bool
+}
+
+// PauseBlobs pauses or resumes storing images as blobs, e.g. because the disk
+// quota is exceeded. Images are linked on the clearnet instead.
+func (i *Images) PauseBlobs(paused bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.blobsPaused = paused
+}
+
+// BlobsPaused reports whether storing images as blobs is paused.
+func (i *Images) BlobsPaused() bool {
+	if i == nil {
+		return false
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	return i.blobsPaused
+}
+
+// recordResponse counts a "404 Not Found" response for an image URL, any
+// other response resets its count.
+func (i *Images) recordResponse(url string, status int) {
+	if i == nil {
+		return
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if status != http.StatusNotFound {
+		delete(i.missing, url)
+		return
+	}
+
+	if i.missing == nil {
+		i.missing = make(map[string]int)
+	}
+	i.missing[url]++
+}
+
+// TakeMissingImages returns the image URLs which were not found at least
+// count times in a row and resets their count.
+func (i *Images) TakeMissingImages(count int) []string {
+	if i == nil {
+		return nil
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	var urls []string
+	for imageURL, missed := range i.missing {
+		if missed >= count {
+			urls = append(urls, imageURL)
+			delete(i.missing, imageURL)
+		}
+	}
+
+	sort.Strings(urls)
+
+	return urls
+}
+
+// imageTransports are the transports of image downloads, one per connect
+// timeout, which are shared by all feeds so that connections are reused.
+var (
+	imageTransports   = make(map[time.Duration]*http.Transport)
+	imageTransportsMu sync.Mutex
+)
+
+// imageTransport returns the shared transport for image downloads which
+// gives up connecting (and the TLS handshake) after connectTimeout.
+func imageTransport(connectTimeout time.Duration) *http.Transport {
+	imageTransportsMu.Lock()
+	defer imageTransportsMu.Unlock()
+
+	if transport, ok := imageTransports[connectTimeout]; ok {
+		return transport
+	}
+
+	dialer := &net.Dialer{Timeout: connectTimeout, KeepAlive: 30 * time.Second}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	transport.TLSHandshakeTimeout = connectTimeout
+	imageTransports[connectTimeout] = transport
+
+	return transport
+}
+
+// imageClient returns the HTTP client for image downloads of a feed, which
+// gives up after image-timeout and sends the User-Agent, headers and
+// credentials of the feed.
+func imageClient(cfg Config) *http.Client {
+	transport := newHeaderTransport(cfg)
+	transport.base = imageTransport(cfg.ImageConnectTimeout)
+
+	return &http.Client{Transport: transport, Timeout: cfg.ImageTimeout}
+}
+
+// errTrackingImage is returned for images which are hosted by a tracker or
+// too small to be seen, they are skipped instead of stored as blobs.
+var errTrackingImage = errors.New("tracking image")
+
+// isTinyImage reports whether an image is empty or at most 1x1 pixels.
+// Images which can't be decoded (e.g. SVG) are not considered tiny.
+func isTinyImage(body []byte) bool {
+	if len(body) == 0 {
+		return true
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(body))
+	if err != nil {
+		return false
+	}
+
+	return config.Width <= 1 && config.Height <= 1
+}
+
+// imageRetryBackoff is the wait before the second attempt of an image
+// download or blob upload, it doubles with every further attempt.
+const imageRetryBackoff = time.Second
+
+// retry calls fn until it succeeds, reports its error as permanent or
+// image-attempts are used up, with an exponential backoff in between.
+func retry(ctx context.Context, cfg Config, fn func() (bool, error)) error {
+	backoff := imageRetryBackoff
+	for attempt := 1; ; attempt++ {
+		transient, err := fn()
+		if err == nil || !transient || attempt >= cfg.ImageAttempts {
+			return err
+		}
+
+		log.Printf("retry: attempt %d of %d failed, retrying in %s: %s", attempt, cfg.ImageAttempts, backoff, err)
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+
+		backoff *= 2
+	}
+}
+
+// GetImage retrieves an image from the internet with the User-Agent and
+// headers of a feed. Images larger than max-blob-size are refused and the
+// download is cancelled with ctx. Network errors and server errors are
+// retried. The image-policies are applied to the retrieved image.
+func GetImage(ctx context.Context, url string, cfg Config) (io.Reader, error) {
+	var body io.Reader
+	err := retry(ctx, cfg, func() (bool, error) {
+		var transient bool
+		var err error
+		body, transient, err = getImage(ctx, url, cfg)
+		return transient, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	filtered, err := applyImagePolicy(body, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("GetImage: %s: %w", url, err)
+	}
+
+	return filtered, nil
+}
+
+// getImage makes a single attempt at retrieving an image and reports whether
+// a failure is transient. A cached copy is used when the server reports that
+// the image didn't change.
+func getImage(ctx context.Context, url string, cfg Config) (io.Reader, bool, error) {
+	maxSize := cfg.MaxBlobSize
+
+	if imageBlocked(url, ConversionRules{}) {
+		return nil, false, fmt.Errorf("GetImage: %s is hosted by a tracker: %w", url, errTrackingImage)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, false, fmt.Errorf("GetImage: unable to create request for %s: %w", url, err)
+	}
+
+	cached, cachedBody, isCached := cfg.Images.loadCachedImage(url)
+	if isCached {
+		if cached.ETag != "" {
+			request.Header.Set("If-None-Match", cached.ETag)
+		}
+		if cached.LastModified != "" {
+			request.Header.Set("If-Modified-Since", cached.LastModified)
+		}
+	}
+
+	response, err := imageClient(cfg).Do(request)
+	if err != nil {
+		return nil, ctx.Err() == nil, fmt.Errorf("GetImage: unable to retrieve %s: %w", url, err)
+	}
+	defer response.Body.Close()
+
+	cfg.Images.recordResponse(url, response.StatusCode)
+
+	if isCached && response.StatusCode == http.StatusNotModified {
+		cfg.Images.touchCachedImage(url)
+		return bytes.NewReader(cachedBody), false, nil
+	}
+
+	if response.StatusCode != 200 {
+		transient := response.StatusCode >= 500 || response.StatusCode == http.StatusTooManyRequests
+		return nil, transient, fmt.Errorf("GetImage: unable to retrieve %s: HTTP %d", url, response.StatusCode)
+	}
+
+	isGIF := strings.Contains(response.Header.Get("Content-Type"), "gif")
+	if response.ContentLength > maxSize && !isGIF {
+		return nil, false, fmt.Errorf("GetImage: %s is too large (%d > %d bytes)", url, response.ContentLength, maxSize)
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(response.Body, maxSize+1))
+	if err != nil {
+		return nil, ctx.Err() == nil, fmt.Errorf("GetImage: unable to read response body: %w", err)
+	}
+
+	if int64(len(body)) > maxSize {
+		// the first frame of a large GIF is used instead, it's at the start
+		if bytes.HasPrefix(body, []byte("GIF8")) {
+			if still, err := gifStill(body); err == nil {
+				return still, false, nil
+			}
+		}
+		return nil, false, fmt.Errorf("GetImage: %s is too large (> %d bytes)", url, maxSize)
+	}
+
+	if isTinyImage(body) {
+		return nil, false, fmt.Errorf("GetImage: %s is empty or 1x1 pixels: %w", url, errTrackingImage)
+	}
+
+	cfg.Images.storeCachedImage(cachedImage{
+		URL:          url,
+		ETag:         response.Header.Get("ETag"),
+		LastModified: response.Header.Get("Last-Modified"),
+	}, body)
+
+	return bytes.NewReader(body), false, nil
+}
+
+// resolveURL resolves a relative or protocol-relative URL against a base URL,
+// e.g. the RSS item link or the feed site link.
+func resolveURL(base, ref string) (string, error) {
+	refURL, err := url.Parse(strings.TrimSpace(ref))
+	if err != nil {
+		return "", fmt.Errorf("resolveURL: unable to parse %s: %w", ref, err)
+	}
+
+	if refURL.IsAbs() || base == "" {
+		return refURL.String(), nil
+	}
+
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return "", fmt.Errorf("resolveURL: unable to parse %s: %w", base, err)
+	}
+
+	return baseURL.ResolveReference(refURL).String(), nil
+}
+
+// decodeDataURI decodes the payload of a data: URI, e.g.
+// "data:image/png;base64,iVBORw0KGgo...".
+func decodeDataURI(uri string) (io.Reader, error) {
+	header, data, found := strings.Cut(strings.TrimPrefix(uri, "data:"), ",")
+	if !found {
+		return nil, fmt.Errorf("decodeDataURI: missing ',' separator in data URI")
+	}
+
+	if strings.HasSuffix(header, ";base64") {
+		decoded, err := base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			return nil, fmt.Errorf("decodeDataURI: unable to decode base64 payload: %w", err)
+		}
+		return bytes.NewReader(decoded), nil
+	}
+
+	decoded, err := url.PathUnescape(data)
+	if err != nil {
+		return nil, fmt.Errorf("decodeDataURI: unable to unescape payload: %w", err)
+	}
+
+	return strings.NewReader(decoded), nil
+}
+
+// resolveImage retrieves an image which may be referenced by a relative,
+// protocol-relative or absolute URL or embedded directly as a data: URI.
+func resolveImage(ctx context.Context, src, base string, cfg Config) (io.Reader, error) {
+	if strings.HasPrefix(src, "data:") {
+		decoded, err := decodeDataURI(src)
+		if err != nil {
+			return nil, fmt.Errorf("resolveImage: %w", err)
+		}
+
+		filtered, err := applyImagePolicy(decoded, cfg)
+		if errors.Is(err, errLinkedImage) {
+			// there's nothing to link for embedded images
+			return nil, fmt.Errorf("resolveImage: %w", errSkippedImage)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("resolveImage: %w", err)
+		}
+
+		return filtered, nil
+	}
+
+	imageURL, err := resolveURL(base, src)
+	if err != nil {
+		return nil, fmt.Errorf("resolveImage: %w", err)
+	}
+
+	return GetImage(ctx, imageURL, cfg)
+}
+
+// lazyLoadAttrs are img attributes commonly used by lazy-loading scripts to
+// hold the real image URL while src points at a placeholder.
+var lazyLoadAttrs = []string{"data-src", "data-lazy-src", "data-original"}
+
+// parseSrcset parses a srcset attribute value into its candidate URLs, ordered
+// from the highest to the lowest resolution.
+func parseSrcset(srcset string) []string {
+	type candidate struct {
+		url   string
+		width float64
+	}
+
+	var candidates []candidate
+	for _, entry := range strings.Split(srcset, ",") {
+		fields := strings.Fields(entry)
+		if len(fields) == 0 {
+			continue
+		}
+
+		width := 1.0
+		if len(fields) > 1 {
+			descriptor := strings.TrimRight(fields[1], "wx")
+			if parsed, err := strconv.ParseFloat(descriptor, 64); err == nil {
+				width = parsed
+			}
+		}
+
+		candidates = append(candidates, candidate{url: fields[0], width: width})
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].width > candidates[j].width
+	})
+
+	var urls []string
+	for _, c := range candidates {
+		urls = append(urls, c.url)
+	}
+
+	return urls
+}
+
+// imageCandidates gathers the possible sources of an img element in order of
+// preference: lazy-load attributes, srcset candidates and finally src.
+func imageCandidates(selec *goquery.Selection) []string {
+	var candidates []string
+
+	for _, attr := range lazyLoadAttrs {
+		if src, ok := selec.Attr(attr); ok && src != "" {
+			candidates = append(candidates, src)
+		}
+	}
+
+	for _, attr := range []string{"data-srcset", "srcset"} {
+		if srcset, ok := selec.Attr(attr); ok {
+			candidates = append(candidates, parseSrcset(srcset)...)
+		}
+	}
+
+	if src, ok := selec.Attr("src"); ok && src != "" {
+		candidates = append(candidates, src)
+	}
+
+	return candidates
+}
+
+// processImage downscales and recompresses an image according to the
+// image-max-width, image-max-height and image-quality config options. Images
+// which can't be decoded and animated GIFs are passed through untouched.
+// Transparent images are encoded as PNG, all others as JPEG (there is no pure
+// Go WebP encoder, so WebP images are recompressed as JPEG or PNG).
+func processImage(src io.Reader, cfg Config) (io.Reader, error) {
+	if cfg.ImageMaxWidth == 0 && cfg.ImageMaxHeight == 0 && cfg.ImageQuality == 0 {
+		return src, nil
+	}
+
+	original, err := ioutil.ReadAll(src)
+	if err != nil {
+		return nil, fmt.Errorf("processImage: unable to read image: %w", err)
+	}
+
+	// only the first frame of an animation would be kept
+	if bytes.HasPrefix(original, []byte("GIF8")) && isAnimatedGIF(original) {
+		return bytes.NewReader(original), nil
+	}
+
+	img, format, err := image.Decode(bytes.NewReader(original))
+	if err != nil {
+		log.Printf("processImage: leaving image untouched, unable to decode: %s", err)
+		return bytes.NewReader(original), nil
+	}
+
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	scale := 1.0
+	if cfg.ImageMaxWidth > 0 && width > cfg.ImageMaxWidth {
+		scale = float64(cfg.ImageMaxWidth) / float64(width)
+	}
+	if cfg.ImageMaxHeight > 0 && height > cfg.ImageMaxHeight {
+		if heightScale := float64(cfg.ImageMaxHeight) / float64(height); heightScale < scale {
+			scale = heightScale
+		}
+	}
+
+	resized := scale < 1.0
+	if resized {
+		dst := image.NewRGBA(image.Rect(0, 0, int(float64(width)*scale), int(float64(height)*scale)))
+		draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Over, nil)
+		img = dst
+	}
+
+	quality := cfg.ImageQuality
+	if quality == 0 {
+		quality = defaultImageQuality
+	}
+
+	transparent := format == "png" || format == "gif"
+	if opaque, ok := img.(interface{ Opaque() bool }); ok && !opaque.Opaque() {
+		transparent = true
+	}
+
+	var buf bytes.Buffer
+	if transparent {
+		err = png.Encode(&buf, img)
+	} else {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("processImage: unable to encode image: %w", err)
+	}
+
+	if !resized && buf.Len() >= len(original) {
+		return bytes.NewReader(original), nil
+	}
+
+	log.Printf("processImage: reduced %s image from %d to %d bytes", format, len(original), buf.Len())
+
+	return &buf, nil
+}
+
+// jpegMetadataMarkers are the JPEG segments which carry EXIF, XMP and IPTC
+// metadata (APP1 and APP13).
+var jpegMetadataMarkers = map[byte]bool{0xE1: true, 0xED: true}
+
+// pngMetadataChunks are the PNG chunks which carry EXIF and textual metadata.
+var pngMetadataChunks = map[string]bool{"eXIf": true, "tEXt": true, "zTXt": true, "iTXt": true}
+
+// stripJPEGMetadata removes metadata segments from a JPEG image without
+// re-encoding it. Malformed images are returned as is.
+func stripJPEGMetadata(data []byte) []byte {
+	stripped := []byte{0xFF, 0xD8}
+
+	idx := 2
+	for idx+4 <= len(data) {
+		if data[idx] != 0xFF {
+			return data
+		}
+
+		marker := data[idx+1]
+		if marker == 0xDA {
+			// start of scan, the compressed image data follows
+			return append(stripped, data[idx:]...)
+		}
+
+		length := int(binary.BigEndian.Uint16(data[idx+2 : idx+4]))
+		end := idx + 2 + length
+		if end > len(data) {
+			return data
+		}
+
+		if !jpegMetadataMarkers[marker] {
+			stripped = append(stripped, data[idx:end]...)
+		}
+
+		idx = end
+	}
+
+	return data
+}
+
+// stripPNGMetadata removes metadata chunks from a PNG image without
+// re-encoding it. Malformed images are returned as is.
+func stripPNGMetadata(data []byte) []byte {
+	stripped := append([]byte{}, data[:8]...)
+
+	idx := 8
+	for idx+12 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[idx : idx+4]))
+		chunkType := string(data[idx+4 : idx+8])
+
+		end := idx + 12 + length
+		if end > len(data) {
+			return data
+		}
+
+		if !pngMetadataChunks[chunkType] {
+			stripped = append(stripped, data[idx:end]...)
+		}
+
+		idx = end
+		if chunkType == "IEND" {
+			return stripped
+		}
+	}
+
+	return data
+}
+
+// stripImageMetadata scrubs EXIF (GPS positions, camera serials, etc.) and
+// other metadata from JPEG and PNG images before they are turned into
+// immutable public blobs.
+func stripImageMetadata(src io.Reader) (io.Reader, error) {
+	data, err := ioutil.ReadAll(src)
+	if err != nil {
+		return nil, fmt.Errorf("stripImageMetadata: unable to read image: %w", err)
+	}
+
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8}):
+		data = stripJPEGMetadata(data)
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		data = stripPNGMetadata(data)
+	}
+
+	return bytes.NewReader(data), nil
+}
+
+// BlobPutter stores blobs. The blob store of the sbot is one, so the
+// conversion of feed items doesn't depend on a running sbot.
+type BlobPutter interface {
+	Put(blob io.Reader) (refs.BlobRef, error)
+}
+
+// HashBlobs is a BlobPutter which only hashes blobs, which gives the refs
+// they would have without storing anything.
+type HashBlobs struct{}
+
+// Put hashes a blob.
+func (HashBlobs) Put(blob io.Reader) (refs.BlobRef, error) {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, blob); err != nil {
+		return refs.BlobRef{}, fmt.Errorf("Put: unable to hash blob: %w", err)
+	}
+
+	return refs.NewBlobRefFromBytes(hash.Sum(nil), refs.RefAlgoBlobSSB1)
+}
+
+// PutBlob processes an image and stores it in the blob store. Image metadata
+// is stripped unless keep-image-metadata is configured. Failed uploads are
+// retried.
+func PutBlob(ctx context.Context, blobs BlobPutter, src io.Reader, cfg Config) (refs.BlobRef, error) {
+	if !cfg.KeepImageMetadata {
+		var err error
+		src, err = stripImageMetadata(src)
+		if err != nil {
+			return refs.BlobRef{}, fmt.Errorf("PutBlob: %w", err)
+		}
+	}
+
+	processed, err := processImage(src, cfg)
+	if err != nil {
+		return refs.BlobRef{}, fmt.Errorf("PutBlob: %w", err)
+	}
+
+	blob, err := ioutil.ReadAll(processed)
+	if err != nil {
+		return refs.BlobRef{}, fmt.Errorf("PutBlob: unable to read image: %w", err)
+	}
+
+	ref, err := putBlob(ctx, blobs, blob, cfg)
+	if err != nil {
+		return refs.BlobRef{}, fmt.Errorf("PutBlob: %w", err)
+	}
+
+	return ref, nil
+}
+
+// putBlob stores a blob, retrying failed uploads.
+func putBlob(ctx context.Context, blobs BlobPutter, blob []byte, cfg Config) (refs.BlobRef, error) {
+	var ref refs.BlobRef
+	err := retry(ctx, cfg, func() (bool, error) {
+		var err error
+		ref, err = blobs.Put(bytes.NewReader(blob))
+		return true, err
+	})
+	if err != nil {
+		return refs.BlobRef{}, fmt.Errorf("putBlob: unable to upload blob: %w", err)
+	}
+
+	return ref, nil
+}
+
+// imageMarkdown renders a markdown image, carrying over the alt text and
+// title so that screen-reader users get accessible posts.
+func imageMarkdown(alt, src, title string) string {
+	alt = strings.NewReplacer("[", "\\[", "]", "\\]", "\n", " ").Replace(strings.TrimSpace(alt))
+
+	title = strings.TrimSpace(title)
+	if title == "" {
+		return "![" + alt + "](" + src + ")"
+	}
+
+	title = strings.NewReplacer(`"`, `\"`, "\n", " ").Replace(title)
+
+	return "![" + alt + "](" + src + ` "` + title + `")`
+}
@@ -0,0 +1,115 @@
+package feed
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/gif"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mmcdole/gofeed"
+)
+
+func TestTakeMissingImages(t *testing.T) {
+	images, err := NewImages("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for idx := 0; idx < 3; idx++ {
+		images.recordResponse("https://example.org/gone.png", http.StatusNotFound)
+	}
+	images.recordResponse("https://example.org/back.png", http.StatusNotFound)
+	images.recordResponse("https://example.org/back.png", http.StatusNotFound)
+	images.recordResponse("https://example.org/back.png", http.StatusOK)
+	images.recordResponse("https://example.org/back.png", http.StatusNotFound)
+
+	missing := images.TakeMissingImages(3)
+	if want := []string{"https://example.org/gone.png"}; !reflect.DeepEqual(missing, want) {
+		t.Errorf("TakeMissingImages(3) = %v, want %v", missing, want)
+	}
+
+	if missing := images.TakeMissingImages(3); len(missing) > 0 {
+		t.Errorf("TakeMissingImages(3) = %v after it was taken, want none", missing)
+	}
+}
+
+func TestImagesAreSeparate(t *testing.T) {
+	first, _ := NewImages("")
+	second, _ := NewImages("")
+
+	first.PauseBlobs(true)
+	if !first.BlobsPaused() {
+		t.Error("BlobsPaused() = false after PauseBlobs(true)")
+	}
+	if second.BlobsPaused() {
+		t.Error("pausing the blobs of one Images paused another")
+	}
+
+	var none *Images
+	if none.BlobsPaused() || none.imageCachePath("https://example.org/a.png") != "" {
+		t.Error("a nil Images should neither pause nor cache")
+	}
+}
+
+func TestProcessImageKeepsAnimations(t *testing.T) {
+	palette := color.Palette{color.Black, color.White}
+	animation := &gif.GIF{}
+	for idx := 0; idx < 2; idx++ {
+		frame := image.NewPaletted(image.Rect(0, 0, 64, 64), palette)
+		frame.SetColorIndex(idx, idx, 1)
+		animation.Image = append(animation.Image, frame)
+		animation.Delay = append(animation.Delay, 10)
+	}
+
+	var original bytes.Buffer
+	if err := gif.EncodeAll(&original, animation); err != nil {
+		t.Fatal(err)
+	}
+
+	processed, err := processImage(bytes.NewReader(original.Bytes()), Config{ImageMaxWidth: 32, ImageQuality: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	contents, err := io.ReadAll(processed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(contents, original.Bytes()) {
+		t.Error("processImage changed an animated GIF")
+	}
+}
+
+func TestItemMessageLinksMissingLeadImage(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	cfg := Config{Feed: server.URL + "/feed.xml"}
+	if err := ValidateConfig(&cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	banner := server.URL + "/banner.png"
+	item := &gofeed.Item{
+		Title:       "Hello",
+		Link:        server.URL + "/hello",
+		Description: "<p>Hello world</p>",
+		Image:       &gofeed.Image{URL: banner},
+	}
+
+	message, err := ItemMessage(context.Background(), item, server.URL, item.Link, HashBlobs{}, cfg)
+	if err != nil {
+		t.Fatalf("ItemMessage() error = %s, want the post with a linked image", err)
+	}
+
+	if text, _ := message["text"].(string); !strings.Contains(text, "]("+banner+")") {
+		t.Errorf("ItemMessage() text = %q, want it to link %s", text, banner)
+	}
+}
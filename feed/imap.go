@@ -0,0 +1,208 @@
+package feed
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/url"
+	"strings"
+
+	"github.com/emersion/go-imap"
+	imapClient "github.com/emersion/go-imap/client"
+	"github.com/mmcdole/gofeed"
+	"golang.org/x/net/html/charset"
+)
+
+// fetchIMAPFeed turns the most recent messages of an IMAP folder into feed
+// items, newest first. Messages are not marked as read.
+func fetchIMAPFeed(cfg IMAPConfig) (gofeed.Feed, error) {
+	feed := gofeed.Feed{Title: cfg.Folder}
+
+	addr := cfg.Host
+	if !strings.Contains(addr, ":") {
+		addr += ":993"
+	}
+
+	client, err := imapClient.DialTLS(addr, nil)
+	if err != nil {
+		return feed, fmt.Errorf("fetchIMAPFeed: unable to connect to %s: %w", addr, err)
+	}
+	defer client.Logout()
+
+	if err := client.Login(cfg.Username, cfg.Password); err != nil {
+		return feed, fmt.Errorf("fetchIMAPFeed: unable to login to %s: %w", addr, err)
+	}
+
+	mailbox, err := client.Select(cfg.Folder, true)
+	if err != nil {
+		return feed, fmt.Errorf("fetchIMAPFeed: unable to select %s: %w", cfg.Folder, err)
+	}
+
+	if mailbox.Messages == 0 {
+		return feed, nil
+	}
+
+	from := uint32(1)
+	if cfg.Limit > 0 && mailbox.Messages > uint32(cfg.Limit) {
+		from = mailbox.Messages - uint32(cfg.Limit) + 1
+	}
+
+	seqSet := new(imap.SeqSet)
+	seqSet.AddRange(from, mailbox.Messages)
+
+	section := &imap.BodySectionName{Peek: true}
+	messages := make(chan *imap.Message, 10)
+	done := make(chan error, 1)
+	go func() {
+		done <- client.Fetch(seqSet, []imap.FetchItem{section.FetchItem()}, messages)
+	}()
+
+	for msg := range messages {
+		body := msg.GetBody(section)
+		if body == nil {
+			continue
+		}
+
+		item, err := emailItem(body)
+		if err != nil {
+			log.Printf("fetchIMAPFeed: skipping message %d: %s", msg.SeqNum, err)
+			continue
+		}
+
+		feed.Items = append([]*gofeed.Item{item}, feed.Items...)
+	}
+
+	if err := <-done; err != nil {
+		return feed, fmt.Errorf("fetchIMAPFeed: unable to fetch messages: %w", err)
+	}
+
+	return feed, nil
+}
+
+// emailItem converts an email into a feed item. The link of the item is a
+// mid: URL of the Message-ID, which is what the item is deduplicated on.
+func emailItem(r io.Reader) (*gofeed.Item, error) {
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return nil, fmt.Errorf("emailItem: unable to read message: %w", err)
+	}
+
+	messageID := strings.Trim(msg.Header.Get("Message-Id"), "<> ")
+	if messageID == "" {
+		return nil, fmt.Errorf("emailItem: missing Message-ID")
+	}
+
+	decoder := &mime.WordDecoder{CharsetReader: charset.NewReaderLabel}
+
+	subject, err := decoder.DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		subject = msg.Header.Get("Subject")
+	}
+
+	htmlBody, textBody, err := emailBody(msg.Header.Get("Content-Type"), msg.Header.Get("Content-Transfer-Encoding"), msg.Body)
+	if err != nil {
+		return nil, fmt.Errorf("emailItem: %w", err)
+	}
+
+	if htmlBody == "" {
+		htmlBody = textToHTML(textBody)
+	}
+
+	item := &gofeed.Item{
+		Title:   subject,
+		Content: htmlBody,
+		Link:    "mid:" + url.PathEscape(messageID),
+		GUID:    messageID,
+	}
+
+	if date, err := msg.Header.Date(); err == nil {
+		item.PublishedParsed = &date
+	}
+
+	if from, err := decoder.DecodeHeader(msg.Header.Get("From")); err == nil {
+		if address, err := mail.ParseAddress(from); err == nil {
+			author := &gofeed.Person{Name: address.Name, Email: address.Address}
+			item.Author = author
+			item.Authors = []*gofeed.Person{author}
+		}
+	}
+
+	return item, nil
+}
+
+// emailBody extracts the HTML and plain text bodies of an email (part),
+// descending into multipart parts. Attachments are ignored.
+func emailBody(contentType, encoding string, body io.Reader) (string, string, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = "text/plain"
+	}
+
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		var htmlBody, textBody string
+
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return htmlBody, textBody, fmt.Errorf("emailBody: unable to read part: %w", err)
+			}
+
+			if strings.HasPrefix(part.Header.Get("Content-Disposition"), "attachment") {
+				continue
+			}
+
+			partHTML, partText, err := emailBody(part.Header.Get("Content-Type"), part.Header.Get("Content-Transfer-Encoding"), part)
+			if err != nil {
+				return htmlBody, textBody, err
+			}
+
+			if htmlBody == "" {
+				htmlBody = partHTML
+			}
+			if textBody == "" {
+				textBody = partText
+			}
+		}
+
+		return htmlBody, textBody, nil
+	}
+
+	if mediaType != "text/html" && mediaType != "text/plain" {
+		return "", "", nil
+	}
+
+	if label := params["charset"]; label != "" {
+		decoded, err := charset.NewReaderLabel(label, body)
+		if err != nil {
+			return "", "", fmt.Errorf("emailBody: %w", err)
+		}
+		body = decoded
+	}
+
+	contents, err := io.ReadAll(body)
+	if err != nil {
+		return "", "", fmt.Errorf("emailBody: unable to read body: %w", err)
+	}
+
+	if mediaType == "text/html" {
+		return string(contents), "", nil
+	}
+
+	return "", string(contents), nil
+}
@@ -0,0 +1,78 @@
+package feed
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// IntroData is the data available to the introduction template.
+type IntroData struct {
+	Title       string
+	Description string
+	Link        string
+	Feed        string
+	Cadence     string
+	Operator    string
+}
+
+// defaultIntroTemplate is the default layout of the introduction post, see
+// IntroData for the available fields.
+const defaultIntroTemplate = `# About this account
+
+This is an automated account which mirrors [{{ .Title }}]({{ .Link }}) into the Scuttleverse.
+{{ if .Description }}
+> {{ .Description }}
+{{ end }}
+New items of the feed ({{ .Feed }}) are published {{ .Cadence }}, every post links back to the clearnet source.
+{{ if .Operator }}
+It is run by {{ .Operator }}, get in touch there for questions or problems.
+{{ end }}`
+
+// Cadence describes how often the items of a feed are published.
+func Cadence(cfg Config) string {
+	switch {
+	case cfg.Digest == "@daily":
+		return "as a daily digest"
+	case cfg.Digest == "@weekly":
+		return "as a weekly digest"
+	case cfg.Digest != "":
+		return fmt.Sprintf("as a digest on the schedule `%s`", cfg.Digest)
+	case cfg.Schedule != "":
+		return fmt.Sprintf("when the feed is checked on the schedule `%s`", cfg.Schedule)
+	}
+
+	wait := time.Duration(cfg.Poll) * time.Minute
+	if cfg.PollEvery > 0 {
+		wait = cfg.PollEvery
+	}
+
+	if wait <= 0 {
+		return "shortly after they appear"
+	}
+
+	return fmt.Sprintf("within %s of appearing", strings.TrimSuffix(strings.TrimSuffix(wait.String(), "0s"), "0m"))
+}
+
+// RenderIntroduction renders the introduction post with the configured (or
+// default) introduction template.
+func RenderIntroduction(cfg Config, data IntroData) (string, error) {
+	layout := cfg.IntroductionTemplate
+	if layout == "" {
+		layout = defaultIntroTemplate
+	}
+
+	tmpl, err := template.New("introduction").Parse(layout)
+	if err != nil {
+		return "", fmt.Errorf("RenderIntroduction: unable to parse template: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("RenderIntroduction: unable to render template: %w", err)
+	}
+
+	return buf.String(), nil
+}
@@ -0,0 +1,611 @@
+package feed
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+)
+
+// MarkdownImageRegex matches markdown images, e.g. ![alt](&ref "title").
+var MarkdownImageRegex = regexp.MustCompile(`!\[[^\]]*\]\([^)]*\)`)
+
+// summaryMode reports whether only a summary of items should be published.
+func summaryMode(cfg Config) bool {
+	return cfg.SummaryParagraphs > 0 || cfg.SummaryCharacters > 0
+}
+
+// Summarise trims converted markdown down to the first summary-paragraphs
+// paragraphs and at most summary-characters characters (cut on a word
+// boundary). Images are dropped from summaries.
+func Summarise(markdown string, cfg Config) string {
+	markdown = MarkdownImageRegex.ReplaceAllString(markdown, "")
+
+	var paragraphs []string
+	for _, paragraph := range strings.Split(markdown, "\n\n") {
+		if strings.TrimSpace(paragraph) == "" {
+			continue
+		}
+		paragraphs = append(paragraphs, strings.TrimSpace(paragraph))
+	}
+
+	if cfg.SummaryParagraphs > 0 && len(paragraphs) > cfg.SummaryParagraphs {
+		paragraphs = paragraphs[:cfg.SummaryParagraphs]
+	}
+
+	summary := strings.Join(paragraphs, "\n\n")
+
+	runes := []rune(summary)
+	if cfg.SummaryCharacters > 0 && len(runes) > cfg.SummaryCharacters {
+		summary = string(runes[:cfg.SummaryCharacters])
+		if idx := strings.LastIndexAny(summary, " \n"); idx > 0 {
+			summary = summary[:idx]
+		}
+		summary = strings.TrimSpace(summary) + "…"
+	}
+
+	return summary
+}
+
+// renderPost renders a post with the configured (or default) template.
+func renderPost(cfg Config, data PostData) (string, error) {
+	layout := cfg.Template
+	if layout == "" {
+		layout = defaultTemplate
+	}
+
+	tmpl, err := template.New("post").Parse(layout)
+	if err != nil {
+		return "", fmt.Errorf("renderPost: unable to parse template: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("renderPost: unable to render template: %w", err)
+	}
+
+	return buf.String(), nil
+}
+
+// blobImageRegex matches markdown images which refer to a blob and captures
+// the blob ref.
+var blobImageRegex = regexp.MustCompile(`!\[[^\]]*\]\((&[^)\s]+)`)
+
+// blogMessage creates a SSB blog message (as rendered by Patchwork and Oasis)
+// for a long article. The full markdown body is stored as a blob.
+func blogMessage(ctx context.Context, blobs BlobPutter, title, link, body, thumbnail string, cfg Config) (map[string]interface{}, error) {
+	ref, err := putBlob(ctx, blobs, []byte(body), cfg)
+	if err != nil {
+		return nil, fmt.Errorf("blogMessage: unable to upload blog body: %w", err)
+	}
+
+	if thumbnail == "" {
+		if match := blobImageRegex.FindStringSubmatch(body); match != nil {
+			thumbnail = match[1]
+		}
+	}
+
+	message := map[string]interface{}{
+		"type":    "blog",
+		"link":    link,
+		"title":   title,
+		"summary": Summarise(body, Config{SummaryParagraphs: 1, SummaryCharacters: blogSummaryLength}),
+		"blog":    ref.String(),
+	}
+
+	if thumbnail != "" {
+		message["thumbnail"] = thumbnail
+	}
+
+	return message, nil
+}
+
+// hashtagRegex matches the characters which are not allowed in hashtags.
+var hashtagRegex = regexp.MustCompile(`[^\p{L}\p{N}_-]+`)
+
+// containsFold reports whether values contains value, ignoring case.
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// categoryTags maps RSS item categories to SSB hashtags (without the leading
+// "#") using the configured mapping and allow/deny lists.
+func categoryTags(categories []string, rules CategoryRules) []string {
+	var tags []string
+
+	seen := make(map[string]bool)
+	for _, category := range categories {
+		category = strings.TrimSpace(category)
+		if category == "" || containsFold(rules.Deny, category) {
+			continue
+		}
+		if len(rules.Allow) > 0 && !containsFold(rules.Allow, category) {
+			continue
+		}
+
+		tag := category
+		for from, to := range rules.Mapping {
+			if strings.EqualFold(from, category) {
+				tag = to
+				break
+			}
+		}
+
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "#")
+		tag = strings.Trim(hashtagRegex.ReplaceAllString(strings.ToLower(tag), "-"), "-")
+		if tag == "" || seen[tag] {
+			continue
+		}
+
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+
+	return tags
+}
+
+// validateFilters compiles the filter regular expressions.
+func validateFilters(rules *FilterRules) error {
+	var err error
+	if rules.include, err = compilePatterns(rules.Include); err != nil {
+		return fmt.Errorf("validateFilters: %w", err)
+	}
+
+	if rules.exclude, err = compilePatterns(rules.Exclude); err != nil {
+		return fmt.Errorf("validateFilters: %w", err)
+	}
+
+	return nil
+}
+
+// compilePatterns compiles regular expressions.
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	var compiled []*regexp.Regexp
+	for _, pattern := range patterns {
+		regex, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("compilePatterns: invalid regular expression %s: %w", pattern, err)
+		}
+		compiled = append(compiled, regex)
+	}
+
+	return compiled, nil
+}
+
+// matchesAny reports whether any of the regular expressions match text.
+func matchesAny(patterns []*regexp.Regexp, text string) bool {
+	for _, pattern := range patterns {
+		if pattern.MatchString(text) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// itemAuthors gathers the author names of a RSS item.
+func itemAuthors(item *gofeed.Item) []string {
+	var authors []string
+
+	if item.Author != nil && item.Author.Name != "" {
+		authors = append(authors, item.Author.Name)
+	}
+
+	for _, author := range item.Authors {
+		if author != nil && author.Name != "" && !containsFold(authors, author.Name) {
+			authors = append(authors, author.Name)
+		}
+	}
+
+	return authors
+}
+
+// FilterItem decides whether a RSS item should be published according to the
+// configured filters. When it should not, the reason is returned.
+func FilterItem(item *gofeed.Item, rules FilterRules) (bool, string) {
+	text := item.Title + "\n" + item.Description + "\n" + item.Content
+
+	if len(rules.include) > 0 && !matchesAny(rules.include, text) {
+		return false, "no include filter matched"
+	}
+
+	if matchesAny(rules.exclude, text) {
+		return false, "an exclude filter matched"
+	}
+
+	for _, category := range item.Categories {
+		if containsFold(rules.DenyCategories, category) {
+			return false, fmt.Sprintf("category %s is denied", category)
+		}
+	}
+
+	if len(rules.AllowCategories) > 0 {
+		allowed := false
+		for _, category := range item.Categories {
+			if containsFold(rules.AllowCategories, category) {
+				allowed = true
+			}
+		}
+		if !allowed {
+			return false, "no category is allowed"
+		}
+	}
+
+	authors := itemAuthors(item)
+	for _, author := range authors {
+		if containsFold(rules.DenyAuthors, author) {
+			return false, fmt.Sprintf("author %s is denied", author)
+		}
+	}
+
+	if len(rules.AllowAuthors) > 0 {
+		allowed := false
+		for _, author := range authors {
+			if containsFold(rules.AllowAuthors, author) {
+				allowed = true
+			}
+		}
+		if !allowed {
+			return false, "no author is allowed"
+		}
+	}
+
+	return true, ""
+}
+
+// contentWarning is a compiled content-warnings regular expression with its
+// warning.
+type contentWarning struct {
+	pattern *regexp.Regexp
+	warning string
+}
+
+// ContentWarning returns the content warning of a RSS item: the
+// content-warning of the feed and the warnings of the content-warnings
+// regular expressions which match the item title and body.
+func ContentWarning(item *gofeed.Item, cfg Config) string {
+	var warnings []string
+	if cfg.ContentWarning != "" {
+		warnings = append(warnings, cfg.ContentWarning)
+	}
+
+	text := item.Title + "\n" + item.Description + "\n" + item.Content
+	for _, rule := range cfg.contentWarnings {
+		if rule.pattern.MatchString(text) && !containsFold(warnings, rule.warning) {
+			warnings = append(warnings, rule.warning)
+		}
+	}
+
+	return strings.Join(warnings, ", ")
+}
+
+// ItemPublished returns the publication (or else update) time of a RSS item.
+func ItemPublished(item *gofeed.Item) *time.Time {
+	if item.PublishedParsed != nil {
+		return item.PublishedParsed
+	}
+
+	return item.UpdatedParsed
+}
+
+// location returns the timezone of the dates of a feed, UTC by default.
+func location(cfg Config) *time.Location {
+	loc, err := time.LoadLocation(cfg.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+
+	return loc
+}
+
+// publishedDate returns the formatted publication date of a RSS item, or an
+// empty string when it has none.
+func publishedDate(item *gofeed.Item, cfg Config) string {
+	published := ItemPublished(item)
+	if published == nil {
+		return ""
+	}
+
+	layout := cfg.DateFormat
+	if layout == "" {
+		layout = defaultDateFormat
+	}
+
+	return published.In(location(cfg)).Format(layout)
+}
+
+// parseSince parses the since option, dates without an offset are in the
+// timezone of the feed.
+func parseSince(value, timezone string) (time.Time, error) {
+	for _, layout := range sinceLayouts {
+		since, err := time.ParseInLocation(layout, value, location(Config{Timezone: timezone}))
+		if err == nil {
+			return since, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("parseSince: invalid date %s, expected e.g. 2006-01-02 or 2006-01-02T15:04:05Z07:00", value)
+}
+
+// PublishedBefore reports whether a RSS item was published before the since
+// option of its feed. Items without a publication date never are.
+func PublishedBefore(item *gofeed.Item, cfg Config) bool {
+	if cfg.Since == "" {
+		return false
+	}
+
+	since, err := parseSince(cfg.Since, cfg.Timezone)
+	if err != nil {
+		return false
+	}
+
+	published := ItemPublished(item)
+
+	return published != nil && published.Before(since)
+}
+
+// ChronologicalItems orders RSS items from oldest to newest by publication
+// date. Feeds are usually reverse-chronological, so when some items lack a
+// date the reverse feed order is used instead.
+func ChronologicalItems(items []*gofeed.Item) []*gofeed.Item {
+	ordered := make([]*gofeed.Item, 0, len(items))
+	for idx := len(items) - 1; idx >= 0; idx-- {
+		ordered = append(ordered, items[idx])
+	}
+
+	for _, item := range ordered {
+		if ItemPublished(item) == nil {
+			return ordered
+		}
+	}
+
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ItemPublished(ordered[i]).Before(*ItemPublished(ordered[j]))
+	})
+
+	return ordered
+}
+
+// trackingParams are query parameters which are stripped from item links.
+// Parameters ending in "*" match by prefix.
+var trackingParams = []string{
+	"utm_*", "fbclid", "gclid", "dclid", "msclkid", "yclid", "igshid",
+	"mc_cid", "mc_eid", "_hsenc", "_hsmi", "mkt_tok",
+}
+
+// StripTrackingParams normalises a link by removing tracking query parameters
+// (trackingParams plus the configured extra parameters).
+func StripTrackingParams(link string, extra []string) string {
+	linkURL, err := url.Parse(link)
+	if err != nil || linkURL.RawQuery == "" {
+		return link
+	}
+
+	query := linkURL.Query()
+	for param := range query {
+		for _, tracking := range append(trackingParams, extra...) {
+			isPrefix := strings.HasSuffix(tracking, "*")
+			if param == tracking || isPrefix && strings.HasPrefix(param, strings.TrimSuffix(tracking, "*")) {
+				query.Del(param)
+				break
+			}
+		}
+	}
+
+	linkURL.RawQuery = query.Encode()
+
+	return linkURL.String()
+}
+
+// ItemMessage converts a RSS item into a post (or blog) message. Downloads
+// of the item are cancelled with ctx.
+func ItemMessage(ctx context.Context, item *gofeed.Item, feedLink, link string, blobs BlobPutter, cfg Config) (map[string]interface{}, error) {
+	feedContent := itemContent(ctx, item, cfg)
+
+	title := item.Title
+	if cfg.Translate.Enabled() {
+		var err error
+		title, feedContent, err = translateItem(ctx, item, feedContent, cfg.Translate)
+		if err != nil {
+			return nil, fmt.Errorf("ItemMessage: %w", err)
+		}
+	}
+
+	log.Printf("ItemMessage: converting '%s' to markdown", title)
+
+	baseURL := item.Link
+	if baseURL == "" {
+		baseURL = feedLink
+	}
+
+	markdown, err := htmlToMarkdown(ctx, feedContent, baseURL, blobs, cfg, !summaryMode(cfg))
+	if err != nil {
+		return nil, fmt.Errorf("ItemMessage: %w", err)
+	}
+
+	// the Open Graph image of the item link is the lead image of items
+	// without any images, it's left out when it can't be retrieved
+	image, fallback := item.Image, false
+	if image == nil && cfg.OGImage && item.Link != "" && !MarkdownImageRegex.MatchString(markdown) {
+		src, err := ogImage(ctx, item.Link, cfg)
+		if err != nil {
+			log.Printf("ItemMessage: no Open Graph image: %s", err)
+		} else if src != "" {
+			image, fallback = &gofeed.Image{URL: src, Title: title}, true
+		}
+	}
+
+	if summaryMode(cfg) {
+		markdown = Summarise(markdown, cfg) + "\n\n[Read more](" + link + ")"
+	}
+
+	data := PostData{Title: title, Content: markdown, Link: link, Published: publishedDate(item, cfg)}
+
+	if authors := itemAuthors(item); len(authors) > 0 {
+		data.Author = strings.Join(authors, ", ")
+		data.AuthorLink = item.Custom["authorURI"]
+
+		switch cfg.Byline {
+		case "line":
+			data.Byline = "_by " + data.Author + "_"
+			if data.AuthorLink != "" {
+				data.Byline = "_by [" + data.Author + "](" + data.AuthorLink + ")_"
+			}
+		case "title":
+			data.Title = data.Author + ": " + data.Title
+		}
+	}
+
+	tags := categoryTags(item.Categories, cfg.Categories)
+
+	var hashtags []string
+	if cfg.Categories.Hashtags {
+		hashtags = append(hashtags, tags...)
+	}
+
+	// the language hashtag is never the channel, unlike the category tags
+	if cfg.Language.Hashtag {
+		language := ItemLanguage(item)
+		if cfg.Translate.Enabled() && cfg.Translate.Mode == "translated" {
+			language = cfg.Translate.Target
+		}
+
+		if language != "" {
+			hashtags = append(hashtags, cfg.Language.Prefix+language)
+		}
+	}
+
+	if len(hashtags) > 0 {
+		data.Hashtags = "#" + strings.Join(hashtags, " #")
+	}
+
+	var thumbnail string
+	if image != nil && cfg.Images.BlobsPaused() {
+		if src, err := resolveURL(baseURL, image.URL); err == nil && !strings.HasPrefix(src, "data:") && !imageBlocked(src, ConversionRules{}) {
+			data.Image = imageMarkdown(image.Title, src, "")
+		}
+	} else if image != nil {
+		srcReader, err := resolveImage(ctx, image.URL, baseURL, cfg)
+		if errors.Is(err, errTrackingImage) || errors.Is(err, errSkippedImage) {
+			log.Printf("ItemMessage: skipping image: %s", err)
+		} else if errors.Is(err, errLinkedImage) {
+			if src, err := resolveURL(baseURL, image.URL); err == nil {
+				data.Image = imageMarkdown(image.Title, src, "")
+			}
+		} else if err != nil && fallback {
+			log.Printf("ItemMessage: leaving out Open Graph image: %s", err)
+		} else if err != nil {
+			// like the images of the body, a missing lead image doesn't hold
+			// back the post, it's linked on the clearnet instead
+			log.Printf("ItemMessage: linking image instead: %s", err)
+			if src, err := resolveURL(baseURL, image.URL); err == nil {
+				data.Image = imageMarkdown(image.Title, src, "")
+			}
+		} else {
+			ref, err := PutBlob(ctx, blobs, srcReader, cfg)
+			if err != nil {
+				return nil, fmt.Errorf("ItemMessage: %w", err)
+			}
+
+			thumbnail = ref.String()
+			data.Image = imageMarkdown(image.Title, thumbnail, "")
+		}
+	}
+
+	content, err := renderPost(cfg, data)
+	if err != nil {
+		return nil, fmt.Errorf("ItemMessage: %w", err)
+	}
+
+	if cfg.PostProcess != "" {
+		content, err = postProcess(ctx, content, data, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("ItemMessage: %w", err)
+		}
+	}
+
+	warning := ContentWarning(item, cfg)
+
+	if cfg.LongPosts == "blog" && len(content) > PostLimit(cfg) {
+		log.Printf("ItemMessage: publishing %s as blog, too long", link)
+
+		body := strings.TrimPrefix(content, "# "+data.Title+"\n")
+		message, err := blogMessage(ctx, blobs, data.Title, link, body, thumbnail, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("ItemMessage: %w", err)
+		}
+
+		if warning != "" {
+			message["contentWarning"] = warning
+		}
+
+		return message, nil
+	}
+
+	message := map[string]interface{}{
+		"type": "post",
+		"link": link,
+		"text": content,
+	}
+
+	if len(hashtags) > 0 {
+		var mentions []map[string]string
+		for _, tag := range hashtags {
+			mentions = append(mentions, map[string]string{"link": "#" + tag})
+		}
+		message["mentions"] = mentions
+	}
+
+	if cfg.Categories.Channel && len(tags) > 0 {
+		message["channel"] = tags[0]
+	} else if cfg.Channel != "" {
+		message["channel"] = cfg.Channel
+	}
+
+	if warning != "" {
+		message["contentWarning"] = warning
+	}
+
+	return message, nil
+}
+
+// ItemHash hashes the converted markdown of the content which the feed itself
+// provides for an item, so that edits can be detected without fetching the
+// item link or its images.
+func ItemHash(ctx context.Context, item *gofeed.Item, feedLink string, blobs BlobPutter, cfg Config) (string, error) {
+	baseURL := item.Link
+	if baseURL == "" {
+		baseURL = feedLink
+	}
+
+	content := item.Content
+	if content == "" {
+		content = item.Description
+	}
+
+	markdown, err := htmlToMarkdown(ctx, content, baseURL, blobs, cfg, false)
+	if err != nil {
+		return "", fmt.Errorf("ItemHash: %w", err)
+	}
+
+	sum := sha256.Sum256([]byte(item.Title + "\n" + markdown))
+
+	return hex.EncodeToString(sum[:]), nil
+}
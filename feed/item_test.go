@@ -0,0 +1,43 @@
+package feed
+
+import (
+	"testing"
+
+	"github.com/mmcdole/gofeed"
+)
+
+func TestFilterItem(t *testing.T) {
+	cfg := Config{
+		Feed:            "https://example.org/feed.xml",
+		Filters:         FilterRules{Include: []string{`(?i)golang`}, Exclude: []string{`sponsored`}},
+		ContentWarnings: map[string]string{`(?i)spoiler`: "spoilers"},
+	}
+	if err := ValidateConfig(&cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		title   string
+		publish bool
+	}{
+		{"Golang 2 is out", true},
+		{"Rust 2 is out", false},
+		{"Golang 2 is out (sponsored)", false},
+	}
+	for _, test := range tests {
+		if publish, reason := FilterItem(&gofeed.Item{Title: test.title}, cfg.Filters); publish != test.publish {
+			t.Errorf("FilterItem(%q) = %t (%s), want %t", test.title, publish, reason, test.publish)
+		}
+	}
+
+	if warning := ContentWarning(&gofeed.Item{Title: "Spoiler: golang"}, cfg); warning != "spoilers" {
+		t.Errorf("ContentWarning() = %q, want spoilers", warning)
+	}
+}
+
+func TestValidateConfigInvalidFilter(t *testing.T) {
+	cfg := Config{Feed: "https://example.org/feed.xml", Filters: FilterRules{Exclude: []string{`(`}}}
+	if err := ValidateConfig(&cfg); err == nil {
+		t.Error("ValidateConfig() accepted an invalid regular expression")
+	}
+}
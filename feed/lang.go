@@ -0,0 +1,153 @@
+package feed
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/mmcdole/gofeed"
+)
+
+// defaultLanguagePrefix is the default prefix of language hashtags.
+const defaultLanguagePrefix = "lang-"
+
+// minLanguageHits is the number of stopwords of a language which must be found
+// in a text before it counts as detected.
+const minLanguageHits = 3
+
+// languageStopwords are frequent words of the languages which are detected,
+// keyed by ISO 639-1 code.
+var languageStopwords = map[string][]string{
+	"en": {"the", "and", "of", "to", "is", "that", "it", "for", "with", "was", "on", "are", "this", "be",
+		"have", "from", "by", "not", "they", "which", "you", "but", "at", "has", "were", "their", "will", "would"},
+	"de": {"der", "die", "und", "das", "ist", "nicht", "ein", "eine", "mit", "sich", "auf", "für", "den", "dem",
+		"des", "von", "auch", "zu", "es", "im", "wird", "werden", "wir", "ich", "oder", "aber", "nach", "bei", "sind"},
+	"fr": {"le", "la", "les", "et", "des", "est", "une", "un", "du", "que", "pour", "dans", "qui", "pas", "sur",
+		"avec", "au", "ce", "il", "elle", "sont", "nous", "vous", "mais", "ou", "plus", "cette", "été", "aux", "par"},
+	"es": {"el", "la", "los", "las", "y", "que", "en", "es", "una", "un", "por", "con", "para", "del", "se",
+		"no", "lo", "como", "su", "más", "pero", "al", "está", "son", "fue", "sus", "también", "ha", "muy", "este"},
+	"it": {"il", "di", "che", "la", "e", "è", "un", "una", "per", "non", "con", "sono", "del", "della", "le",
+		"gli", "da", "si", "nel", "anche", "come", "ma", "più", "alla", "questo", "essere", "ha", "ci", "dei", "delle"},
+	"nl": {"de", "het", "een", "en", "van", "is", "dat", "niet", "op", "te", "zijn", "voor", "met", "die", "ook",
+		"aan", "er", "maar", "om", "wordt", "bij", "nog", "werd", "naar", "hij", "we", "deze", "dan", "kan", "worden"},
+	"pt": {"o", "a", "os", "as", "de", "que", "e", "do", "da", "em", "um", "uma", "para", "com", "não", "é",
+		"no", "na", "por", "mais", "dos", "das", "se", "foi", "ao", "como", "mas", "seu", "sua", "também"},
+	"sv": {"och", "att", "det", "som", "en", "är", "på", "för", "med", "av", "inte", "den", "till", "har", "om",
+		"ett", "de", "var", "jag", "men", "vi", "kan", "så", "från", "eller", "ska", "sig", "hade", "blir", "vid"},
+	"pl": {"i", "w", "nie", "na", "się", "z", "że", "do", "to", "jest", "jak", "o", "ale", "po", "co", "tak",
+		"za", "od", "są", "jego", "przez", "dla", "czy", "już", "być", "tylko", "jej", "tym", "może", "także"},
+}
+
+// stopwordLanguages maps each stopword to the languages it belongs to.
+var stopwordLanguages = func() map[string][]string {
+	languages := make(map[string][]string)
+	for language, words := range languageStopwords {
+		for _, word := range words {
+			languages[word] = append(languages[word], language)
+		}
+	}
+	return languages
+}()
+
+var (
+	// languageTagRegex matches HTML tags, which are left out of the detection.
+	languageTagRegex = regexp.MustCompile(`<[^>]*>`)
+
+	// languageWordRegex matches the words of a text.
+	languageWordRegex = regexp.MustCompile(`\p{L}+`)
+
+	// languageCodeRegex matches an ISO 639 language code.
+	languageCodeRegex = regexp.MustCompile(`^[a-z]{2,3}$`)
+)
+
+// normaliseLanguage turns a language tag like "en-US" into its language code.
+func normaliseLanguage(tag string) string {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if idx := strings.IndexAny(tag, "-_"); idx != -1 {
+		tag = tag[:idx]
+	}
+
+	if !languageCodeRegex.MatchString(tag) {
+		return ""
+	}
+
+	return tag
+}
+
+// DetectLanguage detects the language of a text by counting the stopwords of
+// the known languages. Without a clear winner, it returns the normalised
+// fallback language tag.
+func DetectLanguage(text, fallback string) string {
+	hits := make(map[string]int)
+	for _, word := range languageWordRegex.FindAllString(strings.ToLower(text), -1) {
+		for _, language := range stopwordLanguages[word] {
+			hits[language]++
+		}
+	}
+
+	best, bestHits, tied := "", 0, false
+	for language, count := range hits {
+		if count > bestHits {
+			best, bestHits, tied = language, count, false
+		} else if count == bestHits {
+			tied = true
+		}
+	}
+
+	if bestHits < minLanguageHits || tied {
+		return normaliseLanguage(fallback)
+	}
+
+	return best
+}
+
+// ItemLanguage detects the language of a RSS item from its title and body,
+// falling back to the language the item or else the feed declares.
+func ItemLanguage(item *gofeed.Item) string {
+	fallback := item.Custom["feedLanguage"]
+	if item.DublinCoreExt != nil && len(item.DublinCoreExt.Language) > 0 {
+		fallback = item.DublinCoreExt.Language[0]
+	}
+
+	text := item.Title + "\n" + item.Description + "\n" + item.Content
+	return DetectLanguage(languageTagRegex.ReplaceAllString(text, " "), fallback)
+}
+
+// validateLanguages normalises the allowed languages and checks that they're
+// language codes.
+func validateLanguages(rules *LanguageRules) error {
+	if rules.Prefix == "" {
+		rules.Prefix = defaultLanguagePrefix
+	}
+
+	for idx, language := range rules.Allow {
+		code := normaliseLanguage(language)
+		if code == "" {
+			return fmt.Errorf("validateLanguages: %s is not a language code like de or en", language)
+		}
+		rules.Allow[idx] = code
+	}
+
+	return nil
+}
+
+// FilterLanguage decides whether a RSS item should be published according to
+// the allowed languages. Items whose language can't be detected are skipped
+// when languages are allowed. When it should not be published, the reason is
+// returned.
+func FilterLanguage(item *gofeed.Item, rules LanguageRules) (bool, string) {
+	if len(rules.Allow) == 0 {
+		return true, ""
+	}
+
+	language := ItemLanguage(item)
+	if language == "" {
+		return false, "its language is unknown"
+	}
+
+	if !containsFold(rules.Allow, language) {
+		return false, fmt.Sprintf("language %s is not allowed", language)
+	}
+
+	return true, ""
+}
@@ -0,0 +1,221 @@
+package feed
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/srwiley/oksvg"
+	"github.com/srwiley/rasterx"
+)
+
+// errSkippedImage is returned for images whose format is skipped by the
+// image-policies.
+var errSkippedImage = errors.New("image format skipped")
+
+// errLinkedImage is returned for images whose format is linked on the
+// clearnet instead of stored as a blob by the image-policies.
+var errLinkedImage = errors.New("image format linked")
+
+// imageFormats are the image formats which have a policy, "other" is every
+// format which can't be decoded (e.g. AVIF, HEIC or TIFF).
+var imageFormats = []string{"svg", "gif", "jpeg", "png", "webp", "other"}
+
+// defaultImagePolicies are the policies of the image formats which are not
+// configured in image-policies. SVG blobs are rendered inconsistently by SSB
+// clients (and may carry scripts), so they're rasterized.
+var defaultImagePolicies = map[string]string{
+	"svg":   "rasterize",
+	"gif":   "embed",
+	"jpeg":  "embed",
+	"png":   "embed",
+	"webp":  "embed",
+	"other": "link",
+}
+
+// maxSVGSize is the largest width and height (in pixels) which SVG images are
+// rasterized at.
+const maxSVGSize = 2048
+
+// defaultSVGSize is the width and height (in pixels) which SVG images without
+// a view box are rasterized at.
+const defaultSVGSize = 512
+
+// validateImagePolicies checks the configured image policies and fills in
+// the defaults of the missing formats.
+func validateImagePolicies(policies map[string]string) (map[string]string, error) {
+	validated := make(map[string]string, len(imageFormats))
+	for format, policy := range defaultImagePolicies {
+		validated[format] = policy
+	}
+
+	for format, policy := range policies {
+		format = strings.ToLower(format)
+		if _, ok := defaultImagePolicies[format]; !ok {
+			known := append([]string{}, imageFormats...)
+			sort.Strings(known)
+			return nil, fmt.Errorf("validateImagePolicies: unknown image format %s, expected one of %s", format, strings.Join(known, ", "))
+		}
+
+		switch policy {
+		case "embed", "link", "skip":
+		case "rasterize":
+			if format != "svg" {
+				return nil, fmt.Errorf("validateImagePolicies: only svg images can be rasterized, not %s", format)
+			}
+		default:
+			return nil, fmt.Errorf("validateImagePolicies: unknown policy %s for %s, expected embed, rasterize, link or skip", policy, format)
+		}
+
+		validated[format] = policy
+	}
+
+	return validated, nil
+}
+
+// imageFormat returns the format of an image, one of imageFormats.
+func imageFormat(body []byte) string {
+	head := body
+	if len(head) > 1024 {
+		head = head[:1024]
+	}
+
+	if bytes.Contains(bytes.ToLower(head), []byte("<svg")) {
+		return "svg"
+	}
+
+	_, format, err := image.DecodeConfig(bytes.NewReader(body))
+	if err != nil {
+		return "other"
+	}
+
+	return format
+}
+
+// applyImagePolicy applies the image-policies to an image: it is passed
+// through, rasterized (SVG only) or refused with errLinkedImage or
+// errSkippedImage. Animated GIFs above max-animation-size are replaced by
+// their first frame.
+func applyImagePolicy(src io.Reader, cfg Config) (io.Reader, error) {
+	if isStillImage(src) {
+		return src, nil
+	}
+
+	body, err := ioutil.ReadAll(src)
+	if err != nil {
+		return nil, fmt.Errorf("applyImagePolicy: unable to read image: %w", err)
+	}
+
+	format := imageFormat(body)
+
+	policy, ok := cfg.ImagePolicies[format]
+	if !ok {
+		policy = defaultImagePolicies[format]
+	}
+
+	switch policy {
+	case "rasterize":
+		rasterized, err := rasterizeSVG(body)
+		if err != nil {
+			return nil, fmt.Errorf("applyImagePolicy: %w", err)
+		}
+		return rasterized, nil
+	case "link":
+		return nil, fmt.Errorf("applyImagePolicy: %s image: %w", format, errLinkedImage)
+	case "skip":
+		return nil, fmt.Errorf("applyImagePolicy: %s image: %w", format, errSkippedImage)
+	}
+
+	if format == "gif" && cfg.MaxAnimationSize > 0 && int64(len(body)) > cfg.MaxAnimationSize && isAnimatedGIF(body) {
+		still, err := gifStill(body)
+		if err != nil {
+			return nil, fmt.Errorf("applyImagePolicy: %w", err)
+		}
+		return still, nil
+	}
+
+	return bytes.NewReader(body), nil
+}
+
+// rasterizeSVG renders an SVG image as PNG at the size of its view box.
+func rasterizeSVG(body []byte) (io.Reader, error) {
+	icon, err := oksvg.ReadIconStream(bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("rasterizeSVG: unable to parse SVG: %w", err)
+	}
+
+	width, height := icon.ViewBox.W, icon.ViewBox.H
+	if width <= 0 || height <= 0 {
+		width, height = defaultSVGSize, defaultSVGSize
+	}
+
+	if scale := maxSVGSize / width; width > maxSVGSize || height > maxSVGSize {
+		if heightScale := maxSVGSize / height; heightScale < scale {
+			scale = heightScale
+		}
+		width, height = width*scale, height*scale
+	}
+
+	w, h := int(width), int(height)
+	if w < 1 || h < 1 {
+		return nil, fmt.Errorf("rasterizeSVG: invalid size %dx%d", w, h)
+	}
+
+	icon.SetTarget(0, 0, float64(w), float64(h))
+
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	scanner := rasterx.NewScannerGV(w, h, img, img.Bounds())
+	icon.Draw(rasterx.NewDasher(w, h, scanner), 1)
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("rasterizeSVG: unable to encode PNG: %w", err)
+	}
+
+	return &buf, nil
+}
+
+// stillImage is the first frame of an animated GIF which was too large to be
+// stored as a blob. The original is linked next to it.
+type stillImage struct {
+	*bytes.Reader
+}
+
+// isStillImage reports whether an image is the first frame of an animation.
+func isStillImage(src io.Reader) bool {
+	_, ok := src.(stillImage)
+	return ok
+}
+
+// gifStill returns the first frame of a GIF as PNG. It only needs the data up
+// to the end of the first frame, so truncated GIFs work as well.
+func gifStill(body []byte) (stillImage, error) {
+	frame, err := gif.Decode(bytes.NewReader(body))
+	if err != nil {
+		return stillImage{}, fmt.Errorf("gifStill: unable to decode GIF: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, frame); err != nil {
+		return stillImage{}, fmt.Errorf("gifStill: unable to encode PNG: %w", err)
+	}
+
+	return stillImage{bytes.NewReader(buf.Bytes())}, nil
+}
+
+// isAnimatedGIF reports whether a GIF has more than one frame.
+func isAnimatedGIF(body []byte) bool {
+	animation, err := gif.DecodeAll(bytes.NewReader(body))
+	if err != nil {
+		return false
+	}
+
+	return len(animation.Image) > 1
+}
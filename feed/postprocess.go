@@ -0,0 +1,56 @@
+package feed
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// postProcessTimeout is how long the post-process command may take per post.
+const postProcessTimeout = 60 * time.Second
+
+// validatePostProcess checks that the post-process command can be run.
+func validatePostProcess(command string) error {
+	if command == "" {
+		return nil
+	}
+
+	if _, err := exec.LookPath(command); err != nil {
+		return fmt.Errorf("validatePostProcess: unable to run %s: %w", command, err)
+	}
+
+	return nil
+}
+
+// postProcess passes the markdown of a post through the post-process command,
+// which reads it on stdin and writes the modified markdown to stdout. The
+// title and link of the item and the feed are passed as POST_TITLE, POST_LINK
+// and FEED_URL.
+func postProcess(ctx context.Context, markdown string, data PostData, cfg Config) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, postProcessTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, cfg.PostProcess)
+	cmd.Stdin = strings.NewReader(markdown)
+	cmd.Env = append(os.Environ(), "POST_TITLE="+data.Title, "POST_LINK="+data.Link, "FEED_URL="+cfg.Feed)
+
+	output, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+			return "", fmt.Errorf("postProcess: %s failed: %w: %s", cfg.PostProcess, err, strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return "", fmt.Errorf("postProcess: %s failed: %w", cfg.PostProcess, err)
+	}
+
+	processed := strings.TrimSpace(string(output))
+	if processed == "" {
+		return "", fmt.Errorf("postProcess: %s returned an empty post", cfg.PostProcess)
+	}
+
+	return processed, nil
+}
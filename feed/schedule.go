@@ -0,0 +1,205 @@
+package feed
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	"github.com/robfig/cron/v3"
+)
+
+// nextPoll computes how long to wait before polling a feed again. The RSS ttl
+// hint is honoured and wakeups during skipHours (GMT) and skipDays are pushed
+// back. The configured poll frequency (poll-every, or poll in minutes) acts as
+// a floor.
+func nextPoll(feed gofeed.Feed, cfg Config, now time.Time) time.Duration {
+	wait := time.Duration(cfg.Poll) * time.Minute
+	if cfg.PollEvery > 0 {
+		wait = cfg.PollEvery
+	}
+
+	if ttl, err := strconv.Atoi(feed.Custom["ttl"]); err == nil {
+		if ttlWait := time.Duration(ttl) * time.Minute; ttlWait > wait {
+			wait = ttlWait
+		}
+	}
+
+	skipHours := make(map[int]bool)
+	for _, hour := range strings.Split(feed.Custom["skipHours"], ",") {
+		if parsed, err := strconv.Atoi(strings.TrimSpace(hour)); err == nil {
+			skipHours[parsed%24] = true
+		}
+	}
+
+	skipDays := make(map[string]bool)
+	for _, day := range strings.Split(feed.Custom["skipDays"], ",") {
+		if day = strings.TrimSpace(day); day != "" {
+			skipDays[strings.ToLower(day)] = true
+		}
+	}
+
+	next := now.Add(wait).UTC()
+	for i := 0; i < 24*7; i++ {
+		if !skipHours[next.Hour()] && !skipDays[strings.ToLower(next.Weekday().String())] {
+			break
+		}
+		next = next.Truncate(time.Hour).Add(time.Hour)
+	}
+
+	return next.Sub(now)
+}
+
+// jitterRand is the source of randomness for poll jitter.
+var jitterRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+
+// Jitter returns a random duration in [0, max), or 0 when max is not positive.
+func Jitter(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+
+	return time.Duration(jitterRand.Int63n(int64(max)))
+}
+
+// NextDigest computes when the digest of a feed which was last published (or
+// started collecting items) at last is due.
+func NextDigest(cfg Config, last time.Time) time.Time {
+	schedule, err := cron.ParseStandard(cfg.Digest)
+	if err != nil {
+		log.Printf("NextDigest: %s: invalid digest schedule: %s", cfg.Feed, err)
+		return last
+	}
+
+	return schedule.Next(last)
+}
+
+// PollInterval returns the regular interval at which a feed is polled: the
+// time between the next two runs of its schedule or the configured poll
+// frequency. Hints of the feed, which only stretch it, aren't considered.
+func PollInterval(cfg Config, now time.Time) time.Duration {
+	if cfg.Schedule != "" {
+		if schedule, err := cron.ParseStandard(cfg.Schedule); err == nil {
+			next := schedule.Next(now)
+			return schedule.Next(next).Sub(next) + cfg.PollJitter
+		}
+	}
+
+	wait := time.Duration(cfg.Poll) * time.Minute
+	if cfg.PollEvery > 0 {
+		wait = cfg.PollEvery
+	}
+
+	return wait + cfg.PollJitter
+}
+
+// NextDue computes when a feed should be polled next. A cron schedule takes
+// precedence over the poll frequency and the hints of the feed. A random
+// poll-jitter is added so that polls don't line up with other instances.
+func NextDue(feed gofeed.Feed, cfg Config, now time.Time) time.Time {
+	if cfg.Schedule != "" {
+		schedule, err := cron.ParseStandard(cfg.Schedule)
+		if err == nil {
+			return schedule.Next(now).Add(Jitter(cfg.PollJitter))
+		}
+		log.Printf("NextDue: %s: invalid schedule: %s", cfg.Feed, err)
+	}
+
+	return now.Add(nextPoll(feed, cfg, now) + Jitter(cfg.PollJitter))
+}
+
+// publishWindow is the time of day during which posts of a feed are published,
+// end is before start for windows which span midnight.
+type publishWindow struct {
+	start, end time.Duration
+	location   *time.Location
+}
+
+// parsePublishWindow parses a publish-window, e.g. "08:00-22:00 Europe/Berlin".
+// Without a timezone, the timezone of the feed is used.
+func parsePublishWindow(value, timezone string) (publishWindow, error) {
+	fields := strings.Fields(value)
+	if len(fields) == 0 || len(fields) > 2 {
+		return publishWindow{}, fmt.Errorf("parsePublishWindow: invalid window %s, expected e.g. 08:00-22:00 Europe/Berlin", value)
+	}
+
+	if len(fields) == 2 {
+		timezone = fields[1]
+	}
+
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		return publishWindow{}, fmt.Errorf("parsePublishWindow: unknown timezone %s: %w", timezone, err)
+	}
+
+	bounds := strings.Split(fields[0], "-")
+	if len(bounds) != 2 {
+		return publishWindow{}, fmt.Errorf("parsePublishWindow: invalid window %s, expected e.g. 08:00-22:00 Europe/Berlin", value)
+	}
+
+	var times [2]time.Duration
+	for idx, bound := range bounds {
+		parsed, err := time.Parse("15:04", bound)
+		if err != nil {
+			return publishWindow{}, fmt.Errorf("parsePublishWindow: invalid time %s, expected e.g. 08:00", bound)
+		}
+		times[idx] = time.Duration(parsed.Hour())*time.Hour + time.Duration(parsed.Minute())*time.Minute
+	}
+
+	if times[0] == times[1] {
+		return publishWindow{}, fmt.Errorf("parsePublishWindow: empty window %s", value)
+	}
+
+	return publishWindow{start: times[0], end: times[1], location: location}, nil
+}
+
+// contains reports whether the window is open at a time.
+func (w publishWindow) contains(at time.Time) bool {
+	at = at.In(w.location)
+	offset := time.Duration(at.Hour())*time.Hour + time.Duration(at.Minute())*time.Minute
+
+	if w.start < w.end {
+		return offset >= w.start && offset < w.end
+	}
+
+	return offset >= w.start || offset < w.end
+}
+
+// next returns when the window opens next after a time.
+func (w publishWindow) next(after time.Time) time.Time {
+	after = after.In(w.location)
+	year, month, day := after.Date()
+
+	hour, minute := int(w.start/time.Hour), int(w.start%time.Hour/time.Minute)
+
+	opens := time.Date(year, month, day, hour, minute, 0, 0, w.location)
+	if !opens.After(after) {
+		opens = time.Date(year, month, day+1, hour, minute, 0, 0, w.location)
+	}
+
+	return opens
+}
+
+// PublishWindowOpen reports whether the posts of a feed may be published at a
+// time according to its publish-window. When they may not, it returns when
+// the window opens next.
+func PublishWindowOpen(cfg Config, now time.Time) (bool, time.Time) {
+	if cfg.PublishWindow == "" {
+		return true, time.Time{}
+	}
+
+	window, err := parsePublishWindow(cfg.PublishWindow, cfg.Timezone)
+	if err != nil {
+		log.Printf("PublishWindowOpen: %s: %s", cfg.Feed, err)
+		return true, time.Time{}
+	}
+
+	if window.contains(now) {
+		return true, time.Time{}
+	}
+
+	return false, window.next(now)
+}
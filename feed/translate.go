@@ -0,0 +1,197 @@
+package feed
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+)
+
+// defaultTranslateTimeout is how long translating a text may take by default.
+const defaultTranslateTimeout = 60 * time.Second
+
+// TranslateConfig configures the translation of items into the Target
+// language before they're converted, with an external Command or a
+// LibreTranslate compatible API at URL. Mode is "translated" (the default) to
+// only publish the translation or "bilingual" to keep the original below it.
+type TranslateConfig struct {
+	Target     string        `yaml:"target,omitempty"`
+	Command    []string      `yaml:"command,omitempty"`
+	URL        string        `yaml:"url,omitempty"`
+	APIKey     string        `yaml:"api-key,omitempty"`
+	APIKeyEnv  string        `yaml:"api-key-env,omitempty"`
+	APIKeyFile string        `yaml:"api-key-file,omitempty"`
+	Mode       string        `yaml:"mode,omitempty"`
+	Timeout    time.Duration `yaml:"timeout,omitempty"`
+}
+
+// Enabled reports whether items are translated.
+func (cfg TranslateConfig) Enabled() bool {
+	return cfg.Target != ""
+}
+
+// validateTranslate checks the translation options, if enabled, and reads
+// the API key.
+func validateTranslate(cfg *TranslateConfig) error {
+	if !cfg.Enabled() {
+		if len(cfg.Command) > 0 || cfg.URL != "" {
+			return fmt.Errorf("validateTranslate: the target language is missing")
+		}
+		return nil
+	}
+
+	if cfg.Target = normaliseLanguage(cfg.Target); cfg.Target == "" {
+		return fmt.Errorf("validateTranslate: target is not a language code like de or en")
+	}
+
+	if (len(cfg.Command) > 0) == (cfg.URL != "") {
+		return fmt.Errorf("validateTranslate: configure either a command or the url of an API")
+	}
+
+	if cfg.URL != "" {
+		if parsed, err := url.Parse(cfg.URL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+			return fmt.Errorf("validateTranslate: url %s is not a http(s) URL", cfg.URL)
+		}
+	}
+
+	switch cfg.Mode {
+	case "":
+		cfg.Mode = "translated"
+	case "translated", "bilingual":
+	default:
+		return fmt.Errorf("validateTranslate: unknown mode %s, expected translated or bilingual", cfg.Mode)
+	}
+
+	if cfg.Timeout == 0 {
+		cfg.Timeout = defaultTranslateTimeout
+	}
+
+	apiKey, err := ResolveSecret(cfg.APIKey, cfg.APIKeyEnv, cfg.APIKeyFile)
+	if err != nil {
+		return fmt.Errorf("validateTranslate: api key: %w", err)
+	}
+	cfg.APIKey = apiKey
+
+	return nil
+}
+
+// translateCommand translates text with the external command, which reads it
+// on stdin and writes the translation to stdout. The languages and the format
+// (html or text) are passed as TRANSLATE_SOURCE, TRANSLATE_TARGET and
+// TRANSLATE_FORMAT.
+func translateCommand(ctx context.Context, text, source, format string, cfg TranslateConfig) (string, error) {
+	cmd := exec.CommandContext(ctx, cfg.Command[0], cfg.Command[1:]...)
+	cmd.Stdin = strings.NewReader(text)
+	cmd.Env = append(os.Environ(), "TRANSLATE_SOURCE="+source, "TRANSLATE_TARGET="+cfg.Target, "TRANSLATE_FORMAT="+format)
+
+	output, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+			return "", fmt.Errorf("translateCommand: %s failed: %w: %s", cfg.Command[0], err, strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return "", fmt.Errorf("translateCommand: %s failed: %w", cfg.Command[0], err)
+	}
+
+	return strings.TrimSpace(string(output)), nil
+}
+
+// translateAPI translates text with the /translate endpoint of a
+// LibreTranslate compatible API.
+func translateAPI(ctx context.Context, text, source, format string, cfg TranslateConfig) (string, error) {
+	request := map[string]string{"q": text, "source": source, "target": cfg.Target, "format": format}
+	if cfg.APIKey != "" {
+		request["api_key"] = cfg.APIKey
+	}
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return "", fmt.Errorf("translateAPI: unable to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", strings.TrimSuffix(cfg.URL, "/")+"/translate", bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("translateAPI: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("translateAPI: unable to call %s: %w", cfg.URL, err)
+	}
+	defer resp.Body.Close()
+
+	var response struct {
+		TranslatedText string `json:"translatedText"`
+		Error          string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil && resp.StatusCode < 300 {
+		return "", fmt.Errorf("translateAPI: unable to decode response: %w", err)
+	}
+
+	if resp.StatusCode >= 300 {
+		return "", fmt.Errorf("translateAPI: %s responded with %s: %s", cfg.URL, resp.Status, response.Error)
+	}
+
+	return response.TranslatedText, nil
+}
+
+// translate translates a text from the source language ("auto" when unknown)
+// into the target language.
+func translate(ctx context.Context, text, source, format string, cfg TranslateConfig) (string, error) {
+	if strings.TrimSpace(text) == "" {
+		return text, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+	defer cancel()
+
+	if cfg.URL != "" {
+		return translateAPI(ctx, text, source, format, cfg)
+	}
+
+	return translateCommand(ctx, text, source, format, cfg)
+}
+
+// translateItem translates the title and the HTML content of an item, unless
+// it's in the target language already. Bilingual content keeps the original
+// below the translation.
+func translateItem(ctx context.Context, item *gofeed.Item, content string, cfg TranslateConfig) (string, string, error) {
+	source := ItemLanguage(item)
+	if source == cfg.Target {
+		return item.Title, content, nil
+	}
+
+	label := "Original"
+	if source == "" {
+		source = "auto"
+	} else {
+		label += " (" + source + ")"
+	}
+
+	title, err := translate(ctx, item.Title, source, "text", cfg)
+	if err != nil {
+		return "", "", fmt.Errorf("translateItem: title: %w", err)
+	}
+
+	translated, err := translate(ctx, content, source, "html", cfg)
+	if err != nil {
+		return "", "", fmt.Errorf("translateItem: content: %w", err)
+	}
+
+	if cfg.Mode == "bilingual" {
+		translated += "<hr><p><em>" + label + ":</em></p><h2>" + html.EscapeString(item.Title) + "</h2>" + content
+	}
+
+	return title, translated, nil
+}
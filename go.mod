@@ -3,19 +3,35 @@ module decentral1se/rss-butt-plug
 go 1.18
 
 require (
+	github.com/BurntSushi/toml v1.2.1
 	github.com/JohannesKaufmann/html-to-markdown v1.3.6
 	github.com/PuerkitoBio/goquery v1.8.0
+	github.com/andybalholm/cascadia v1.3.1
+	github.com/btcsuite/btcd/btcec/v2 v2.2.1
+	github.com/cretz/bine v0.2.0
+	github.com/emersion/go-imap v1.2.1
+	github.com/gorilla/websocket v1.5.0
 	github.com/mmcdole/gofeed v1.1.3
+	github.com/robfig/cron/v3 v3.0.1
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
+	github.com/srwiley/oksvg v0.0.0-20221011165216-be6e8873101c
+	github.com/srwiley/rasterx v0.0.0-20210519020934-456a8d69b780
 	github.com/ssbc/go-luigi v0.3.7-0.20221019204020-324065b9a7c6
+	github.com/ssbc/go-metafeed v1.1.3-0.20221019090205-458925e39156
+	github.com/ssbc/go-muxrpc/v2 v2.0.14-0.20221111190521-10382533750c
 	github.com/ssbc/go-ssb v0.2.2-0.20221114231348-43505cca26d4
+	github.com/ssbc/go-ssb-multiserver v0.1.5-0.20221019203850-917ae0e23d57
 	github.com/ssbc/go-ssb-refs v0.5.2-0.20221019090322-8b558c2f31de
+	github.com/ssbc/margaret v0.4.4-0.20221101112304-4f5815095ef3
+	github.com/yuin/goldmark v1.5.4
+	golang.org/x/image v0.1.0
+	golang.org/x/net v0.2.0
 	gopkg.in/yaml.v2 v2.4.0
 )
 
 require (
 	filippo.io/edwards25519 v1.0.0 // indirect
 	github.com/RoaringBitmap/roaring v1.2.1 // indirect
-	github.com/andybalholm/cascadia v1.3.1 // indirect
 	github.com/bits-and-blooms/bitset v1.3.3 // indirect
 	github.com/cespare/xxhash v1.1.0 // indirect
 	github.com/cespare/xxhash/v2 v2.1.2 // indirect
@@ -32,7 +48,6 @@ require (
 	github.com/golang/protobuf v1.5.2 // indirect
 	github.com/golang/snappy v0.0.4 // indirect
 	github.com/google/flatbuffers v22.10.26+incompatible // indirect
-	github.com/gorilla/websocket v1.5.0 // indirect
 	github.com/hashicorp/errwrap v1.1.0 // indirect
 	github.com/hashicorp/go-multierror v1.1.1 // indirect
 	github.com/json-iterator/go v1.1.12 // indirect
@@ -49,12 +64,8 @@ require (
 	github.com/pkg/errors v0.9.1 // indirect
 	github.com/rs/cors v1.8.2 // indirect
 	github.com/ssbc/go-gabbygrove v0.0.0-20221025092911-c274a44c3523 // indirect
-	github.com/ssbc/go-metafeed v1.1.3-0.20221019090205-458925e39156 // indirect
-	github.com/ssbc/go-muxrpc/v2 v2.0.14-0.20221111190521-10382533750c // indirect
 	github.com/ssbc/go-netwrap v0.1.5-0.20221019160355-cd323bb2e29d // indirect
 	github.com/ssbc/go-secretstream v1.2.11-0.20221111164233-4b41f899f844 // indirect
-	github.com/ssbc/go-ssb-multiserver v0.1.5-0.20221019203850-917ae0e23d57 // indirect
-	github.com/ssbc/margaret v0.4.4-0.20221101112304-4f5815095ef3 // indirect
 	github.com/ugorji/go/codec v1.2.7 // indirect
 	github.com/zeebo/bencode v1.0.0 // indirect
 	go.cryptoscope.co/nocomment v0.0.0-20210520094614-fb744e81f810 // indirect
@@ -62,7 +73,6 @@ require (
 	go.opencensus.io v0.23.0 // indirect
 	golang.org/x/crypto v0.2.0 // indirect
 	golang.org/x/exp v0.0.0-20221025133541-111beb427cde // indirect
-	golang.org/x/net v0.2.0 // indirect
 	golang.org/x/sync v0.1.0 // indirect
 	golang.org/x/sys v0.2.0 // indirect
 	golang.org/x/text v0.4.0 // indirect
@@ -0,0 +1,92 @@
+package pub
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/ssbc/go-muxrpc/v2"
+	"github.com/ssbc/go-ssb"
+	multiserver "github.com/ssbc/go-ssb-multiserver"
+	"github.com/ssbc/go-ssb/sbot"
+)
+
+const (
+	// minBackoff and maxBackoff bound the time between reconnection attempts.
+	minBackoff = 5 * time.Second
+	maxBackoff = 5 * time.Minute
+
+	// connectedCheck is how often an established connection is checked.
+	connectedCheck = 30 * time.Second
+)
+
+// connect dials the multiserver address and waits for the connection to be
+// established.
+func connect(pub *sbot.Sbot, address string) (muxrpc.Endpoint, error) {
+	addr, err := multiserver.ParseNetAddress([]byte(address))
+	if err != nil {
+		return nil, fmt.Errorf("connect: unable to parse %s: %w", address, err)
+	}
+
+	if edp, ok := pub.Network.GetEndpointFor(addr.Ref); ok {
+		return edp, nil
+	}
+
+	if err := pub.Network.Connect(context.TODO(), addr.WrappedAddr()); err != nil {
+		return nil, fmt.Errorf("connect: unable to connect to %s: %w", address, err)
+	}
+
+	for i := 0; i < 20; i++ {
+		if edp, ok := pub.Network.GetEndpointFor(addr.Ref); ok {
+			return edp, nil
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+
+	return nil, fmt.Errorf("connect: connection to %s was not established", address)
+}
+
+// isConnected reports whether the connection of edp is still established.
+func isConnected(pub *sbot.Sbot, edp muxrpc.Endpoint) bool {
+	remote, err := ssb.GetFeedRefFromAddr(edp.Remote())
+	if err != nil {
+		return false
+	}
+
+	current, ok := pub.Network.GetEndpointFor(remote)
+	return ok && current == edp
+}
+
+// keepConnected keeps a connection to the multiserver address returned by
+// resolve open, reconnecting with an exponential backoff. connected is called
+// every time the connection is (re-)established.
+func keepConnected(pub *sbot.Sbot, resolve func() (string, error), connected func(muxrpc.Endpoint)) {
+	backoff := minBackoff
+	for {
+		address, err := resolve()
+		if err == nil {
+			var edp muxrpc.Endpoint
+			edp, err = connect(pub, address)
+			if err == nil {
+				backoff = minBackoff
+				connected(edp)
+
+				for isConnected(pub, edp) {
+					time.Sleep(connectedCheck)
+				}
+
+				log.Printf("keepConnected: lost connection to %s", address)
+				continue
+			}
+		}
+
+		log.Printf("keepConnected: %s, retrying in %s", err, backoff)
+		time.Sleep(backoff)
+
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package pub
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// blobsDir is the directory of the blob store in the data directory.
+const blobsDir = "blobs"
+
+// DiskUsage is the disk usage (in bytes) of the data directory, split into
+// the blob store and the rest of the repo (log, indexes and state).
+type DiskUsage struct {
+	Repo  int64 `json:"repo"`
+	Blobs int64 `json:"blobs"`
+}
+
+// Total returns the disk usage of the entire data directory.
+func (u DiskUsage) Total() int64 {
+	return u.Repo + u.Blobs
+}
+
+// dirSize sums up the sizes of the files in a directory tree.
+func dirSize(root string) (int64, error) {
+	var size int64
+
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+
+		return nil
+	})
+
+	return size, err
+}
+
+// MeasureDiskUsage measures the disk usage of the data directory.
+func MeasureDiskUsage(cfg Config) (DiskUsage, error) {
+	dataDir, err := filepath.Abs(cfg.DataDir)
+	if err != nil {
+		return DiskUsage{}, fmt.Errorf("MeasureDiskUsage: unable to convert %s to an absolute path: %w", cfg.DataDir, err)
+	}
+
+	total, err := dirSize(dataDir)
+	if err != nil {
+		return DiskUsage{}, fmt.Errorf("MeasureDiskUsage: unable to measure %s: %w", dataDir, err)
+	}
+
+	blobs, err := dirSize(filepath.Join(dataDir, blobsDir))
+	if err != nil {
+		return DiskUsage{}, fmt.Errorf("MeasureDiskUsage: unable to measure %s: %w", blobsDir, err)
+	}
+
+	return DiskUsage{Repo: total - blobs, Blobs: blobs}, nil
+}
@@ -0,0 +1,67 @@
+package pub
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/skip2/go-qrcode"
+	"github.com/ssbc/go-ssb/sbot"
+)
+
+// mainNetCap is the secret-handshake capability of the main SSB network,
+// which the go-sbot joins.
+const mainNetCap = "1KHLiKZvAvjbY1ziZEHMXawbCEIM6qwjCDm3VYRan/s="
+
+// WriteInviteFile writes the public invite, the @id of the pub and its
+// network details to invite.txt in the data directory, so that they can be
+// published, e.g. on a website. When invite-qr is enabled, the invite is
+// also written as a QR code to invite.png, for scanning with Manyverse.
+func WriteInviteFile(cfg Config, pub *sbot.Sbot, token, host, port string) error {
+	dataDir, err := filepath.Abs(cfg.DataDir)
+	if err != nil {
+		return fmt.Errorf("WriteInviteFile: unable to convert %s to an absolute path: %w", cfg.DataDir, err)
+	}
+
+	if host == "" {
+		host = "localhost"
+	}
+
+	if port == "" {
+		port = cfg.Port
+	}
+
+	transport := "net"
+	if strings.HasSuffix(host, ".onion") {
+		transport = "onion"
+	}
+
+	id := pub.KeyPair.ID().String()
+	address := net.JoinHostPort(host, port)
+	key := strings.TrimSuffix(strings.TrimPrefix(id, "@"), ".ed25519")
+
+	var info strings.Builder
+	fmt.Fprintf(&info, "invite: %s\n", token)
+	fmt.Fprintf(&info, "id: %s\n", id)
+	fmt.Fprintf(&info, "address: %s\n", address)
+	fmt.Fprintf(&info, "multiserver: %s:%s~shs:%s\n", transport, address, key)
+	fmt.Fprintf(&info, "shs-cap: %s\n", mainNetCap)
+
+	infoPath := filepath.Join(dataDir, "invite.txt")
+	if err := os.WriteFile(infoPath, []byte(info.String()), 0644); err != nil {
+		return fmt.Errorf("WriteInviteFile: unable to write %s: %w", infoPath, err)
+	}
+
+	if !cfg.InviteQR {
+		return nil
+	}
+
+	qrPath := filepath.Join(dataDir, "invite.png")
+	if err := qrcode.WriteFile(token, qrcode.Medium, 512, qrPath); err != nil {
+		return fmt.Errorf("WriteInviteFile: unable to write %s: %w", qrPath, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,261 @@
+package pub
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ssbc/go-muxrpc/v2"
+	"github.com/ssbc/go-ssb"
+	refs "github.com/ssbc/go-ssb-refs"
+	ssbClient "github.com/ssbc/go-ssb/client"
+	"github.com/ssbc/go-ssb/plugins/legacyinvites"
+	"github.com/ssbc/go-ssb/sbot"
+)
+
+// invitesFile is the name of the file in the data directory which keeps
+// track of the minted invites.
+const invitesFile = "invites.json"
+
+// publicInviteNote is the note of the public invite.
+const publicInviteNote = "public invite"
+
+// InviteRecord is a minted invite. ID is the @id of the keypair derived from
+// the invite seed, which is what a guest connects with when redeeming it.
+// Only the token of the public invite is kept, so that it's reused.
+type InviteRecord struct {
+	ID      string    `json:"id"`
+	Uses    uint      `json:"uses"`
+	Note    string    `json:"note,omitempty"`
+	Created time.Time `json:"created"`
+	Revoked bool      `json:"revoked,omitempty"`
+	Public  bool      `json:"public,omitempty"`
+	Token   string    `json:"token,omitempty"`
+}
+
+// Invites is the register of minted invites. It's persisted as JSON in the
+// data directory. The go-sbot has no way to list or delete invites, so
+// revoked invites are refused when the guest connects.
+type Invites struct {
+	Records []InviteRecord `json:"invites"`
+
+	path string
+	mu   sync.Mutex
+}
+
+// LoadInvites loads the invite register from the data directory.
+func LoadInvites(dataDir string) (*Invites, error) {
+	dataDir, err := filepath.Abs(dataDir)
+	if err != nil {
+		return nil, fmt.Errorf("LoadInvites: unable to convert %s to an absolute path: %w", dataDir, err)
+	}
+
+	invites := &Invites{path: filepath.Join(dataDir, invitesFile)}
+
+	contents, err := os.ReadFile(invites.path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("LoadInvites: unable to read %s: %w", invites.path, err)
+	}
+
+	if len(contents) > 0 {
+		if err := json.Unmarshal(contents, invites); err != nil {
+			return nil, fmt.Errorf("LoadInvites: unable to unmarshal %s: %w", invites.path, err)
+		}
+	}
+
+	return invites, nil
+}
+
+// save persists the invite register. The caller must hold the lock.
+func (i *Invites) save() error {
+	contents, err := json.MarshalIndent(i, "", "  ")
+	if err != nil {
+		return fmt.Errorf("save: unable to marshal invites: %w", err)
+	}
+
+	tmpPath := i.path + ".tmp"
+	if err := os.WriteFile(tmpPath, contents, 0600); err != nil {
+		return fmt.Errorf("save: unable to write %s: %w", tmpPath, err)
+	}
+
+	if err := os.Rename(tmpPath, i.path); err != nil {
+		return fmt.Errorf("save: unable to rename %s: %w", tmpPath, err)
+	}
+
+	return nil
+}
+
+// Create mints an invite with the given number of uses by speaking to the
+// go-sbot and records it in the register. The listen address of the invite
+// is replaced by host and port, "localhost" and the listen port are used when
+// they're empty.
+func (i *Invites) Create(pub *sbot.Sbot, host, port string, uses uint, note string) (string, error) {
+	return i.create(pub, host, port, uses, note, false)
+}
+
+// PublicInvite returns the public invite of the pub, which is shared e.g. on
+// a website. The public invite in the register is reused unless it was
+// revoked, else one with the given number of uses is minted.
+func (i *Invites) PublicInvite(pub *sbot.Sbot, host, port string, uses uint) (string, error) {
+	i.mu.Lock()
+	var token string
+	for _, record := range i.Records {
+		if record.Public && !record.Revoked && record.Token != "" {
+			token = record.Token
+		}
+	}
+	i.mu.Unlock()
+
+	if token == "" {
+		return i.create(pub, host, port, uses, publicInviteNote, true)
+	}
+
+	token, err := inviteAddress(token, host, port)
+	if err != nil {
+		return token, fmt.Errorf("PublicInvite: %w", err)
+	}
+
+	return token, nil
+}
+
+// create mints an invite and records it in the register, along with its
+// token if it's the public invite.
+func (i *Invites) create(pub *sbot.Sbot, host, port string, uses uint, note string, public bool) (string, error) {
+	var token string
+
+	client, err := ssbClient.NewTCP(pub.KeyPair, pub.Network.GetListenAddr())
+	if err != nil {
+		return token, fmt.Errorf("Create: unable to initalise TCP client: %w", err)
+	}
+
+	args := legacyinvites.CreateArguments{Uses: uses, Note: note}
+	err = client.Async(context.TODO(), &token, muxrpc.TypeString, muxrpc.Method{"invite", "create"}, args)
+	if err != nil {
+		client.Close()
+		return token, fmt.Errorf("Create: unable to create invite: %w", err)
+	}
+
+	if err := client.Close(); err != nil {
+		return token, fmt.Errorf("Create: unable to close TCP client: %w", err)
+	}
+
+	id, err := inviteID(token)
+	if err != nil {
+		return token, fmt.Errorf("Create: %w", err)
+	}
+
+	record := InviteRecord{
+		ID:      id,
+		Uses:    uses,
+		Note:    note,
+		Created: time.Now(),
+		Public:  public,
+	}
+	if public {
+		record.Token = token
+	}
+
+	token, err = inviteAddress(token, host, port)
+	if err != nil {
+		return token, fmt.Errorf("Create: %w", err)
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.Records = append(i.Records, record)
+
+	if err := i.save(); err != nil {
+		return token, fmt.Errorf("Create: %w", err)
+	}
+
+	return token, nil
+}
+
+// List returns a copy of the invite register.
+func (i *Invites) List() []InviteRecord {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	return append([]InviteRecord{}, i.Records...)
+}
+
+// Revoke marks the invite with the given @id as revoked.
+func (i *Invites) Revoke(id string) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	for idx := range i.Records {
+		if i.Records[idx].ID != id {
+			continue
+		}
+
+		i.Records[idx].Revoked = true
+		if err := i.save(); err != nil {
+			return fmt.Errorf("Revoke: %w", err)
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("Revoke: unknown invite %s", id)
+}
+
+// revoked reports whether id belongs to a revoked invite.
+func (i *Invites) revoked(id string) bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	for _, record := range i.Records {
+		if record.ID == id {
+			return record.Revoked
+		}
+	}
+
+	return false
+}
+
+// refuseRevoked is a connection wrapper which drops connections made with the
+// keypair of a revoked invite.
+func (i *Invites) refuseRevoked(conn net.Conn) (net.Conn, error) {
+	remote, err := ssb.GetFeedRefFromAddr(conn.RemoteAddr())
+	if err != nil {
+		return conn, nil
+	}
+
+	if i.revoked(remote.String()) {
+		conn.Close()
+		return nil, fmt.Errorf("refuseRevoked: %s uses a revoked invite", remote.String())
+	}
+
+	return conn, nil
+}
+
+// inviteID derives the @id of the guest keypair from the seed of a legacy
+// invite token.
+func inviteID(token string) (string, error) {
+	idx := strings.LastIndex(token, "~")
+	if idx == -1 {
+		return "", fmt.Errorf("inviteID: unable to parse invite %s", token)
+	}
+
+	seed, err := base64.StdEncoding.DecodeString(token[idx+1:])
+	if err != nil {
+		return "", fmt.Errorf("inviteID: unable to decode invite seed: %w", err)
+	}
+
+	keyPair, err := ssb.NewKeyPair(bytes.NewReader(seed), refs.RefAlgoFeedSSB1)
+	if err != nil {
+		return "", fmt.Errorf("inviteID: unable to derive invite keypair: %w", err)
+	}
+
+	return keyPair.ID().String(), nil
+}
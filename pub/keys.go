@@ -0,0 +1,174 @@
+package pub
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ssbc/go-ssb"
+	refs "github.com/ssbc/go-ssb-refs"
+)
+
+// secretFile is the name of the file in the data directory which holds the
+// keypair of the pub identity.
+const secretFile = "secret"
+
+// parseSecretFile parses the keypair in the SSB secret file at path. Comment
+// lines, as written by the JavaScript implementation, are ignored.
+func parseSecretFile(path string) (ssb.KeyPair, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("parseSecretFile: unable to read %s: %w", path, err)
+	}
+
+	var stripped bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	for scanner.Scan() {
+		if strings.HasPrefix(strings.TrimSpace(scanner.Text()), "#") {
+			continue
+		}
+		stripped.WriteString(scanner.Text() + "\n")
+	}
+
+	keyPair, err := ssb.ParseKeyPair(&stripped)
+	if err != nil {
+		return nil, fmt.Errorf("parseSecretFile: unable to parse %s: %w", path, err)
+	}
+
+	return keyPair, nil
+}
+
+// loadKeyFile loads the keypair of the key-file option. The file must not be
+// accessible by the group or others.
+func loadKeyFile(path string) (ssb.KeyPair, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("loadKeyFile: %w", err)
+	}
+
+	if perms := info.Mode().Perm(); perms&0077 != 0 {
+		return nil, fmt.Errorf("loadKeyFile: %s is accessible by others (%s), restrict it with chmod 600", path, perms)
+	}
+
+	keyPair, err := parseSecretFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("loadKeyFile: %w", err)
+	}
+
+	return keyPair, nil
+}
+
+// ExportKey writes the keypair of the pub identity, from key-file or the data
+// directory, as a standard SSB secret file to w.
+func ExportKey(cfg Config, w io.Writer) error {
+	dataDir, err := filepath.Abs(cfg.DataDir)
+	if err != nil {
+		return fmt.Errorf("ExportKey: unable to convert %s to an absolute path: %w", cfg.DataDir, err)
+	}
+
+	var keyPair ssb.KeyPair
+	if cfg.KeyFile != "" {
+		keyPair, err = loadKeyFile(cfg.KeyFile)
+	} else {
+		keyPair, err = ssb.LoadKeyPair(filepath.Join(dataDir, secretFile))
+	}
+	if err != nil {
+		return fmt.Errorf("ExportKey: unable to load keypair: %w", err)
+	}
+
+	if err := ssb.EncodeKeyPairAsJSON(keyPair, w); err != nil {
+		return fmt.Errorf("ExportKey: %w", err)
+	}
+
+	return nil
+}
+
+// ImportKey makes the keypair in the SSB secret file at path, e.g. the one of
+// an existing identity, the pub identity. An existing different identity is
+// only replaced with force, it's kept next to the new one as a backup.
+func ImportKey(cfg Config, path string, force bool) (string, error) {
+	if cfg.KeyFile != "" {
+		return "", fmt.Errorf("ImportKey: key-file %s is configured, point it at %s instead", cfg.KeyFile, path)
+	}
+
+	keyPair, err := parseSecretFile(path)
+	if err != nil {
+		return "", fmt.Errorf("ImportKey: %w", err)
+	}
+
+	dataDir, err := filepath.Abs(cfg.DataDir)
+	if err != nil {
+		return "", fmt.Errorf("ImportKey: unable to convert %s to an absolute path: %w", cfg.DataDir, err)
+	}
+
+	secretPath := filepath.Join(dataDir, secretFile)
+	current, err := ssb.LoadKeyPair(secretPath)
+	if err != nil && !os.IsNotExist(err) {
+		return "", fmt.Errorf("ImportKey: unable to load current keypair: %w", err)
+	}
+
+	id := keyPair.ID().String()
+	if current != nil {
+		if current.ID().Equal(keyPair.ID()) {
+			return id, nil
+		}
+
+		if !force {
+			return "", fmt.Errorf("ImportKey: %s already holds the identity %s, use -force to replace it", dataDir, current.ID().String())
+		}
+
+		backupPath := fmt.Sprintf("%s.%d.bak", secretPath, time.Now().Unix())
+		if err := os.Rename(secretPath, backupPath); err != nil {
+			return "", fmt.Errorf("ImportKey: unable to back up %s: %w", secretPath, err)
+		}
+	}
+
+	if err := ssb.SaveKeyPair(keyPair, secretPath); err != nil {
+		return "", fmt.Errorf("ImportKey: %w", err)
+	}
+
+	return id, nil
+}
+
+// CreateKey generates the keypair of the pub identity in the data directory,
+// unless there is one already or key-file is configured. It returns the
+// identity either way.
+func CreateKey(cfg Config) (string, error) {
+	if cfg.KeyFile != "" {
+		keyPair, err := loadKeyFile(cfg.KeyFile)
+		if err != nil {
+			return "", fmt.Errorf("CreateKey: %w", err)
+		}
+		return keyPair.ID().String(), nil
+	}
+
+	dataDir, err := filepath.Abs(cfg.DataDir)
+	if err != nil {
+		return "", fmt.Errorf("CreateKey: unable to convert %s to an absolute path: %w", cfg.DataDir, err)
+	}
+
+	secretPath := filepath.Join(dataDir, secretFile)
+	keyPair, err := ssb.LoadKeyPair(secretPath)
+	if err == nil {
+		return keyPair.ID().String(), nil
+	}
+	if !os.IsNotExist(err) {
+		return "", fmt.Errorf("CreateKey: unable to load current keypair: %w", err)
+	}
+
+	keyPair, err = ssb.NewKeyPair(nil, refs.RefAlgoFeedSSB1)
+	if err != nil {
+		return "", fmt.Errorf("CreateKey: unable to generate keypair: %w", err)
+	}
+
+	if err := ssb.SaveKeyPair(keyPair, secretPath); err != nil {
+		return "", fmt.Errorf("CreateKey: %w", err)
+	}
+
+	return keyPair.ID().String(), nil
+}
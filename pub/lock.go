@@ -0,0 +1,51 @@
+package pub
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// lockFile is the name of the lock file in the data directory.
+const lockFile = "rss-butt-plug.lock"
+
+// Lock takes an exclusive lock on the data directory, so that no two
+// instances write to the same repo and state store. The lock is held until the
+// returned file is closed or the process exits. It fails right away when
+// another process holds it.
+func Lock(cfg Config) (*os.File, error) {
+	if err := os.MkdirAll(cfg.DataDir, 0700); err != nil {
+		return nil, fmt.Errorf("Lock: unable to create %s: %w", cfg.DataDir, err)
+	}
+
+	path := filepath.Join(cfg.DataDir, lockFile)
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("Lock: unable to open %s: %w", path, err)
+	}
+
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		defer file.Close()
+
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			holder := "another process"
+			if pid, err := os.ReadFile(path); err == nil && len(strings.TrimSpace(string(pid))) > 0 {
+				holder = "process " + strings.TrimSpace(string(pid))
+			}
+			return nil, fmt.Errorf("Lock: data-dir %s is in use by %s, is rss-butt-plug already running?", cfg.DataDir, holder)
+		}
+
+		return nil, fmt.Errorf("Lock: unable to lock %s: %w", path, err)
+	}
+
+	// the PID is only informational, the lock is what counts
+	if err := file.Truncate(0); err == nil {
+		file.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+
+	return file, nil
+}
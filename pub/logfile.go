@@ -0,0 +1,159 @@
+package pub
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultLogMaxSize is the default size (in bytes) above which the log file
+// is rotated.
+const defaultLogMaxSize = 10 * 1024 * 1024
+
+// defaultLogMaxFiles is the default number of rotated log files which are
+// kept.
+const defaultLogMaxFiles = 5
+
+// LogConfig configures logging to File, relative to the data directory. It's
+// rotated once it grows beyond MaxSize (bytes) or was written to for MaxAge,
+// keeping MaxFiles rotated files (File.1 being the newest). Stderr keeps
+// logging to stderr as well.
+type LogConfig struct {
+	File     string        `yaml:"file,omitempty"`
+	MaxSize  int64         `yaml:"max-size,omitempty"`
+	MaxAge   time.Duration `yaml:"max-age,omitempty"`
+	MaxFiles int           `yaml:"max-files,omitempty"`
+	Stderr   bool          `yaml:"stderr,omitempty"`
+}
+
+// kitLogger writes the key/value log lines of go-sbot as logfmt.
+type kitLogger struct {
+	w io.Writer
+}
+
+// Log writes a single log line.
+func (l kitLogger) Log(keyvals ...interface{}) error {
+	var line strings.Builder
+	line.WriteString("ts=" + time.Now().UTC().Format(time.RFC3339Nano))
+
+	for idx := 0; idx+1 < len(keyvals); idx += 2 {
+		value := fmt.Sprint(keyvals[idx+1])
+		if strings.ContainsAny(value, " =\"\n") || value == "" {
+			value = strconv.Quote(value)
+		}
+		fmt.Fprintf(&line, " %v=%s", keyvals[idx], value)
+	}
+	line.WriteString("\n")
+
+	_, err := io.WriteString(l.w, line.String())
+	return err
+}
+
+// rotatingFile is a log file which is rotated by size and age.
+type rotatingFile struct {
+	cfg    LogConfig
+	path   string
+	file   *os.File
+	size   int64
+	opened time.Time
+	mu     sync.Mutex
+}
+
+// OpenLog opens the log file of cfg in the data directory for appending,
+// together with stderr if configured.
+func OpenLog(cfg LogConfig, dataDir string) (io.Writer, error) {
+	if cfg.MaxSize <= 0 {
+		cfg.MaxSize = defaultLogMaxSize
+	}
+
+	if cfg.MaxFiles <= 0 {
+		cfg.MaxFiles = defaultLogMaxFiles
+	}
+
+	path := cfg.File
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dataDir, path)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("OpenLog: unable to create %s: %w", filepath.Dir(path), err)
+	}
+
+	logFile := &rotatingFile{cfg: cfg, path: path}
+	if err := logFile.open(); err != nil {
+		return nil, fmt.Errorf("OpenLog: %w", err)
+	}
+
+	if cfg.Stderr {
+		return io.MultiWriter(logFile, os.Stderr), nil
+	}
+
+	return logFile, nil
+}
+
+// open opens the log file for appending.
+func (r *rotatingFile) open() error {
+	file, err := os.OpenFile(r.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("open: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("open: %w", err)
+	}
+
+	r.file, r.size, r.opened = file, info.Size(), time.Now()
+
+	return nil
+}
+
+// rotate moves the log file to File.1, shifting the older ones up and
+// dropping the oldest, and starts a new one.
+func (r *rotatingFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return fmt.Errorf("rotate: %w", err)
+	}
+
+	os.Remove(fmt.Sprintf("%s.%d", r.path, r.cfg.MaxFiles))
+	for idx := r.cfg.MaxFiles - 1; idx > 0; idx-- {
+		os.Rename(fmt.Sprintf("%s.%d", r.path, idx), fmt.Sprintf("%s.%d", r.path, idx+1))
+	}
+
+	if err := os.Rename(r.path, r.path+".1"); err != nil {
+		return fmt.Errorf("rotate: %w", err)
+	}
+
+	return r.open()
+}
+
+// Write appends to the log file, rotating it first when it's due.
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	due := r.size > 0 && r.size+int64(len(p)) > r.cfg.MaxSize
+	if r.cfg.MaxAge > 0 && r.size > 0 && time.Since(r.opened) > r.cfg.MaxAge {
+		due = true
+	}
+
+	if due {
+		if err := r.rotate(); err != nil {
+			// keep logging to the current file rather than losing lines
+			if r.open() != nil {
+				return 0, err
+			}
+		}
+	}
+
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+
+	return n, err
+}
@@ -0,0 +1,38 @@
+package pub
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOpenLogRotates(t *testing.T) {
+	dataDir := t.TempDir()
+
+	w, err := OpenLog(LogConfig{File: "rss-butt-plug.log", MaxSize: 10, MaxFiles: 2}, dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for idx := 0; idx < 4; idx++ {
+		if _, err := fmt.Fprintf(w, "line %d\n", idx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	path := filepath.Join(dataDir, "rss-butt-plug.log")
+	for name, want := range map[string]string{path: "line 3\n", path + ".1": "line 2\n", path + ".2": "line 1\n"} {
+		contents, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(contents) != want {
+			t.Errorf("%s = %q, want %q", filepath.Base(name), contents, want)
+		}
+	}
+
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("more than MaxFiles rotated files are kept")
+	}
+}
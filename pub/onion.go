@@ -0,0 +1,79 @@
+package pub
+
+import (
+	"fmt"
+	"io"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cretz/bine/control"
+)
+
+// onionKeyFile is the name of the file in the data directory which holds the
+// private key of the onion service, so that its address is stable.
+const onionKeyFile = "onion.key"
+
+// TorConfig is the configuration of the Tor onion service which exposes the
+// SSB listener of the pub. The service is added through the control port of
+// a running Tor daemon.
+type TorConfig struct {
+	ControlAddr     string `yaml:"control-addr,omitempty"`
+	ControlPassword string `yaml:"control-password,omitempty"`
+}
+
+// ServeOnion exposes the SSB listener as a Tor onion service and returns its
+// .onion address and the Tor control connection which owns it. Tor removes
+// the service when the connection is closed, the private key is kept in the
+// data directory.
+func ServeOnion(cfg Config) (string, io.Closer, error) {
+	dataDir, err := filepath.Abs(cfg.DataDir)
+	if err != nil {
+		return "", nil, fmt.Errorf("ServeOnion: unable to convert %s to an absolute path: %w", cfg.DataDir, err)
+	}
+
+	keyPath := filepath.Join(dataDir, onionKeyFile)
+
+	var key control.Key = control.GenKey(control.KeyAlgoED25519V3)
+	contents, err := os.ReadFile(keyPath)
+	if err != nil && !os.IsNotExist(err) {
+		return "", nil, fmt.Errorf("ServeOnion: unable to read %s: %w", keyPath, err)
+	}
+	if len(contents) > 0 {
+		key, err = control.KeyFromString(strings.TrimSpace(string(contents)))
+		if err != nil {
+			return "", nil, fmt.Errorf("ServeOnion: unable to parse %s: %w", keyPath, err)
+		}
+	}
+
+	conn, err := textproto.Dial("tcp", cfg.Tor.ControlAddr)
+	if err != nil {
+		return "", nil, fmt.Errorf("ServeOnion: unable to connect to the Tor control port %s: %w", cfg.Tor.ControlAddr, err)
+	}
+
+	controller := control.NewConn(conn)
+	if err := controller.Authenticate(cfg.Tor.ControlPassword); err != nil {
+		controller.Close()
+		return "", nil, fmt.Errorf("ServeOnion: unable to authenticate with Tor: %w", err)
+	}
+
+	onion, err := controller.AddOnion(&control.AddOnionRequest{
+		Key:   key,
+		Ports: []*control.KeyVal{control.NewKeyVal(cfg.Port, "127.0.0.1:"+cfg.Port)},
+	})
+	if err != nil {
+		controller.Close()
+		return "", nil, fmt.Errorf("ServeOnion: unable to add onion service: %w", err)
+	}
+
+	if onion.Key != nil {
+		serialized := string(onion.Key.Type()) + ":" + onion.Key.Blob()
+		if err := os.WriteFile(keyPath, []byte(serialized), 0600); err != nil {
+			controller.Close()
+			return "", nil, fmt.Errorf("ServeOnion: unable to write %s: %w", keyPath, err)
+		}
+	}
+
+	return onion.ServiceID + ".onion", controller, nil
+}
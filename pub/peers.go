@@ -0,0 +1,35 @@
+package pub
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/ssbc/go-muxrpc/v2"
+	"github.com/ssbc/go-ssb/sbot"
+)
+
+// ConnectPeers connects the pub to the configured peers and keeps the
+// connections open in the background, so that content replicates outward
+// even when nobody dials in.
+func ConnectPeers(pub *sbot.Sbot, cfg Config) error {
+	for _, peer := range cfg.Peers {
+		if !strings.HasPrefix(peer, "net:") || !strings.Contains(peer, "~shs:") {
+			return fmt.Errorf("ConnectPeers: %s is not a net:<host>:<port>~shs:<key> multiserver address", peer)
+		}
+	}
+
+	for _, peer := range cfg.Peers {
+		peer := peer
+
+		resolve := func() (string, error) {
+			return peer, nil
+		}
+
+		go keepConnected(pub, resolve, func(edp muxrpc.Endpoint) {
+			log.Printf("ConnectPeers: connected to peer %s", edp.Remote())
+		})
+	}
+
+	return nil
+}
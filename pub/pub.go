@@ -0,0 +1,204 @@
+// Package pub manages the lifecycle of the internally managed go-sbot.
+package pub
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"path/filepath"
+	"strings"
+	"time"
+
+	refs "github.com/ssbc/go-ssb-refs"
+	ssbClient "github.com/ssbc/go-ssb/client"
+	"github.com/ssbc/go-ssb/sbot"
+)
+
+// Config is the configuration of the internally managed go-sbot. KeyFile is
+// a SSB secret file which holds the identity instead of the data directory.
+// PublicHost and PublicPort are the address under which the go-sbot is
+// reachable from the internet, they're used when minting invites. InviteQR
+// enables writing the public invite as a QR code. MaxDiskUsage is the quota (in
+// bytes) of the data directory, above which no more blobs are stored. MetaFeeds
+// makes the identity a metafeed with a subfeed per RSS source. Log configures
+// logging to a file.
+type Config struct {
+	DataDir    string       `yaml:"data-dir"`
+	KeyFile    string       `yaml:"key-file,omitempty"`
+	Addr       string       `yaml:"addr"`
+	Port       string       `yaml:"port"`
+	WsPort     string       `yaml:"ws-port"`
+	ShsCap     string       `yaml:"shs-cap"`
+	Hops       uint         `yaml:"hops"`
+	PublicHost string       `yaml:"public-host,omitempty"`
+	PublicPort string       `yaml:"public-port,omitempty"`
+	InviteQR   bool         `yaml:"invite-qr,omitempty"`
+	Tor        TorConfig    `yaml:"tor,omitempty"`
+	Rooms      []RoomConfig `yaml:"rooms,omitempty"`
+	Peers      []string     `yaml:"peers,omitempty"`
+
+	MaxDiskUsage int64 `yaml:"max-disk-usage,omitempty"`
+	MetaFeeds    bool  `yaml:"metafeeds,omitempty"`
+
+	Log LogConfig `yaml:"log,omitempty"`
+}
+
+// inviteAddress replaces the address of a legacy invite token, which is of
+// the form "<host>:<port>:<@id>~<seed>".
+func inviteAddress(token, host, port string) (string, error) {
+	idx := strings.Index(token, ":@")
+	if idx == -1 {
+		return token, fmt.Errorf("inviteAddress: unable to parse invite %s", token)
+	}
+
+	listenHost, listenPort, err := net.SplitHostPort(token[:idx])
+	if err != nil {
+		return token, fmt.Errorf("inviteAddress: unable to parse invite address: %w", err)
+	}
+
+	if host == "" {
+		host = listenHost
+		if host == "::" || host == "" {
+			host = "localhost"
+		}
+	}
+
+	if port == "" {
+		port = listenPort
+	}
+
+	return net.JoinHostPort(host, port) + token[idx:], nil
+}
+
+// New instantiates a new go-sbot instance. Connections using a revoked invite
+// of invites are refused. The go-sbot logs to logOutput, or stdout when it's
+// nil.
+func New(cfg Config, invites *Invites, logOutput io.Writer) (*sbot.Sbot, error) {
+	dataDir, err := filepath.Abs(cfg.DataDir)
+	if err != nil {
+		return nil, fmt.Errorf("New: unable to convert %s to an absolute path: %w", cfg.DataDir, err)
+	}
+
+	sbotOpts := []sbot.Option{
+		sbot.EnableAdvertismentBroadcasts(true),
+		sbot.EnableAdvertismentDialing(true),
+		sbot.LateOption(sbot.WithUNIXSocket()),
+		sbot.WithHops(cfg.Hops),
+		sbot.WithListenAddr(fmt.Sprintf(":%s", cfg.Port)),
+		sbot.WithPostSecureConnWrapper(invites.refuseRevoked),
+		sbot.WithRepoPath(dataDir),
+		sbot.WithWebsocketAddress(fmt.Sprintf(":%s", cfg.WsPort)),
+	}
+
+	if logOutput != nil {
+		sbotOpts = append(sbotOpts, sbot.WithInfo(kitLogger{logOutput}))
+	}
+
+	if cfg.MetaFeeds {
+		if cfg.KeyFile != "" {
+			return nil, fmt.Errorf("New: key-file can't be used with metafeeds, the metafeed key is kept in the data directory")
+		}
+		sbotOpts = append(sbotOpts, sbot.WithMetaFeedMode(true))
+	}
+
+	if cfg.KeyFile != "" {
+		keyPair, err := loadKeyFile(cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("New: %w", err)
+		}
+		sbotOpts = append(sbotOpts, sbot.WithKeyPair(keyPair))
+	}
+
+	pub, err := sbot.New(sbotOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("New: unable to initialise sbot: %w", err)
+	}
+
+	// an existing classic identity stays one, there's no way to turn it into a
+	// metafeed without losing its followers
+	if cfg.MetaFeeds && pub.KeyPair.ID().Algo() != refs.RefAlgoFeedBendyButt {
+		Close(pub)
+		return nil, fmt.Errorf("New: metafeeds need a new data-dir, %s holds the classic identity %s", dataDir, pub.KeyPair.ID().String())
+	}
+
+	return pub, nil
+}
+
+// Close shuts a go-sbot down.
+func Close(pub *sbot.Sbot) error {
+	pub.Shutdown()
+	if err := pub.Close(); err != nil {
+		return fmt.Errorf("Close: %w", err)
+	}
+
+	return nil
+}
+
+// Ping checks that the go-sbot of a running rss-butt-plug answers on the UNIX
+// socket in its data directory, by asking for its identity within timeout.
+func Ping(cfg Config, timeout time.Duration) (refs.FeedRef, error) {
+	dataDir, err := filepath.Abs(cfg.DataDir)
+	if err != nil {
+		return refs.FeedRef{}, fmt.Errorf("Ping: unable to convert %s to an absolute path: %w", cfg.DataDir, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	client, err := ssbClient.NewUnix(filepath.Join(dataDir, "socket"), ssbClient.WithContext(ctx))
+	if err != nil {
+		return refs.FeedRef{}, fmt.Errorf("Ping: %w", err)
+	}
+	defer client.Close()
+
+	id, err := client.Whoami()
+	if err != nil {
+		return refs.FeedRef{}, fmt.Errorf("Ping: go-sbot doesn't answer: %w", err)
+	}
+
+	return id, nil
+}
+
+// Serve serves a go-sbot over the network until ctx is cancelled. Errors of
+// the network listener are logged and it is restarted with an exponential
+// backoff, so that the feeds are still polled and published in the meantime.
+func Serve(ctx context.Context, pub *sbot.Sbot) {
+	backoff := minBackoff
+	for {
+		started := time.Now()
+		err := pub.Network.Serve(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err == nil {
+			sleep(ctx, 1*time.Second)
+			continue
+		}
+
+		if time.Since(started) > maxBackoff {
+			backoff = minBackoff
+		}
+
+		log.Printf("Serve: %s, restarting the network listener in %s", err, backoff)
+		sleep(ctx, backoff)
+
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// sleep pauses for d or until ctx is cancelled.
+func sleep(ctx context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,113 @@
+package pub
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+
+	"github.com/ssbc/go-ssb"
+	"github.com/ssbc/go-ssb/repo"
+	"github.com/ssbc/go-ssb/sbot"
+)
+
+// openOffline opens the repo of the data directory without networking, for
+// maintenance. rss-butt-plug must not be running.
+func openOffline(cfg Config, opts ...sbot.Option) (*sbot.Sbot, error) {
+	dataDir, err := filepath.Abs(cfg.DataDir)
+	if err != nil {
+		return nil, fmt.Errorf("openOffline: unable to convert %s to an absolute path: %w", cfg.DataDir, err)
+	}
+
+	opts = append(opts, sbot.DisableNetworkNode(), sbot.WithRepoPath(dataDir))
+
+	if cfg.KeyFile != "" {
+		keyPair, err := loadKeyFile(cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("openOffline: %w", err)
+		}
+		opts = append(opts, sbot.WithKeyPair(keyPair))
+	}
+
+	pub, err := sbot.New(opts...)
+	if err != nil {
+		return nil, fmt.Errorf("openOffline: unable to open repo (is rss-butt-plug still running?): %w", err)
+	}
+
+	return pub, nil
+}
+
+// CheckRepo checks the consistency of the log and the indexes with the fsck
+// of go-sbot and returns the problems it found. With repair, the messages of
+// inconsistent feeds are nulled so that they can be replicated again.
+func CheckRepo(cfg Config, repair bool) ([]string, error) {
+	pub, err := openOffline(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("CheckRepo: %w", err)
+	}
+	defer Close(pub)
+
+	pub.WaitUntilIndexesAreSynced()
+
+	var problems []string
+	for _, mode := range []sbot.FSCKMode{sbot.FSCKModeLength, sbot.FSCKModeSequences} {
+		err := pub.FSCK(sbot.FSCKWithMode(mode))
+		if err == nil {
+			continue
+		}
+
+		var wrongSequence ssb.ErrWrongSequence
+		var consistency sbot.ErrConsistencyProblems
+		switch {
+		case errors.As(err, &consistency):
+			for _, problem := range consistency.Errors {
+				problems = append(problems, problem.Error())
+			}
+
+			if repair {
+				if err := pub.HealRepo(consistency); err != nil {
+					return problems, fmt.Errorf("CheckRepo: unable to repair repo: %w", err)
+				}
+				log.Printf("CheckRepo: nulled %d message(s) of %d feed(s)", consistency.Sequences.GetCardinality(), len(consistency.Errors))
+			}
+		case errors.As(err, &wrongSequence):
+			problems = append(problems, wrongSequence.Error())
+
+			if repair {
+				if err := pub.NullFeed(wrongSequence.Ref); err != nil {
+					return problems, fmt.Errorf("CheckRepo: unable to repair repo: %w", err)
+				}
+				log.Printf("CheckRepo: nulled feed %s", wrongSequence.Ref.String())
+			}
+		default:
+			return problems, fmt.Errorf("CheckRepo: %w", err)
+		}
+
+		if repair {
+			break
+		}
+	}
+
+	return problems, nil
+}
+
+// CompactRepo drops the indexes of the repo and rebuilds them from the log.
+func CompactRepo(cfg Config) error {
+	dataDir, err := filepath.Abs(cfg.DataDir)
+	if err != nil {
+		return fmt.Errorf("CompactRepo: unable to convert %s to an absolute path: %w", cfg.DataDir, err)
+	}
+
+	if err := sbot.DropIndicies(repo.New(dataDir)); err != nil {
+		return fmt.Errorf("CompactRepo: unable to drop indexes: %w", err)
+	}
+
+	pub, err := openOffline(cfg, sbot.DisableLiveIndexMode())
+	if err != nil {
+		return fmt.Errorf("CompactRepo: %w", err)
+	}
+
+	Close(pub)
+
+	return nil
+}
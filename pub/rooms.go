@@ -0,0 +1,180 @@
+package pub
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/ssbc/go-muxrpc/v2"
+	"github.com/ssbc/go-ssb"
+	"github.com/ssbc/go-ssb/sbot"
+)
+
+// roomsFile is the name of the file in the data directory which remembers
+// the addresses of rooms joined with an invite, invites can only be used once.
+const roomsFile = "rooms.json"
+
+// RoomConfig is a SSB room server which the pub stays connected to, so that
+// peers which can't dial the pub directly reach it through a tunnel. Either
+// the multiserver address of the room or a room invite (its join link) is
+// required. Alias is registered on the room when set.
+type RoomConfig struct {
+	Address string `yaml:"address,omitempty"`
+	Invite  string `yaml:"invite,omitempty"`
+	Alias   string `yaml:"alias,omitempty"`
+}
+
+// roomsMu guards the rooms file.
+var roomsMu sync.Mutex
+
+// JoinRooms connects the pub to the configured rooms and keeps the
+// connections open in the background.
+func JoinRooms(pub *sbot.Sbot, cfg Config) error {
+	for idx, room := range cfg.Rooms {
+		if room.Address == "" && room.Invite == "" {
+			return fmt.Errorf("JoinRooms: room %d needs an address or an invite", idx+1)
+		}
+	}
+
+	dataDir, err := filepath.Abs(cfg.DataDir)
+	if err != nil {
+		return fmt.Errorf("JoinRooms: unable to convert %s to an absolute path: %w", cfg.DataDir, err)
+	}
+
+	for _, room := range cfg.Rooms {
+		room := room
+
+		resolve := func() (string, error) {
+			if room.Address != "" {
+				return room.Address, nil
+			}
+			return consumeRoomInvite(pub, filepath.Join(dataDir, roomsFile), room.Invite)
+		}
+
+		go keepConnected(pub, resolve, func(edp muxrpc.Endpoint) {
+			log.Printf("JoinRooms: connected to room %s", edp.Remote())
+
+			if room.Alias == "" {
+				return
+			}
+
+			aliasURL, err := registerAlias(pub, edp, room.Alias)
+			if err != nil {
+				log.Printf("JoinRooms: %s", err)
+				return
+			}
+
+			log.Printf("JoinRooms: registered alias %s", aliasURL)
+		})
+	}
+
+	return nil
+}
+
+// consumeRoomInvite claims a room invite over HTTP, following the rooms 2.0
+// specification, and returns the multiserver address of the room. Addresses
+// of claimed invites are kept in path.
+func consumeRoomInvite(pub *sbot.Sbot, path, invite string) (string, error) {
+	roomsMu.Lock()
+	defer roomsMu.Unlock()
+
+	joined := make(map[string]string)
+
+	contents, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return "", fmt.Errorf("consumeRoomInvite: unable to read %s: %w", path, err)
+	}
+
+	if len(contents) > 0 {
+		if err := json.Unmarshal(contents, &joined); err != nil {
+			return "", fmt.Errorf("consumeRoomInvite: unable to unmarshal %s: %w", path, err)
+		}
+	}
+
+	if address, ok := joined[invite]; ok {
+		return address, nil
+	}
+
+	inviteURL, err := url.Parse(invite)
+	if err != nil {
+		return "", fmt.Errorf("consumeRoomInvite: unable to parse %s: %w", invite, err)
+	}
+
+	token := inviteURL.Query().Get("token")
+	if token == "" {
+		return "", fmt.Errorf("consumeRoomInvite: %s has no invite token", invite)
+	}
+
+	body, err := json.Marshal(map[string]string{
+		"id":     pub.KeyPair.ID().String(),
+		"invite": token,
+	})
+	if err != nil {
+		return "", fmt.Errorf("consumeRoomInvite: unable to marshal request: %w", err)
+	}
+
+	consumeURL := url.URL{Scheme: inviteURL.Scheme, Host: inviteURL.Host, Path: "/invite/consume"}
+	resp, err := http.Post(consumeURL.String(), "application/json", bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("consumeRoomInvite: unable to claim invite: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Status             string `json:"status"`
+		Error              string `json:"error"`
+		MultiserverAddress string `json:"multiserverAddress"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("consumeRoomInvite: unable to decode response: %w", err)
+	}
+
+	if result.Status != "successful" || result.MultiserverAddress == "" {
+		return "", fmt.Errorf("consumeRoomInvite: unable to claim invite: %s", result.Error)
+	}
+
+	joined[invite] = result.MultiserverAddress
+
+	contents, err = json.MarshalIndent(joined, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("consumeRoomInvite: unable to marshal rooms: %w", err)
+	}
+
+	if err := os.WriteFile(path, contents, 0600); err != nil {
+		return "", fmt.Errorf("consumeRoomInvite: unable to write %s: %w", path, err)
+	}
+
+	return result.MultiserverAddress, nil
+}
+
+// registerAlias registers alias for the pub on the room connected through
+// edp and returns the alias URL.
+func registerAlias(pub *sbot.Sbot, edp muxrpc.Endpoint, alias string) (string, error) {
+	room, err := ssb.GetFeedRefFromAddr(edp.Remote())
+	if err != nil {
+		return "", fmt.Errorf("registerAlias: %w", err)
+	}
+
+	id := pub.KeyPair.ID().String()
+	registration := fmt.Sprintf("=room-alias-registration:%s:%s:%s", room.String(), id, alias)
+	signature := ed25519.Sign(ed25519.PrivateKey(pub.KeyPair.Secret()), []byte(registration))
+
+	var aliasURL string
+	method := muxrpc.Method{"room", "registerAlias"}
+	sig := base64.StdEncoding.EncodeToString(signature) + ".sig.ed25519"
+	if err := edp.Async(context.TODO(), &aliasURL, muxrpc.TypeString, method, alias, sig); err != nil {
+		return "", fmt.Errorf("registerAlias: unable to register %s: %w", alias, err)
+	}
+
+	return aliasURL, nil
+}
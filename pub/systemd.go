@@ -0,0 +1,51 @@
+package pub
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// SdNotify sends a state (e.g. "READY=1") to the systemd service manager over
+// $NOTIFY_SOCKET. It does nothing when not running as a Type=notify service.
+func SdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+
+	// a leading @ is the notation of systemd for an abstract socket
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return fmt.Errorf("SdNotify: unable to connect to %s: %w", socket, err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return fmt.Errorf("SdNotify: unable to send %s: %w", state, err)
+	}
+
+	return nil
+}
+
+// WatchdogInterval returns how often systemd expects a "WATCHDOG=1" keepalive,
+// half of WatchdogSec= to leave some slack, or 0 when the watchdog is
+// disabled.
+func WatchdogInterval() time.Duration {
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+
+	return time.Duration(usec) * time.Microsecond / 2
+}
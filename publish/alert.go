@@ -0,0 +1,70 @@
+package publish
+
+import (
+	"fmt"
+	"log"
+
+	refs "github.com/ssbc/go-ssb-refs"
+	"github.com/ssbc/go-ssb/sbot"
+
+	"decentral1se/rss-butt-plug/feed"
+)
+
+// AlertConfig configures where operator alerts are sent to: a private message
+// to the Admin identity, a JSON POST to Webhook and / or a XMPP notice.
+// Failures is the number of consecutive failed polls of a feed which raise an
+// alert.
+type AlertConfig struct {
+	Admin    string          `yaml:"admin,omitempty"`
+	Webhook  string          `yaml:"webhook,omitempty"`
+	XMPP     feed.XMPPConfig `yaml:"xmpp,omitempty"`
+	Failures int             `yaml:"failures,omitempty"`
+}
+
+// Enabled reports whether any alert output is configured.
+func (cfg AlertConfig) Enabled() bool {
+	return cfg.Admin != "" || cfg.Webhook != "" || cfg.XMPP.Enabled()
+}
+
+// ValidateAlert checks the admin identity and the XMPP account of the alerts,
+// if enabled.
+func ValidateAlert(cfg *AlertConfig) error {
+	if err := feed.ResolveXMPP(&cfg.XMPP); err != nil {
+		return fmt.Errorf("ValidateAlert: %w", err)
+	}
+
+	if cfg.Admin == "" {
+		return nil
+	}
+
+	if _, err := refs.ParseFeedRef(cfg.Admin); err != nil {
+		return fmt.Errorf("ValidateAlert: admin %s is not a SSB identity: %w", cfg.Admin, err)
+	}
+
+	return nil
+}
+
+// Alert logs an operator alert and sends it to the configured outputs.
+// Failing to send it is only logged, alerts are raised on errors already.
+func Alert(pub *sbot.Sbot, cfg AlertConfig, text string) {
+	log.Printf("Alert: %s", text)
+
+	if cfg.Admin != "" {
+		if err := sendPrivate(pub, cfg.Admin, "rss-butt-plug alert: "+text); err != nil {
+			log.Printf("Alert: %s", err)
+		}
+	}
+
+	if cfg.Webhook != "" {
+		payload := map[string]string{"kind": "alert", "text": text}
+		if err := sendWebhook(cfg.Webhook, payload); err != nil {
+			log.Printf("Alert: %s", err)
+		}
+	}
+
+	if cfg.XMPP.Enabled() {
+		if err := sendXMPP(cfg.XMPP, "rss-butt-plug alert: "+text); err != nil {
+			log.Printf("Alert: %s", err)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package publish
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	"github.com/ssbc/go-ssb/sbot"
+
+	"decentral1se/rss-butt-plug/feed"
+)
+
+// deadPostText is the final post of a feed which is gone.
+func deadPostText(cfg feed.Config, since time.Time, pollErr error) string {
+	reason := "it isn't a feed any more"
+
+	var httpErr gofeed.HTTPError
+	if errors.As(pollErr, &httpErr) {
+		reason = "it responds with " + httpErr.Status
+	}
+
+	return fmt.Sprintf("This mirror of %s has stopped: the feed has been gone since %s (%s), so nothing more will be published here.",
+		cfg.Feed, since.Format("2 January 2006"), reason)
+}
+
+// CheckDeadFeed records a failed poll of a feed. Errors which suggest that
+// the feed is gone start its dead streak, which only a successful poll ends.
+// Once the streak has lasted for dead-after, the feed is paused and its final
+// post is published if dead-post is set. It reports whether the feed was
+// paused.
+func CheckDeadFeed(pub *sbot.Sbot, cfg feed.Config, state *State, pollErr error) (bool, error) {
+	feedState := state.Feed(cfg.Feed)
+	if cfg.DeadAfter <= 0 || feedState.Paused || !feed.IsDeadError(pollErr) {
+		return false, nil
+	}
+
+	now := time.Now()
+	if feedState.DeadSince.IsZero() {
+		feedState.DeadSince = now
+	}
+
+	if now.Sub(feedState.DeadSince) < cfg.DeadAfter {
+		return false, nil
+	}
+
+	log.Printf("CheckDeadFeed: pausing %s, it has been gone since %s", cfg.Feed, feedState.DeadSince.Format(time.RFC3339))
+	feedState.Paused = true
+
+	if cfg.DeadPost {
+		as, err := author(pub, cfg.Feed, true)
+		if err != nil {
+			return true, fmt.Errorf("CheckDeadFeed: %w", err)
+		}
+
+		publish, err := openPublisher(pub, as)
+		if err != nil {
+			return true, fmt.Errorf("CheckDeadFeed: %w", err)
+		}
+
+		// the queue of a paused feed isn't published any more, so the final
+		// post is published right away
+		target, err := postPublisher(pub, publish, cfg, state)
+		if err != nil {
+			return true, fmt.Errorf("CheckDeadFeed: %w", err)
+		}
+
+		message := map[string]interface{}{
+			"type": "post",
+			"link": cfg.Feed,
+			"text": deadPostText(cfg, feedState.DeadSince, pollErr),
+		}
+		if cfg.Channel != "" {
+			message["channel"] = cfg.Channel
+		}
+
+		item, err := publishMessage(target, message, cfg, state)
+		if err != nil {
+			return true, fmt.Errorf("CheckDeadFeed: %w", err)
+		}
+
+		feedState.recordItem(item)
+		feedState.Published++
+		feedState.LastPublished = item.Published
+	}
+
+	if err := state.Save(); err != nil {
+		return true, fmt.Errorf("CheckDeadFeed: %w", err)
+	}
+
+	return true, nil
+}
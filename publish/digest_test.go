@@ -0,0 +1,96 @@
+package publish
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	refs "github.com/ssbc/go-ssb-refs"
+
+	"decentral1se/rss-butt-plug/feed"
+)
+
+// fakeMessage is a published message which only has a key.
+type fakeMessage struct {
+	refs.Message
+	key refs.MessageRef
+}
+
+func (m fakeMessage) Key() refs.MessageRef {
+	return m.key
+}
+
+// fakePublisher records the published messages instead of publishing them.
+type fakePublisher struct {
+	published []interface{}
+}
+
+func (p *fakePublisher) Publish(content interface{}) (refs.Message, error) {
+	p.published = append(p.published, content)
+
+	key, err := refs.NewMessageRefFromBytes(bytes.Repeat([]byte{byte(len(p.published))}, 32), refs.RefAlgoMessageSSB1)
+	if err != nil {
+		return nil, err
+	}
+
+	return fakeMessage{key: key}, nil
+}
+
+func TestPublishDigest(t *testing.T) {
+	state, err := LoadState(t.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := feed.Config{Feed: "https://example.org/feed.xml", Digest: "0 9 * * *"}
+	feedState := state.Feed(cfg.Feed)
+	feedState.LastDigest = time.Now().Add(-48 * time.Hour)
+	feedState.Digest = []map[string]interface{}{
+		{"type": "post", "link": "https://example.org/1", "text": "# One\n\nThe first"},
+		{"type": "post", "link": "https://example.org/2", "text": "# Two\n\nThe second"},
+	}
+
+	// the feeds are fetched while the digest is published
+	stop, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+
+			state.mu.Lock()
+			_ = state.Ignored["https://example.org/1"]
+			_ = len(state.Feed(cfg.Feed).Digest)
+			state.mu.Unlock()
+		}
+	}()
+
+	publish := &fakePublisher{}
+	err = publishDigest(publish, cfg, state)
+	close(stop)
+	<-done
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(publish.published) != 1 {
+		t.Fatalf("published %d messages, want the digest", len(publish.published))
+	}
+
+	if !state.Ignored["https://example.org/1"] || !state.Ignored["https://example.org/2"] {
+		t.Errorf("the items of the digest weren't ignored: %v", state.Ignored)
+	}
+
+	if len(feedState.Digest) != 0 || len(feedState.Items) != 1 || feedState.Items[0].Type != "digest" {
+		t.Errorf("digest %v left with items %v, want it published", feedState.Digest, feedState.Items)
+	}
+
+	// the next digest waits for the schedule
+	feedState.Digest = []map[string]interface{}{{"type": "post", "link": "https://example.org/3", "text": "three"}}
+	if err := publishDigest(publish, cfg, state); err != nil || len(publish.published) != 1 {
+		t.Errorf("publishDigest() published again before the next digest was due: %v", err)
+	}
+}
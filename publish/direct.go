@@ -0,0 +1,72 @@
+package publish
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	refs "github.com/ssbc/go-ssb-refs"
+	"github.com/ssbc/go-ssb/sbot"
+)
+
+// directPublisher publishes messages encrypted (box1) to a few recipients,
+// like a newsletter which is delivered as private messages.
+type directPublisher struct {
+	pub        *sbot.Sbot
+	publish    publisher
+	recipients []refs.FeedRef
+}
+
+// newDirectPublisher returns a publisher which encrypts messages to the
+// recipients and the pub identity itself, so that the pub can read back what
+// it published.
+func newDirectPublisher(pub *sbot.Sbot, publish publisher, recipients []string) (directPublisher, error) {
+	direct := directPublisher{pub: pub, publish: publish}
+
+	for _, recipient := range recipients {
+		ref, err := refs.ParseFeedRef(recipient)
+		if err != nil {
+			return direct, fmt.Errorf("newDirectPublisher: %w", err)
+		}
+		direct.recipients = append(direct.recipients, ref)
+	}
+	direct.recipients = append(direct.recipients, pub.KeyPair.ID())
+
+	return direct, nil
+}
+
+// Publish encrypts content to the recipients and publishes it.
+func (d directPublisher) Publish(content interface{}) (refs.Message, error) {
+	contents, err := json.Marshal(content)
+	if err != nil {
+		return nil, fmt.Errorf("Publish: unable to marshal message: %w", err)
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(contents, &fields); err != nil {
+		return nil, fmt.Errorf("Publish: only objects can be published to recipients: %w", err)
+	}
+
+	var recps []string
+	for _, recipient := range d.recipients {
+		recps = append(recps, recipient.String())
+	}
+	fields["recps"] = recps
+
+	plaintext, err := json.Marshal(fields)
+	if err != nil {
+		return nil, fmt.Errorf("Publish: unable to marshal message: %w", err)
+	}
+
+	ciphertext, err := d.pub.Groups.EncryptBox1(plaintext, d.recipients...)
+	if err != nil {
+		return nil, fmt.Errorf("Publish: %w", err)
+	}
+
+	message, err := d.publish.Publish(base64.StdEncoding.EncodeToString(ciphertext) + ".box")
+	if err != nil {
+		return nil, fmt.Errorf("Publish: failed to publish: %w", err)
+	}
+
+	return message, nil
+}
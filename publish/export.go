@@ -0,0 +1,216 @@
+package publish
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	refs "github.com/ssbc/go-ssb-refs"
+	"github.com/ssbc/go-ssb/sbot"
+)
+
+// archive is the JSON archive written by Export.
+type archive struct {
+	Name     string         `json:"name"`
+	Identity string         `json:"identity"`
+	Exported time.Time      `json:"exported"`
+	Posts    []archivedPost `json:"posts"`
+}
+
+// archivedPost is a post in the JSON archive. Blobs maps the blob references
+// in Markdown to the exported files, relative to the archive.
+type archivedPost struct {
+	Key       string            `json:"key"`
+	Title     string            `json:"title"`
+	Link      string            `json:"link,omitempty"`
+	Published time.Time         `json:"published"`
+	Markdown  string            `json:"markdown"`
+	Page      string            `json:"page"`
+	Blobs     map[string]string `json:"blobs,omitempty"`
+}
+
+// exportFileName turns a message or blob reference into a file name, the
+// base64 of its hash made safe for paths and URLs.
+func exportFileName(ref string) string {
+	name := strings.TrimLeft(ref, "%&")
+	name = strings.TrimSuffix(name, ".sha256")
+	name = strings.TrimRight(name, "=")
+
+	return strings.NewReplacer("/", "_", "+", "-").Replace(name)
+}
+
+// exportBlob copies a blob to the blobs directory of the export, unless it's
+// there already. It returns the path of the file relative to the export.
+func exportBlob(pub *sbot.Sbot, dir, ref string) (string, error) {
+	path := filepath.Join("blobs", exportFileName(ref))
+	if _, err := os.Stat(filepath.Join(dir, path)); err == nil {
+		return path, nil
+	}
+
+	blobRef, err := refs.ParseBlobRef(ref)
+	if err != nil {
+		return "", fmt.Errorf("exportBlob: %w", err)
+	}
+
+	blob, err := pub.BlobStore.Get(blobRef)
+	if err != nil {
+		return "", fmt.Errorf("exportBlob: unable to retrieve %s: %w", ref, err)
+	}
+	defer blob.Close()
+
+	file, err := os.Create(filepath.Join(dir, path))
+	if err != nil {
+		return "", fmt.Errorf("exportBlob: %w", err)
+	}
+
+	if _, err := io.Copy(file, blob); err != nil {
+		file.Close()
+		return "", fmt.Errorf("exportBlob: unable to write %s: %w", ref, err)
+	}
+
+	if err := file.Close(); err != nil {
+		return "", fmt.Errorf("exportBlob: %w", err)
+	}
+
+	return path, nil
+}
+
+// exportIndexTemplate renders the index.html of the static site.
+var exportIndexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Name}}</title>
+</head>
+<body>
+<h1>{{.Name}}</h1>
+<p>The posts of <code>{{.Identity}}</code> on SSB, exported {{.Exported.Format "2006-01-02"}}.</p>
+<ul>
+{{range .Posts}}<li><a href="{{.Page}}">{{.Title}}</a> ({{.Published.Format "2006-01-02"}})</li>
+{{end}}</ul>
+</body>
+</html>
+`))
+
+// exportPostTemplate renders the page of a post of the static site.
+var exportPostTemplate = template.Must(template.New("post").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+</head>
+<body>
+<p><a href="../index.html">{{.Name}}</a></p>
+<h1>{{.Title}}</h1>
+<p>{{.Published.Format "2006-01-02 15:04"}}{{if .Link}}, <a href="{{.Link}}">original</a>{{end}}, <code>{{.Key}}</code></p>
+{{.HTML}}
+</body>
+</html>
+`))
+
+// writeTemplate renders a template into a file.
+func writeTemplate(path string, tmpl *template.Template, data interface{}) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("writeTemplate: %w", err)
+	}
+
+	if err := tmpl.Execute(file, data); err != nil {
+		file.Close()
+		return fmt.Errorf("writeTemplate: unable to render %s: %w", path, err)
+	}
+
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("writeTemplate: %w", err)
+	}
+
+	return nil
+}
+
+// Export writes the posts and blogs published by the pub identity (and its
+// subfeeds) to dir as a static site (index.html and a page per post) and a
+// JSON archive (archive.json), together with the blobs they refer to. That way
+// the mirrored history survives the loss of the SSB repo and can be rehosted.
+// It returns the number of exported posts.
+func Export(pub *sbot.Sbot, dir string) (int, error) {
+	name, entries, err := ownEntries(pub, 0)
+	if err != nil {
+		return 0, fmt.Errorf("Export: %w", err)
+	}
+
+	for _, sub := range []string{"posts", "blobs"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			return 0, fmt.Errorf("Export: %w", err)
+		}
+	}
+
+	exported := archive{
+		Name:     name,
+		Identity: pub.KeyPair.ID().String(),
+		Exported: time.Now(),
+		Posts:    []archivedPost{},
+	}
+
+	for _, entry := range entries {
+		post := archivedPost{
+			Key:       entry.Key,
+			Title:     entry.Title,
+			Link:      entry.Link,
+			Published: entry.Published,
+			Markdown:  entry.Markdown,
+			Page:      filepath.ToSlash(filepath.Join("posts", exportFileName(entry.Key)+".html")),
+			Blobs:     make(map[string]string),
+		}
+
+		for _, ref := range blobRefRegex.FindAllString(entry.Markdown, -1) {
+			path, err := exportBlob(pub, dir, ref)
+			if err != nil {
+				log.Printf("Export: %s: %s", entry.Key, err)
+				continue
+			}
+			post.Blobs[ref] = filepath.ToSlash(path)
+		}
+
+		page := struct {
+			archivedPost
+			Name string
+			HTML template.HTML
+		}{post, name, template.HTML(entryHTML(entry.Markdown, func(ref string) string {
+			if path, ok := post.Blobs[ref]; ok {
+				return "../" + path
+			}
+			return ref
+		}))}
+
+		if err := writeTemplate(filepath.Join(dir, post.Page), exportPostTemplate, page); err != nil {
+			return 0, fmt.Errorf("Export: %w", err)
+		}
+
+		exported.Posts = append(exported.Posts, post)
+	}
+
+	if err := writeTemplate(filepath.Join(dir, "index.html"), exportIndexTemplate, exported); err != nil {
+		return 0, fmt.Errorf("Export: %w", err)
+	}
+
+	var contents bytes.Buffer
+	encoder := json.NewEncoder(&contents)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(exported); err != nil {
+		return 0, fmt.Errorf("Export: unable to marshal archive: %w", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "archive.json"), contents.Bytes(), 0644); err != nil {
+		return 0, fmt.Errorf("Export: %w", err)
+	}
+
+	return len(exported.Posts), nil
+}
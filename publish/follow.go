@@ -0,0 +1,134 @@
+package publish
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/ssbc/go-luigi"
+	refs "github.com/ssbc/go-ssb-refs"
+	"github.com/ssbc/go-ssb/sbot"
+	"github.com/ssbc/margaret"
+)
+
+// contact is the content of a contact message.
+type contact struct {
+	Type      string `json:"type"`
+	Contact   string `json:"contact"`
+	Following *bool  `json:"following"`
+}
+
+// FollowBack follows the identities which follow the pub identity (or one of
+// its subfeeds) and aren't followed back yet. When allow isn't empty, only the identities in allow are
+// followed back. The contact messages are read from state.FollowSeq on, the
+// follows found so far are kept in the state store. It returns the number of
+// published follows.
+func FollowBack(pub *sbot.Sbot, allow []string, state *State) (int, error) {
+	self, err := ownFeeds(pub)
+	if err != nil {
+		return 0, fmt.Errorf("FollowBack: %w", err)
+	}
+
+	src, err := pub.ReceiveLog.Query(margaret.SeqWrap(true), margaret.Gte(state.FollowSeq))
+	if err != nil {
+		return 0, fmt.Errorf("FollowBack: unable to query log: %w", err)
+	}
+
+	followers := make(map[string]bool)
+	followed := make(map[string]bool)
+	nextSeq := state.FollowSeq
+	for {
+		v, err := src.Next(context.Background())
+		if luigi.IsEOS(err) {
+			break
+		}
+
+		wrapped, ok := v.(margaret.SeqWrapper)
+		if !ok {
+			continue
+		}
+		nextSeq = wrapped.Seq() + 1
+
+		msg, ok := wrapped.Value().(refs.Message)
+		if !ok {
+			continue
+		}
+
+		var content contact
+		if err := json.Unmarshal(msg.ContentBytes(), &content); err != nil {
+			continue
+		}
+
+		if content.Type != "contact" || content.Following == nil {
+			continue
+		}
+
+		author := msg.Author().String()
+		switch {
+		case self[author]:
+			followed[content.Contact] = *content.Following
+		case self[content.Contact]:
+			followers[author] = *content.Following
+		}
+	}
+
+	state.mu.Lock()
+	for id, following := range followers {
+		state.Followers[id] = following
+	}
+	for id, following := range followed {
+		state.Followed[id] = following
+	}
+	state.FollowSeq = nextSeq
+
+	var unfollowed []string
+	for follower, following := range state.Followers {
+		if following && !state.Followed[follower] {
+			unfollowed = append(unfollowed, follower)
+		}
+	}
+	state.mu.Unlock()
+	sort.Strings(unfollowed)
+
+	if err := state.Save(); err != nil {
+		return 0, fmt.Errorf("FollowBack: %w", err)
+	}
+
+	allowed := make(map[string]bool)
+	for _, id := range allow {
+		allowed[id] = true
+	}
+
+	var publish publisher
+	published := 0
+	for _, follower := range unfollowed {
+		if len(allowed) > 0 && !allowed[follower] {
+			continue
+		}
+
+		if publish == nil {
+			as, err := author(pub, mainPurpose, true)
+			if err != nil {
+				return published, fmt.Errorf("FollowBack: %w", err)
+			}
+
+			publish, err = openPublisher(pub, as)
+			if err != nil {
+				return published, fmt.Errorf("FollowBack: %w", err)
+			}
+		}
+
+		log.Printf("FollowBack: following back %s", follower)
+
+		yes := true
+		if _, err := publish.Publish(contact{Type: "contact", Contact: follower, Following: &yes}); err != nil {
+			return published, fmt.Errorf("FollowBack: failed to publish: %w", err)
+		}
+
+		published++
+	}
+
+	return published, nil
+}
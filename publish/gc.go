@@ -0,0 +1,150 @@
+package publish
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/ssbc/go-luigi"
+	refs "github.com/ssbc/go-ssb-refs"
+	"github.com/ssbc/go-ssb/sbot"
+)
+
+// UnreferencedBlob is a blob which no message refers to.
+type UnreferencedBlob struct {
+	Ref  string
+	Size int64
+}
+
+// referencedBlobs gathers the blob references in data into found. The "&" of
+// a reference may be escaped as \u0026 in JSON, as it is by the private
+// publishers.
+func referencedBlobs(data []byte, found map[string]bool) {
+	for _, ref := range blobRefRegex.FindAllString(strings.ReplaceAll(string(data), `\u0026`, "&"), -1) {
+		found[ref] = true
+	}
+}
+
+// CollectBlobs deletes the blobs which aren't referenced by any message in
+// the log (including the bodies of blog messages and the own private posts)
+// or by the messages waiting in the state store. Nothing is deleted
+// when dryRun is set. It returns the unreferenced blobs.
+func CollectBlobs(pub *sbot.Sbot, state *State, dryRun bool) ([]UnreferencedBlob, error) {
+	referenced := make(map[string]bool)
+
+	src, err := pub.ReceiveLog.Query()
+	if err != nil {
+		return nil, fmt.Errorf("CollectBlobs: unable to query log: %w", err)
+	}
+
+	var blogs []string
+	for {
+		v, err := src.Next(context.Background())
+		if luigi.IsEOS(err) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("CollectBlobs: unable to read log: %w", err)
+		}
+
+		msg, ok := v.(refs.Message)
+		if !ok {
+			continue
+		}
+
+		data := msg.ContentBytes()
+		if len(data) > 0 && data[0] == '"' && msg.Author().Equal(pub.KeyPair.ID()) {
+			// own posts to a private group (box2) or to recipients (box1)
+			// are boxed strings, their blob references only show once
+			// decrypted
+			plaintext, err := pub.Groups.DecryptMessage(msg)
+			if err != nil {
+				return nil, fmt.Errorf("CollectBlobs: unable to decrypt %s, its blobs can't be told apart: %w", msg.Key().String(), err)
+			}
+			data = plaintext
+		}
+
+		referencedBlobs(data, referenced)
+
+		var content struct {
+			Type string `json:"type"`
+			Blog string `json:"blog"`
+		}
+		if err := json.Unmarshal(data, &content); err == nil && content.Type == "blog" {
+			blogs = append(blogs, content.Blog)
+		}
+	}
+
+	for _, blog := range blogs {
+		ref, err := refs.ParseBlobRef(blog)
+		if err != nil {
+			continue
+		}
+
+		blob, err := pub.BlobStore.Get(ref)
+		if err != nil {
+			continue
+		}
+
+		body, err := io.ReadAll(blob)
+		blob.Close()
+		if err != nil {
+			return nil, fmt.Errorf("CollectBlobs: unable to read blog %s: %w", blog, err)
+		}
+
+		referencedBlobs(body, referenced)
+	}
+
+	state.mu.Lock()
+	pending, err := marshalUnescaped(state)
+	state.mu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("CollectBlobs: unable to marshal state: %w", err)
+	}
+	referencedBlobs(pending, referenced)
+
+	var unreferenced []UnreferencedBlob
+
+	blobs := pub.BlobStore.List()
+	for {
+		v, err := blobs.Next(context.Background())
+		if luigi.IsEOS(err) {
+			break
+		} else if err != nil {
+			return unreferenced, fmt.Errorf("CollectBlobs: unable to list blobs: %w", err)
+		}
+
+		ref, ok := v.(refs.BlobRef)
+		if !ok || referenced[ref.String()] {
+			continue
+		}
+
+		size, err := pub.BlobStore.Size(ref)
+		if err != nil {
+			return unreferenced, fmt.Errorf("CollectBlobs: %w", err)
+		}
+
+		unreferenced = append(unreferenced, UnreferencedBlob{Ref: ref.String(), Size: size})
+	}
+
+	if dryRun {
+		return unreferenced, nil
+	}
+
+	for _, blob := range unreferenced {
+		ref, err := refs.ParseBlobRef(blob.Ref)
+		if err != nil {
+			return unreferenced, fmt.Errorf("CollectBlobs: %w", err)
+		}
+
+		if err := pub.BlobStore.Delete(ref); err != nil {
+			return unreferenced, fmt.Errorf("CollectBlobs: unable to delete %s: %w", blob.Ref, err)
+		}
+
+		log.Printf("CollectBlobs: deleted %s", blob.Ref)
+	}
+
+	return unreferenced, nil
+}
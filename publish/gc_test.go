@@ -0,0 +1,129 @@
+package publish
+
+import (
+	"strings"
+	"testing"
+
+	refs "github.com/ssbc/go-ssb-refs"
+	"github.com/ssbc/go-ssb/sbot"
+)
+
+// newTestPub starts a go-sbot without networking in a temporary directory,
+// as a metafeed if metafeeds is set.
+func newTestPub(t *testing.T, metafeeds bool) *sbot.Sbot {
+	t.Helper()
+
+	pub, err := sbot.New(
+		sbot.WithRepoPath(t.TempDir()),
+		sbot.DisableNetworkNode(),
+		sbot.WithMetaFeedMode(metafeeds),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		pub.Shutdown()
+		pub.Close()
+	})
+
+	return pub
+}
+
+// putTestBlob stores contents as a blob and returns its reference.
+func putTestBlob(t *testing.T, pub *sbot.Sbot, contents string) string {
+	t.Helper()
+
+	ref, err := pub.BlobStore.Put(strings.NewReader(contents))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ref.String()
+}
+
+// publishTest publishes content as the pub identity (or its main subfeed)
+// and returns the key of the message.
+func publishTest(t *testing.T, pub *sbot.Sbot, content interface{}) string {
+	t.Helper()
+
+	as, err := author(pub, mainPurpose, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publish, err := openPublisher(pub, as)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message, err := publish.Publish(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return message.Key().String()
+}
+
+func TestCollectBlobs(t *testing.T) {
+	pub := newTestPub(t, false)
+
+	state, err := LoadState(t.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	posted := putTestBlob(t, pub, "image of a post")
+	inBlog := putTestBlob(t, pub, "image of a blog")
+	blog := putTestBlob(t, pub, "# Blog\n\n![image]("+inBlog+")")
+	private := putTestBlob(t, pub, "image of a private post")
+	pending := putTestBlob(t, pub, "image of a held post")
+	orphan := putTestBlob(t, pub, "image of nothing")
+
+	publishTest(t, pub, map[string]interface{}{"type": "post", "text": "![image](" + posted + ")"})
+	publishTest(t, pub, map[string]interface{}{"type": "blog", "title": "Blog", "blog": blog})
+	if err := sendPrivate(pub, pub.KeyPair.ID().String(), "![image]("+private+")"); err != nil {
+		t.Fatal(err)
+	}
+	state.Feed("https://example.org/feed.xml").Pending = []PendingItem{{
+		ID:      "held",
+		Message: map[string]interface{}{"type": "post", "text": "![image](" + pending + ")"},
+	}}
+
+	unreferenced, err := CollectBlobs(pub, state, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unreferenced) != 1 || unreferenced[0].Ref != orphan {
+		t.Fatalf("CollectBlobs() = %v, want only %s", unreferenced, orphan)
+	}
+	if !hasTestBlob(t, pub, orphan) {
+		t.Fatalf("CollectBlobs() deleted %s on a dry run", orphan)
+	}
+
+	if _, err := CollectBlobs(pub, state, false); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ref := range []string{posted, inBlog, blog, private, pending} {
+		if !hasTestBlob(t, pub, ref) {
+			t.Errorf("CollectBlobs() deleted the referenced blob %s", ref)
+		}
+	}
+	if hasTestBlob(t, pub, orphan) {
+		t.Errorf("CollectBlobs() kept the unreferenced blob %s", orphan)
+	}
+}
+
+// hasTestBlob reports whether the blob ref is stored.
+func hasTestBlob(t *testing.T, pub *sbot.Sbot, ref string) bool {
+	t.Helper()
+
+	blobRef, err := refs.ParseBlobRef(ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = pub.BlobStore.Size(blobRef)
+	return err == nil
+}
@@ -0,0 +1,133 @@
+package publish
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	refs "github.com/ssbc/go-ssb-refs"
+	"github.com/ssbc/go-ssb/sbot"
+
+	"decentral1se/rss-butt-plug/feed"
+)
+
+// groupPublisher publishes messages encrypted (box2) to a private group.
+type groupPublisher struct {
+	pub   *sbot.Sbot
+	group refs.MessageRef
+}
+
+// groupMessage is a message published to a private group, the groups manager
+// only returns its key.
+type groupMessage struct {
+	refs.Message
+	key refs.MessageRef
+}
+
+// Key returns the key of the message.
+func (m groupMessage) Key() refs.MessageRef {
+	return m.key
+}
+
+// Publish encrypts content to the group and publishes it.
+func (g groupPublisher) Publish(content interface{}) (refs.Message, error) {
+	contents, err := json.Marshal(content)
+	if err != nil {
+		return nil, fmt.Errorf("Publish: unable to marshal message: %w", err)
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(contents, &fields); err != nil {
+		return nil, fmt.Errorf("Publish: only objects can be published to a group: %w", err)
+	}
+	fields["recps"] = []string{g.group.String()}
+
+	contents, err = json.Marshal(fields)
+	if err != nil {
+		return nil, fmt.Errorf("Publish: unable to marshal message: %w", err)
+	}
+
+	key, err := g.pub.Groups.PublishTo(g.group, contents)
+	if err != nil {
+		return nil, fmt.Errorf("Publish: unable to publish to group %s: %w", g.group.String(), err)
+	}
+
+	return groupMessage{key: key}, nil
+}
+
+// postPublisher returns the publisher of the posts of a feed: its private
+// group or its recipients, if configured, or else the public publisher. About
+// messages are always public.
+func postPublisher(pub *sbot.Sbot, public publisher, cfg feed.Config, state *State) (publisher, error) {
+	if len(cfg.Recipients) > 0 {
+		return newDirectPublisher(pub, public, cfg.Recipients)
+	}
+
+	if cfg.PrivateGroup.Name == "" {
+		return public, nil
+	}
+
+	group, err := ensureGroup(pub, cfg, state)
+	if err != nil {
+		return nil, fmt.Errorf("postPublisher: %w", err)
+	}
+
+	return groupPublisher{pub: pub, group: group}, nil
+}
+
+// ensureGroup returns the private group of a feed. The group is created the
+// first time and the configured members which weren't added yet are added,
+// both are recorded in the state store.
+func ensureGroup(pub *sbot.Sbot, cfg feed.Config, state *State) (refs.MessageRef, error) {
+	feedState := state.Feed(cfg.Feed)
+
+	if feedState.Group == "" {
+		// box2 needs the previous message of the feed, which a new identity
+		// doesn't have until its about message is published
+		if current, err := pub.CurrentSequence(pub.KeyPair.ID()); err != nil || current.Seq < 0 {
+			return refs.MessageRef{}, fmt.Errorf("ensureGroup: unable to create group %s before the first public message of %s", cfg.PrivateGroup.Name, pub.KeyPair.ID().String())
+		}
+
+		group, _, err := pub.Groups.Create(cfg.PrivateGroup.Name)
+		if err != nil {
+			return refs.MessageRef{}, fmt.Errorf("ensureGroup: unable to create group %s: %w", cfg.PrivateGroup.Name, err)
+		}
+
+		log.Printf("ensureGroup: created private group %s for %s", group.String(), cfg.Feed)
+
+		feedState.Group = group.String()
+		if err := state.Save(); err != nil {
+			return refs.MessageRef{}, fmt.Errorf("ensureGroup: %w", err)
+		}
+	}
+
+	group, err := refs.ParseMessageRef(feedState.Group)
+	if err != nil {
+		return refs.MessageRef{}, fmt.Errorf("ensureGroup: %w", err)
+	}
+
+	for _, member := range cfg.PrivateGroup.Members {
+		if containsString(feedState.GroupMembers, member) {
+			continue
+		}
+
+		memberRef, err := refs.ParseFeedRef(member)
+		if err != nil {
+			return group, fmt.Errorf("ensureGroup: %w", err)
+		}
+
+		welcome := fmt.Sprintf("Welcome to %s, the members-only mirror of %s.", cfg.PrivateGroup.Name, cfg.Feed)
+		if _, err := pub.Groups.AddMember(group, memberRef, welcome); err != nil {
+			return group, fmt.Errorf("ensureGroup: unable to add %s to the group: %w", member, err)
+		}
+
+		log.Printf("ensureGroup: added %s to the private group of %s", member, cfg.Feed)
+
+		feedState.GroupMembers = append(feedState.GroupMembers, member)
+		if err := state.Save(); err != nil {
+			return group, fmt.Errorf("ensureGroup: %w", err)
+		}
+	}
+
+	return group, nil
+}
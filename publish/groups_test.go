@@ -0,0 +1,79 @@
+package publish
+
+import (
+	"testing"
+	"time"
+
+	"decentral1se/rss-butt-plug/feed"
+)
+
+func TestPrivateGroup(t *testing.T) {
+	pub := newTestPub(t, false)
+
+	state, err := LoadState(t.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := feed.Config{Feed: "https://example.org/feed.xml", PrivateGroup: feed.GroupConfig{Name: "members"}}
+	public := &fakePublisher{}
+
+	if _, err := postPublisher(pub, public, cfg, state); err == nil {
+		t.Error("postPublisher() created a group before the first public message")
+	}
+
+	publishTest(t, pub, map[string]interface{}{"type": "about", "about": pub.KeyPair.ID().String(), "name": "Example"})
+
+	// the feed index is updated in the background
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if current, err := pub.CurrentSequence(pub.KeyPair.ID()); err == nil && current.Seq >= 0 {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatal("the about message wasn't indexed")
+		}
+	}
+
+	publish, err := postPublisher(pub, public, cfg, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	group := state.Feed(cfg.Feed).Group
+	if group == "" {
+		t.Fatal("the group wasn't recorded in the state store")
+	}
+
+	image := putTestBlob(t, pub, "image of a members-only post")
+	if _, err := publish.Publish(map[string]interface{}{
+		"type": "post", "link": "https://example.org/1", "text": "![image](" + image + ")",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(public.published) > 0 {
+		t.Errorf("the post was published publicly: %v", public.published)
+	}
+
+	// the group is created once
+	if _, err := postPublisher(pub, public, cfg, state); err != nil || state.Feed(cfg.Feed).Group != group {
+		t.Errorf("postPublisher() = %v, group %s, want the group %s", err, state.Feed(cfg.Feed).Group, group)
+	}
+
+	// the own posts to the group are known as published and keep their blobs
+	posts, err := MessagesFromLog(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, post := range posts {
+		found = found || post.Link == "https://example.org/1"
+	}
+	if !found {
+		t.Errorf("MessagesFromLog() = %v, want the post to the group", posts)
+	}
+
+	if _, err := CollectBlobs(pub, state, false); err != nil {
+		t.Fatal(err)
+	}
+	if !hasTestBlob(t, pub, image) {
+		t.Errorf("CollectBlobs() deleted %s of the post to the group", image)
+	}
+}
@@ -0,0 +1,115 @@
+package publish
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"decentral1se/rss-butt-plug/feed"
+)
+
+// matrixTimeout is how long the Matrix homeserver gets to respond.
+const matrixTimeout = 10 * time.Second
+
+// messageTitle returns the title of a published message: the title of a blog
+// or the heading of a post, falling back to its link.
+func messageTitle(message map[string]interface{}) string {
+	if title, ok := message["title"].(string); ok && title != "" {
+		return title
+	}
+
+	text, _ := message["text"].(string)
+	if heading, _, _ := strings.Cut(text, "\n"); strings.HasPrefix(heading, "# ") {
+		return strings.TrimPrefix(heading, "# ")
+	}
+
+	link, _ := message["link"].(string)
+	return link
+}
+
+// matrixRequest calls the client-server API of the homeserver, decoding the
+// JSON response into result (if not nil).
+func matrixRequest(cfg feed.MatrixConfig, method, path string, body, result interface{}) error {
+	var payload bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&payload).Encode(body); err != nil {
+			return fmt.Errorf("matrixRequest: unable to marshal request: %w", err)
+		}
+	}
+
+	req, err := http.NewRequest(method, strings.TrimSuffix(cfg.Homeserver, "/")+path, &payload)
+	if err != nil {
+		return fmt.Errorf("matrixRequest: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+cfg.AccessToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{Timeout: matrixTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("matrixRequest: unable to call homeserver: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("matrixRequest: homeserver responded with %s", resp.Status)
+	}
+
+	if result != nil {
+		if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+			return fmt.Errorf("matrixRequest: unable to decode response: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// matrixRoomID returns the ID of the configured room, resolving an alias.
+func matrixRoomID(cfg feed.MatrixConfig) (string, error) {
+	if strings.HasPrefix(cfg.Room, "!") {
+		return cfg.Room, nil
+	}
+
+	var resolved struct {
+		RoomID string `json:"room_id"`
+	}
+	if err := matrixRequest(cfg, http.MethodGet, "/_matrix/client/v3/directory/room/"+url.PathEscape(cfg.Room), nil, &resolved); err != nil {
+		return "", fmt.Errorf("matrixRoomID: unable to resolve %s: %w", cfg.Room, err)
+	}
+
+	return resolved.RoomID, nil
+}
+
+// notifyMatrix posts a notice with the title, message key and link of a newly
+// published item to the Matrix room. The transaction ID is derived from the
+// message key, so that retries don't post duplicates.
+func notifyMatrix(cfg feed.MatrixConfig, message map[string]interface{}, item PublishedItem) error {
+	room, err := matrixRoomID(cfg)
+	if err != nil {
+		return fmt.Errorf("notifyMatrix: %w", err)
+	}
+
+	lines := []string{messageTitle(message), item.Key}
+	if item.Link != "" && item.Link != lines[0] {
+		lines = append(lines, item.Link)
+	}
+
+	notice := map[string]string{
+		"msgtype": "m.notice",
+		"body":    strings.Join(lines, "\n"),
+	}
+
+	txn := sha256.Sum256([]byte(item.Key))
+	path := fmt.Sprintf("/_matrix/client/v3/rooms/%s/send/m.room.message/%s", url.PathEscape(room), hex.EncodeToString(txn[:]))
+	if err := matrixRequest(cfg, http.MethodPut, path, notice, nil); err != nil {
+		return fmt.Errorf("notifyMatrix: %w", err)
+	}
+
+	return nil
+}
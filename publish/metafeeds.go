@@ -0,0 +1,157 @@
+package publish
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/ssbc/go-luigi"
+	"github.com/ssbc/go-metafeed"
+	"github.com/ssbc/go-metafeed/metamngmt"
+	refs "github.com/ssbc/go-ssb-refs"
+	"github.com/ssbc/go-ssb/message"
+	"github.com/ssbc/go-ssb/sbot"
+)
+
+// publisher publishes messages as a single identity: the pub identity or one
+// of its subfeeds.
+type publisher interface {
+	Publish(content interface{}) (refs.Message, error)
+}
+
+// mainPurpose is the purpose of the subfeed which publishes the messages that
+// don't belong to a single RSS source, e.g. follows and notifications.
+const mainPurpose = "main"
+
+// MetafeedMode reports whether the pub identity is a metafeed, whose
+// subfeeds publish the posts.
+func MetafeedMode(pub *sbot.Sbot) bool {
+	return pub.KeyPair.ID().Algo() == refs.RefAlgoFeedBendyButt
+}
+
+// subfeeds returns the active subfeeds of the pub metafeed by purpose, as
+// announced in the metafeed/add/derived messages of the metafeed.
+func subfeeds(pub *sbot.Sbot) (map[string]refs.FeedRef, error) {
+	root := pub.KeyPair.ID()
+
+	listed, err := pub.MetaFeeds.ListSubFeeds(root)
+	if err != nil {
+		return nil, fmt.Errorf("subfeeds: unable to list subfeeds: %w", err)
+	}
+
+	active := make(map[string]bool)
+	for _, entry := range listed {
+		active[entry.Feed.String()] = true
+	}
+
+	src, err := pub.ReceiveLog.Query()
+	if err != nil {
+		return nil, fmt.Errorf("subfeeds: unable to query log: %w", err)
+	}
+
+	found := make(map[string]refs.FeedRef)
+	for {
+		v, err := src.Next(context.Background())
+		if luigi.IsEOS(err) {
+			break
+		}
+
+		msg, ok := v.(refs.Message)
+		if !ok || !msg.Author().Equal(root) {
+			continue
+		}
+
+		var added metamngmt.AddDerived
+		if err := metafeed.VerifySubSignedContent(msg.ContentBytes(), &added); err != nil {
+			continue
+		}
+
+		if active[added.SubFeed.String()] {
+			found[added.FeedPurpose] = added.SubFeed
+		}
+	}
+
+	return found, nil
+}
+
+// author returns the identity which publishes the messages of a purpose, the
+// feed URL of a RSS source or mainPurpose. That is the pub identity itself,
+// unless it's a metafeed. Then it's the subfeed of the purpose, which is
+// created when create is set.
+func author(pub *sbot.Sbot, purpose string, create bool) (refs.FeedRef, error) {
+	root := pub.KeyPair.ID()
+	if !MetafeedMode(pub) {
+		return root, nil
+	}
+
+	found, err := subfeeds(pub)
+	if err != nil {
+		return root, fmt.Errorf("author: %w", err)
+	}
+
+	if subfeed, ok := found[purpose]; ok {
+		return subfeed, nil
+	}
+
+	if !create {
+		return root, nil
+	}
+
+	subfeed, err := pub.MetaFeeds.CreateSubFeed(root, purpose, refs.RefAlgoFeedSSB1)
+	if err != nil {
+		return root, fmt.Errorf("author: unable to create subfeed for %s: %w", purpose, err)
+	}
+
+	log.Printf("author: created subfeed %s for %s", subfeed.String(), purpose)
+
+	return subfeed, nil
+}
+
+// ownFeeds returns the pub identity and its subfeeds.
+func ownFeeds(pub *sbot.Sbot) (map[string]bool, error) {
+	own := map[string]bool{pub.KeyPair.ID().String(): true}
+	if !MetafeedMode(pub) {
+		return own, nil
+	}
+
+	found, err := subfeeds(pub)
+	if err != nil {
+		return own, fmt.Errorf("ownFeeds: %w", err)
+	}
+
+	for _, subfeed := range found {
+		own[subfeed.String()] = true
+	}
+
+	return own, nil
+}
+
+// subfeedPublisher publishes on a subfeed of the pub metafeed.
+type subfeedPublisher struct {
+	pub *sbot.Sbot
+	as  refs.FeedRef
+}
+
+// Publish publishes content on the subfeed.
+func (s subfeedPublisher) Publish(content interface{}) (refs.Message, error) {
+	return s.pub.MetaFeeds.Publish(s.as, content)
+}
+
+// openPublisher opens a publisher for the pub identity or one of its
+// subfeeds. A metafeed only holds the announcements of its subfeeds.
+func openPublisher(pub *sbot.Sbot, as refs.FeedRef) (publisher, error) {
+	if !as.Equal(pub.KeyPair.ID()) {
+		return subfeedPublisher{pub: pub, as: as}, nil
+	}
+
+	if MetafeedMode(pub) {
+		return nil, fmt.Errorf("openPublisher: unable to publish on metafeed %s", as.String())
+	}
+
+	publish, err := message.OpenPublishLog(pub.ReceiveLog, pub.Users, pub.KeyPair)
+	if err != nil {
+		return nil, fmt.Errorf("openPublisher: failed to open publish log: %w", err)
+	}
+
+	return publish, nil
+}
@@ -0,0 +1,66 @@
+package publish
+
+import (
+	"testing"
+)
+
+func TestMetafeedAuthors(t *testing.T) {
+	pub := newTestPub(t, true)
+	if !MetafeedMode(pub) {
+		t.Fatal("MetafeedMode() = false for a metafeed")
+	}
+
+	const feedURL = "https://example.org/feed.xml"
+
+	root, err := author(pub, feedURL, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !root.Equal(pub.KeyPair.ID()) {
+		t.Errorf("author() = %s without a subfeed, want the metafeed", root.String())
+	}
+
+	subfeed, err := author(pub, feedURL, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subfeed.Equal(pub.KeyPair.ID()) {
+		t.Fatal("author() didn't create a subfeed")
+	}
+
+	again, err := author(pub, feedURL, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !again.Equal(subfeed) {
+		t.Errorf("author() = %s, want the existing subfeed %s", again.String(), subfeed.String())
+	}
+
+	if _, err := openPublisher(pub, pub.KeyPair.ID()); err == nil {
+		t.Error("openPublisher() accepted the metafeed, which only announces subfeeds")
+	}
+
+	publish, err := openPublisher(pub, subfeed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := publish.Publish(map[string]interface{}{"type": "post", "link": feedURL, "text": "# From a subfeed"}); err != nil {
+		t.Fatal(err)
+	}
+
+	own, err := ownFeeds(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !own[pub.KeyPair.ID().String()] || !own[subfeed.String()] {
+		t.Errorf("ownFeeds() = %v, want the metafeed and %s", own, subfeed.String())
+	}
+
+	posts := newOwnPosts(pub)
+	if err := posts.update(); err != nil {
+		t.Fatal(err)
+	}
+	if _, entries := posts.latest(0); len(entries) != 1 || entries[0].Title != "From a subfeed" {
+		t.Errorf("latest() = %v, want the post of the subfeed", entries)
+	}
+}
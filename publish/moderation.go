@@ -0,0 +1,347 @@
+package publish
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ssbc/go-luigi"
+	refs "github.com/ssbc/go-ssb-refs"
+	"github.com/ssbc/go-ssb/sbot"
+	"github.com/ssbc/margaret"
+
+	"decentral1se/rss-butt-plug/feed"
+)
+
+// moderationPreviewLength is the number of bytes of a held post which are
+// sent to the moderators, private messages are limited in size.
+const moderationPreviewLength = 3000
+
+// ModerationConfig configures the SSB identities which are sent the posts of
+// moderated feeds for approval. They approve or reject them by replying
+// "approve <id>" or "reject <id>" in a private message to the pub identity.
+type ModerationConfig struct {
+	Moderators []string `yaml:"moderators,omitempty"`
+}
+
+// Enabled reports whether any moderator is configured.
+func (cfg ModerationConfig) Enabled() bool {
+	return len(cfg.Moderators) > 0
+}
+
+// ValidateModeration checks the identities of the moderators.
+func ValidateModeration(cfg ModerationConfig) error {
+	for _, moderator := range cfg.Moderators {
+		if _, err := refs.ParseFeedRef(moderator); err != nil {
+			return fmt.Errorf("ValidateModeration: moderator %s is not a SSB identity: %w", moderator, err)
+		}
+	}
+
+	return nil
+}
+
+// PendingItem is a message of a moderated feed which waits for approval.
+// Decision is "approved" or "rejected" once it's decided on, the decision is
+// applied the next time the feed is published. Announced records that it was
+// sent to the moderators.
+type PendingItem struct {
+	ID        string                 `json:"id"`
+	Title     string                 `json:"title"`
+	Link      string                 `json:"link,omitempty"`
+	Added     time.Time              `json:"added"`
+	Decision  string                 `json:"decision,omitempty"`
+	Announced bool                   `json:"announced,omitempty"`
+	Message   map[string]interface{} `json:"message"`
+}
+
+// PendingEntry is a pending item of a feed, without its message.
+type PendingEntry struct {
+	Feed     string    `json:"feed"`
+	ID       string    `json:"id"`
+	Title    string    `json:"title"`
+	Link     string    `json:"link,omitempty"`
+	Added    time.Time `json:"added"`
+	Decision string    `json:"decision,omitempty"`
+}
+
+// pendingID is the short ID under which a message of a feed is approved. It's
+// derived from the content, so that the same message is only held once.
+func pendingID(feedURL string, message map[string]interface{}) string {
+	content, _ := json.Marshal(message)
+	sum := sha256.Sum256(append([]byte(feedURL+"\n"), content...))
+
+	return hex.EncodeToString(sum[:])[:10]
+}
+
+// holdForApproval applies the decisions on the pending items of a feed and,
+// if it's moderated, holds its new messages for approval. It returns the
+// messages to queue: the approved ones and those which aren't moderated, like
+// about messages.
+func holdForApproval(cfg feed.Config, state *State, messages []map[string]interface{}) []map[string]interface{} {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	feedState := state.Feed(cfg.Feed)
+
+	var queue []map[string]interface{}
+	var pending []PendingItem
+	held := make(map[string]bool)
+	for _, item := range feedState.Pending {
+		switch item.Decision {
+		case "approved":
+			log.Printf("holdForApproval: %s (%s) was approved", item.Link, item.ID)
+			queue = append(queue, item.Message)
+		case "rejected":
+			log.Printf("holdForApproval: %s (%s) was rejected", item.Link, item.ID)
+			// a rejected item isn't converted again, a rejected update is
+			// already recorded by its hash
+			if _, update := item.Message["root"]; !update && item.Link != "" {
+				state.Ignored[item.Link] = true
+			}
+		default:
+			pending = append(pending, item)
+			held[item.ID] = true
+		}
+	}
+
+	for _, message := range messages {
+		if !cfg.Moderate || message["type"] == "about" {
+			queue = append(queue, message)
+			continue
+		}
+
+		item := PendingItem{
+			ID:      pendingID(cfg.Feed, message),
+			Title:   messageTitle(message),
+			Added:   time.Now(),
+			Message: message,
+		}
+		item.Link, _ = message["link"].(string)
+
+		if held[item.ID] {
+			continue
+		}
+
+		log.Printf("holdForApproval: holding %s (%s) for approval", item.Link, item.ID)
+		pending = append(pending, item)
+		held[item.ID] = true
+	}
+
+	feedState.Pending = pending
+
+	return queue
+}
+
+// ListPending returns the items of all feeds which wait for approval (or
+// whose decision wasn't applied yet), oldest first.
+func ListPending(state *State) []PendingEntry {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	var entries []PendingEntry
+	for feedURL, feedState := range state.Feeds {
+		for _, item := range feedState.Pending {
+			entries = append(entries, PendingEntry{
+				Feed:     feedURL,
+				ID:       item.ID,
+				Title:    item.Title,
+				Link:     item.Link,
+				Added:    item.Added,
+				Decision: item.Decision,
+			})
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Added.Before(entries[j].Added)
+	})
+
+	return entries
+}
+
+// Decide approves or rejects the pending item id and returns it with the feed
+// it belongs to. It may be called while the feeds are published, the
+// decision is applied the next time the feed is published.
+func Decide(state *State, id string, approve bool) (string, PendingEntry, error) {
+	decision := "rejected"
+	if approve {
+		decision = "approved"
+	}
+
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	for feedURL, feedState := range state.Feeds {
+		for idx, item := range feedState.Pending {
+			if item.ID != id {
+				continue
+			}
+
+			feedState.Pending[idx].Decision = decision
+
+			entry := PendingEntry{Feed: feedURL, ID: item.ID, Title: item.Title, Link: item.Link, Added: item.Added, Decision: decision}
+			return feedURL, entry, nil
+		}
+	}
+
+	return "", PendingEntry{}, fmt.Errorf("Decide: no pending item %s", id)
+}
+
+// markAnnounced records that the moderators were sent the pending item id of
+// a feed.
+func markAnnounced(state *State, feedURL, id string) {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	feedState := state.Feed(feedURL)
+	for idx, item := range feedState.Pending {
+		if item.ID == id {
+			feedState.Pending[idx].Announced = true
+		}
+	}
+}
+
+// moderationPreview is the private message which asks the moderators to
+// approve a held item.
+func moderationPreview(feedURL string, item PendingItem) string {
+	text, _ := item.Message["text"].(string)
+	if text == "" {
+		text, _ = item.Message["summary"].(string)
+	}
+
+	if len(text) > moderationPreviewLength {
+		end := moderationPreviewLength
+		for end > 0 && !utf8.RuneStart(text[end]) {
+			end--
+		}
+		text = text[:end] + "…"
+	}
+
+	return fmt.Sprintf("A post of %s waits for approval, reply with `approve %s` or `reject %s`:\n\n%s",
+		feedURL, item.ID, item.ID, text)
+}
+
+// Moderate applies the decisions which the moderators sent as private
+// messages since the last call and sends them the items which were newly held
+// for approval. It returns the feeds with decided items, they should be
+// published right away.
+func Moderate(pub *sbot.Sbot, cfg ModerationConfig, state *State) ([]string, error) {
+	moderators := make(map[string]bool)
+	for _, moderator := range cfg.Moderators {
+		moderators[moderator] = true
+	}
+
+	src, err := pub.ReceiveLog.Query(margaret.SeqWrap(true), margaret.Gte(state.ModerationSeq))
+	if err != nil {
+		return nil, fmt.Errorf("Moderate: unable to query log: %w", err)
+	}
+
+	decided := make(map[string]bool)
+	nextSeq := state.ModerationSeq
+	for {
+		v, err := src.Next(context.Background())
+		if luigi.IsEOS(err) {
+			break
+		}
+
+		wrapped, ok := v.(margaret.SeqWrapper)
+		if !ok {
+			continue
+		}
+		nextSeq = wrapped.Seq() + 1
+
+		msg, ok := wrapped.Value().(refs.Message)
+		if !ok || !moderators[msg.Author().String()] {
+			continue
+		}
+
+		plaintext, err := pub.Groups.DecryptMessage(msg)
+		if err != nil {
+			continue
+		}
+
+		var content noteContent
+		if err := json.Unmarshal(plaintext, &content); err != nil || content.Type != "post" {
+			continue
+		}
+
+		for _, line := range strings.Split(content.Text, "\n") {
+			fields := strings.Fields(strings.Trim(line, "`"))
+			if len(fields) < 2 {
+				continue
+			}
+
+			command := strings.ToLower(fields[0])
+			if command != "approve" && command != "reject" {
+				continue
+			}
+
+			for _, id := range fields[1:] {
+				feedURL, entry, err := Decide(state, strings.Trim(id, "`"), command == "approve")
+				if err != nil {
+					log.Printf("Moderate: %s from %s: %s", command, msg.Author().String(), err)
+					continue
+				}
+
+				log.Printf("Moderate: %s %s %s (%s)", msg.Author().String(), entry.Decision, entry.Link, entry.ID)
+				decided[feedURL] = true
+
+				reply := fmt.Sprintf("%s %s: %s", entry.Decision, entry.ID, entry.Title)
+				if err := sendPrivate(pub, msg.Author().String(), reply); err != nil {
+					log.Printf("Moderate: %s", err)
+				}
+			}
+		}
+	}
+
+	type announcement struct {
+		feed string
+		item PendingItem
+	}
+
+	state.mu.Lock()
+	var announcements []announcement
+	for feedURL, feedState := range state.Feeds {
+		for _, item := range feedState.Pending {
+			if !item.Announced && item.Decision == "" {
+				announcements = append(announcements, announcement{feedURL, item})
+			}
+		}
+	}
+	state.mu.Unlock()
+
+	for _, announced := range announcements {
+		sent := true
+		for _, moderator := range cfg.Moderators {
+			if err := sendPrivate(pub, moderator, moderationPreview(announced.feed, announced.item)); err != nil {
+				log.Printf("Moderate: %s", err)
+				sent = false
+			}
+		}
+
+		// an item which not all moderators were told about is announced
+		// again the next time
+		if sent {
+			markAnnounced(state, announced.feed, announced.item.ID)
+		}
+	}
+
+	state.ModerationSeq = nextSeq
+	if err := state.Save(); err != nil {
+		return nil, fmt.Errorf("Moderate: %w", err)
+	}
+
+	var feeds []string
+	for feedURL := range decided {
+		feeds = append(feeds, feedURL)
+	}
+
+	return feeds, nil
+}
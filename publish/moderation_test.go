@@ -0,0 +1,73 @@
+package publish
+
+import (
+	"testing"
+
+	"decentral1se/rss-butt-plug/feed"
+)
+
+func TestHoldForApproval(t *testing.T) {
+	state, err := LoadState(t.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := feed.Config{Feed: "https://example.org/feed.xml", Moderate: true}
+	post := map[string]interface{}{"type": "post", "link": "https://example.org/1", "text": "# One"}
+	about := map[string]interface{}{"type": "about", "name": "example"}
+
+	queue := holdForApproval(cfg, state, []map[string]interface{}{about, post})
+	if len(queue) != 1 || queue[0]["type"] != "about" {
+		t.Fatalf("holdForApproval queued %v, want only the about message", queue)
+	}
+
+	// the same post is only held once
+	holdForApproval(cfg, state, []map[string]interface{}{post})
+
+	pending := ListPending(state)
+	if len(pending) != 1 || pending[0].Title != "One" {
+		t.Fatalf("ListPending() = %v, want the post", pending)
+	}
+
+	if _, _, err := Decide(state, "unknown", true); err == nil {
+		t.Error("Decide accepted an unknown item")
+	}
+
+	feedURL, _, err := Decide(state, pending[0].ID, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if feedURL != cfg.Feed {
+		t.Errorf("Decide returned feed %s, want %s", feedURL, cfg.Feed)
+	}
+
+	queue = holdForApproval(cfg, state, nil)
+	if len(queue) != 1 || queue[0]["link"] != "https://example.org/1" {
+		t.Errorf("holdForApproval queued %v, want the approved post", queue)
+	}
+	if pending := ListPending(state); len(pending) != 0 {
+		t.Errorf("ListPending() = %v after the decision was applied, want none", pending)
+	}
+}
+
+func TestRejectIgnoresItem(t *testing.T) {
+	state, err := LoadState(t.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := feed.Config{Feed: "https://example.org/feed.xml", Moderate: true}
+	post := map[string]interface{}{"type": "post", "link": "https://example.org/2", "text": "two"}
+
+	holdForApproval(cfg, state, []map[string]interface{}{post})
+	if _, _, err := Decide(state, pendingID(cfg.Feed, post), false); err != nil {
+		t.Fatal(err)
+	}
+
+	if queue := holdForApproval(cfg, state, nil); len(queue) != 0 {
+		t.Errorf("holdForApproval queued %v, want nothing", queue)
+	}
+	if !state.Ignored["https://example.org/2"] {
+		t.Error("a rejected item isn't ignored")
+	}
+}
@@ -0,0 +1,192 @@
+package publish
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/gorilla/websocket"
+
+	"decentral1se/rss-butt-plug/feed"
+)
+
+// nostrRelayTimeout is how long a Nostr relay gets to accept an event.
+const nostrRelayTimeout = 10 * time.Second
+
+// nostrEvent is a signed Nostr event (NIP-01).
+type nostrEvent struct {
+	ID        string     `json:"id"`
+	PubKey    string     `json:"pubkey"`
+	CreatedAt int64      `json:"created_at"`
+	Kind      int        `json:"kind"`
+	Tags      [][]string `json:"tags"`
+	Content   string     `json:"content"`
+	Sig       string     `json:"sig"`
+}
+
+// signNostrEvent computes the id of an event and signs it with a hex encoded
+// secp256k1 private key.
+func signNostrEvent(event *nostrEvent, privateKey string) error {
+	keyBytes, err := hex.DecodeString(privateKey)
+	if err != nil || len(keyBytes) != 32 {
+		return fmt.Errorf("signNostrEvent: private key must be 32 hex encoded bytes")
+	}
+
+	privKey, pubKey := btcec.PrivKeyFromBytes(keyBytes)
+	event.PubKey = hex.EncodeToString(schnorr.SerializePubKey(pubKey))
+
+	var serialized bytes.Buffer
+	encoder := json.NewEncoder(&serialized)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode([]interface{}{0, event.PubKey, event.CreatedAt, event.Kind, event.Tags, event.Content}); err != nil {
+		return fmt.Errorf("signNostrEvent: unable to serialize event: %w", err)
+	}
+
+	id := sha256.Sum256(bytes.TrimSuffix(serialized.Bytes(), []byte("\n")))
+	event.ID = hex.EncodeToString(id[:])
+
+	sig, err := schnorr.Sign(privKey, id[:])
+	if err != nil {
+		return fmt.Errorf("signNostrEvent: unable to sign event: %w", err)
+	}
+	event.Sig = hex.EncodeToString(sig.Serialize())
+
+	return nil
+}
+
+// ValidateNostr checks the private key of the Nostr output, if enabled.
+func ValidateNostr(cfg feed.NostrConfig) error {
+	if len(cfg.Relays) == 0 {
+		return nil
+	}
+
+	if err := signNostrEvent(&nostrEvent{}, cfg.PrivateKey); err != nil {
+		return fmt.Errorf("ValidateNostr: %w", err)
+	}
+
+	return nil
+}
+
+// nostrMessageEvent turns a published post or blog message into a Nostr
+// text note. Blob images can't be resolved outside of SSB so they are left
+// out. Other messages are not mirrored.
+func nostrMessageEvent(message map[string]interface{}) (*nostrEvent, bool) {
+	event := &nostrEvent{CreatedAt: time.Now().Unix(), Kind: 1, Tags: [][]string{}}
+
+	link, _ := message["link"].(string)
+
+	switch message["type"] {
+	case "post":
+		text, _ := message["text"].(string)
+		event.Content = strings.TrimSpace(feed.MarkdownImageRegex.ReplaceAllString(text, ""))
+	case "blog":
+		title, _ := message["title"].(string)
+		summary, _ := message["summary"].(string)
+		event.Content = title + "\n\n" + summary + "\n\n" + link
+	default:
+		return nil, false
+	}
+
+	if link != "" {
+		event.Tags = append(event.Tags, []string{"r", link})
+	}
+
+	// mentions are only typed before the message went through the queue
+	var mentions []map[string]string
+	if encoded, err := json.Marshal(message["mentions"]); err == nil {
+		json.Unmarshal(encoded, &mentions)
+	}
+
+	for _, mention := range mentions {
+		if tag := strings.TrimPrefix(mention["link"], "#"); tag != mention["link"] {
+			event.Tags = append(event.Tags, []string{"t", strings.ToLower(tag)})
+		}
+	}
+
+	return event, true
+}
+
+// publishNostr signs a published message as a Nostr event and broadcasts it
+// to the configured relays in the background, so that slow relays don't hold
+// up publishing. Failing relays are recorded in the state of the feed.
+func publishNostr(cfg feed.NostrConfig, message map[string]interface{}, state *State, feedState *FeedState) {
+	if len(cfg.Relays) == 0 {
+		return
+	}
+
+	event, ok := nostrMessageEvent(message)
+	if !ok {
+		return
+	}
+
+	if err := signNostrEvent(event, cfg.PrivateKey); err != nil {
+		log.Printf("publishNostr: %s", err)
+		return
+	}
+
+	for _, relay := range cfg.Relays {
+		go func(relay string) {
+			err := sendNostrEvent(relay, event)
+
+			state.mu.Lock()
+			defer state.mu.Unlock()
+
+			if err != nil {
+				log.Printf("publishNostr: %s", err)
+				if feedState.NostrErrors == nil {
+					feedState.NostrErrors = make(map[string]string)
+				}
+				feedState.NostrErrors[relay] = err.Error()
+				return
+			}
+
+			delete(feedState.NostrErrors, relay)
+			log.Printf("publishNostr: broadcast %s to %s", message["link"], relay)
+		}(relay)
+	}
+}
+
+// sendNostrEvent sends an event to a relay and waits for it to be accepted.
+func sendNostrEvent(relay string, event *nostrEvent) error {
+	conn, _, err := websocket.DefaultDialer.Dial(relay, nil)
+	if err != nil {
+		return fmt.Errorf("sendNostrEvent: unable to connect to %s: %w", relay, err)
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(nostrRelayTimeout)
+	if err := conn.SetWriteDeadline(deadline); err != nil {
+		return fmt.Errorf("sendNostrEvent: %w", err)
+	}
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		return fmt.Errorf("sendNostrEvent: %w", err)
+	}
+
+	if err := conn.WriteJSON([]interface{}{"EVENT", event}); err != nil {
+		return fmt.Errorf("sendNostrEvent: unable to send event to %s: %w", relay, err)
+	}
+
+	for {
+		var response []interface{}
+		if err := conn.ReadJSON(&response); err != nil {
+			return fmt.Errorf("sendNostrEvent: no answer from %s: %w", relay, err)
+		}
+
+		if len(response) < 3 || response[0] != "OK" || response[1] != event.ID {
+			continue
+		}
+
+		if accepted, _ := response[2].(bool); !accepted {
+			return fmt.Errorf("sendNostrEvent: %s rejected the event: %v", relay, response[3:])
+		}
+
+		return nil
+	}
+}
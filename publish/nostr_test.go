@@ -0,0 +1,32 @@
+package publish
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"decentral1se/rss-butt-plug/feed"
+)
+
+func TestPublishNostrRecordsFailures(t *testing.T) {
+	cfg := feed.NostrConfig{
+		PrivateKey: strings.Repeat("01", 32),
+		Relays:     []string{"ws://127.0.0.1:1"},
+	}
+	state := &State{Feeds: make(map[string]*FeedState)}
+	message := map[string]interface{}{"type": "post", "text": "hello", "link": "https://example.org/hello"}
+
+	publishNostr(cfg, message, state, state.Feed("feed"))
+
+	deadline := time.Now().Add(nostrRelayTimeout)
+	for len(state.NostrErrors("feed")) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the failed broadcast wasn't recorded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if errors := state.NostrErrors("feed"); !strings.Contains(errors[0], "ws://127.0.0.1:1") {
+		t.Errorf("NostrErrors() = %v, want an error naming the relay", errors)
+	}
+}
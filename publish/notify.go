@@ -0,0 +1,403 @@
+package publish
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ssbc/go-luigi"
+	refs "github.com/ssbc/go-ssb-refs"
+	"github.com/ssbc/go-ssb/sbot"
+	"github.com/ssbc/margaret"
+
+	"decentral1se/rss-butt-plug/feed"
+)
+
+// notifyWebhookTimeout is how long a notification or alert webhook gets to
+// respond.
+const notifyWebhookTimeout = 10 * time.Second
+
+// NotifyConfig configures where replies to the published posts and mentions
+// of the pub identity are forwarded to: a private message to the Admin
+// identity, a JSON POST to Webhook and / or the log.
+type NotifyConfig struct {
+	Admin   string `yaml:"admin,omitempty"`
+	Webhook string `yaml:"webhook,omitempty"`
+	Log     bool   `yaml:"log,omitempty"`
+}
+
+// Enabled reports whether any notification output is configured.
+func (cfg NotifyConfig) Enabled() bool {
+	return cfg.Admin != "" || cfg.Webhook != "" || cfg.Log
+}
+
+// Notification is a reply to a published post or a mention of the pub
+// identity.
+type Notification struct {
+	Kind   string `json:"kind"`
+	Key    string `json:"key"`
+	Author string `json:"author"`
+	Root   string `json:"root,omitempty"`
+	Text   string `json:"text"`
+}
+
+// ValidateNotify checks the admin identity of the notifications, if enabled.
+func ValidateNotify(cfg NotifyConfig) error {
+	if cfg.Admin == "" {
+		return nil
+	}
+
+	if _, err := refs.ParseFeedRef(cfg.Admin); err != nil {
+		return fmt.Errorf("ValidateNotify: admin %s is not a SSB identity: %w", cfg.Admin, err)
+	}
+
+	return nil
+}
+
+// noteContent is the part of the content of a replicated message which is
+// inspected for replies and mentions.
+type noteContent struct {
+	Type     string          `json:"type"`
+	Text     string          `json:"text"`
+	Root     string          `json:"root"`
+	Branch   json.RawMessage `json:"branch"`
+	Mentions json.RawMessage `json:"mentions"`
+}
+
+// refList returns the references in raw, which is a single message or feed
+// reference or a list of references or mentions.
+func refList(raw json.RawMessage) []string {
+	if len(raw) == 0 {
+		return nil
+	}
+
+	var single string
+	if err := json.Unmarshal(raw, &single); err == nil {
+		return []string{single}
+	}
+
+	var list []json.RawMessage
+	if err := json.Unmarshal(raw, &list); err != nil {
+		return nil
+	}
+
+	var found []string
+	for _, entry := range list {
+		var mention struct {
+			Link string `json:"link"`
+		}
+		if err := json.Unmarshal(entry, &mention); err == nil && mention.Link != "" {
+			found = append(found, mention.Link)
+		} else if err := json.Unmarshal(entry, &single); err == nil {
+			found = append(found, single)
+		}
+	}
+
+	return found
+}
+
+// notifyFeeds returns the pub identity and its active subfeeds, without
+// scanning the log for their announcements.
+func notifyFeeds(pub *sbot.Sbot) (map[string]bool, error) {
+	self := map[string]bool{pub.KeyPair.ID().String(): true}
+	if !MetafeedMode(pub) {
+		return self, nil
+	}
+
+	listed, err := pub.MetaFeeds.ListSubFeeds(pub.KeyPair.ID())
+	if err != nil {
+		return self, fmt.Errorf("notifyFeeds: unable to list subfeeds: %w", err)
+	}
+
+	for _, entry := range listed {
+		self[entry.Feed.String()] = true
+	}
+
+	return self, nil
+}
+
+// startNotify collects the keys of the messages which the pub published so
+// far, the first time notifications are enabled. Notifications start at the
+// current end of the log, earlier replies and mentions aren't forwarded.
+func startNotify(pub *sbot.Sbot, self map[string]bool, state *State) error {
+	src, err := pub.ReceiveLog.Query(margaret.SeqWrap(true))
+	if err != nil {
+		return fmt.Errorf("startNotify: unable to query log: %w", err)
+	}
+
+	own := make(map[string]bool)
+	lastSeq := int64(-1)
+	for {
+		v, err := src.Next(context.Background())
+		if luigi.IsEOS(err) {
+			break
+		}
+
+		wrapped, ok := v.(margaret.SeqWrapper)
+		if !ok {
+			continue
+		}
+		lastSeq = wrapped.Seq()
+
+		if msg, ok := wrapped.Value().(refs.Message); ok && self[msg.Author().String()] {
+			own[msg.Key().String()] = true
+		}
+	}
+
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	// a state from before own messages were kept picks up where it stopped
+	if state.NotifySeq == 0 {
+		state.NotifySeq = lastSeq
+	}
+	state.OwnMessages = own
+
+	return nil
+}
+
+// Notify forwards replies to the published posts and mentions of the pub
+// identity (or its subfeeds) which were replicated since the last call. The
+// position in the receive log and the keys of the own messages are kept in
+// the state store.
+func Notify(pub *sbot.Sbot, cfg NotifyConfig, state *State) error {
+	self, err := notifyFeeds(pub)
+	if err != nil {
+		return fmt.Errorf("Notify: %w", err)
+	}
+
+	if state.OwnMessages == nil {
+		if err := startNotify(pub, self, state); err != nil {
+			return fmt.Errorf("Notify: %w", err)
+		}
+	}
+
+	src, err := pub.ReceiveLog.Query(margaret.SeqWrap(true), margaret.Gt(state.NotifySeq))
+	if err != nil {
+		return fmt.Errorf("Notify: unable to query log: %w", err)
+	}
+
+	var notifications []Notification
+	lastSeq := state.NotifySeq
+	for {
+		v, err := src.Next(context.Background())
+		if luigi.IsEOS(err) {
+			break
+		}
+
+		wrapped, ok := v.(margaret.SeqWrapper)
+		if !ok {
+			continue
+		}
+		lastSeq = wrapped.Seq()
+
+		msg, ok := wrapped.Value().(refs.Message)
+		if !ok {
+			continue
+		}
+
+		if self[msg.Author().String()] {
+			state.mu.Lock()
+			state.OwnMessages[msg.Key().String()] = true
+			state.mu.Unlock()
+			continue
+		}
+
+		var content noteContent
+		if err := json.Unmarshal(msg.ContentBytes(), &content); err != nil || content.Type != "post" {
+			continue
+		}
+
+		notification := Notification{
+			Key:    msg.Key().String(),
+			Author: msg.Author().String(),
+			Root:   content.Root,
+			Text:   content.Text,
+		}
+
+		for _, branch := range append(refList(content.Branch), content.Root) {
+			if state.OwnMessages[branch] {
+				notification.Kind = "reply"
+			}
+		}
+
+		for _, mention := range refList(content.Mentions) {
+			if self[mention] && notification.Kind == "" {
+				notification.Kind = "mention"
+			}
+		}
+
+		for feed := range self {
+			if notification.Kind == "" && strings.Contains(content.Text, feed) {
+				notification.Kind = "mention"
+			}
+		}
+
+		if notification.Kind == "" {
+			continue
+		}
+
+		notifications = append(notifications, notification)
+	}
+
+	for _, notification := range notifications {
+		if err := notify(pub, cfg, notification); err != nil {
+			log.Printf("Notify: %s", err)
+		}
+	}
+
+	state.mu.Lock()
+	state.NotifySeq = lastSeq
+	state.mu.Unlock()
+
+	if err := state.Save(); err != nil {
+		return fmt.Errorf("Notify: %w", err)
+	}
+
+	return nil
+}
+
+// notify forwards a single notification to the configured outputs.
+func notify(pub *sbot.Sbot, cfg NotifyConfig, notification Notification) error {
+	if cfg.Log {
+		log.Printf("notify: %s from %s (%s): %s", notification.Kind, notification.Author,
+			notification.Key, notification.Text)
+	}
+
+	if cfg.Admin != "" {
+		text := fmt.Sprintf("New %s from [%s](%s) on [this message](%s):\n\n> %s",
+			notification.Kind, notification.Author, notification.Author, notification.Key,
+			strings.ReplaceAll(notification.Text, "\n", "\n> "))
+
+		if err := sendPrivate(pub, cfg.Admin, text, notification.Author); err != nil {
+			return fmt.Errorf("notify: %w", err)
+		}
+	}
+
+	if cfg.Webhook != "" {
+		if err := sendWebhook(cfg.Webhook, notification); err != nil {
+			return fmt.Errorf("notify: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// sendWebhook posts payload as JSON to a webhook.
+func sendWebhook(webhook string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("sendWebhook: unable to marshal payload: %w", err)
+	}
+
+	client := &http.Client{Timeout: notifyWebhookTimeout}
+	resp, err := client.Post(webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("sendWebhook: unable to call webhook: %w", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("sendWebhook: webhook responded with %s", resp.Status)
+	}
+
+	return nil
+}
+
+// publishedEvent is the payload of the publish-webhook.
+type publishedEvent struct {
+	Kind string `json:"kind"`
+	Feed string `json:"feed"`
+	PublishedItem
+}
+
+// notifyPublished sends a published item of a feed to its publish-webhook,
+// Matrix room and XMPP contact or room, if configured. Updates of items are
+// only sent to the webhook.
+// Failing to send it is only logged, the item is published.
+func notifyPublished(cfg feed.Config, message map[string]interface{}, item PublishedItem) {
+	if cfg.PublishWebhook != "" {
+		event := publishedEvent{Kind: "published", Feed: cfg.Feed, PublishedItem: item}
+		if err := sendWebhook(cfg.PublishWebhook, event); err != nil {
+			log.Printf("notifyPublished: %s: %s", cfg.Feed, err)
+		}
+	}
+
+	if cfg.Matrix.Room != "" && item.Type != "update" {
+		if err := notifyMatrix(cfg.Matrix, message, item); err != nil {
+			log.Printf("notifyPublished: %s: %s", cfg.Feed, err)
+		}
+	}
+
+	if cfg.XMPP.Enabled() && item.Type != "update" {
+		notice := messageTitle(message)
+		if item.Link != "" && item.Link != notice {
+			notice += " " + item.Link
+		}
+		notice += " (" + item.Key + ")"
+
+		// a XMPP notice takes a login, it's sent in the background so that
+		// an unreachable server doesn't hold up publishing
+		go func() {
+			if err := sendXMPP(cfg.XMPP, notice); err != nil {
+				log.Printf("notifyPublished: %s: %s", cfg.Feed, err)
+			}
+		}()
+	}
+}
+
+// sendPrivate publishes text as a private message to the recipient, which
+// also mentions the given identities. A metafeed sends it from its main
+// subfeed.
+func sendPrivate(pub *sbot.Sbot, recipient, text string, mentions ...string) error {
+	recipientRef, err := refs.ParseFeedRef(recipient)
+	if err != nil {
+		return fmt.Errorf("sendPrivate: %w", err)
+	}
+
+	self, err := author(pub, mainPurpose, true)
+	if err != nil {
+		return fmt.Errorf("sendPrivate: %w", err)
+	}
+
+	content := map[string]interface{}{
+		"type":  "post",
+		"text":  text,
+		"recps": []string{recipient, self.String()},
+	}
+
+	if len(mentions) > 0 {
+		var links []map[string]string
+		for _, mention := range mentions {
+			links = append(links, map[string]string{"link": mention})
+		}
+		content["mentions"] = links
+	}
+
+	plaintext, err := json.Marshal(content)
+	if err != nil {
+		return fmt.Errorf("sendPrivate: unable to marshal message: %w", err)
+	}
+
+	ciphertext, err := pub.Groups.EncryptBox1(plaintext, recipientRef, self)
+	if err != nil {
+		return fmt.Errorf("sendPrivate: %w", err)
+	}
+
+	publish, err := openPublisher(pub, self)
+	if err != nil {
+		return fmt.Errorf("sendPrivate: %w", err)
+	}
+
+	if _, err := publish.Publish(base64.StdEncoding.EncodeToString(ciphertext) + ".box"); err != nil {
+		return fmt.Errorf("sendPrivate: failed to publish: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,1109 @@
+// Package publish publishes converted feed items to the log of a go-sbot,
+// keeping track of what was published in a state store, and to Nostr relays
+// and reverse RSS/Atom feeds.
+package publish
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mmcdole/gofeed"
+	refs "github.com/ssbc/go-ssb-refs"
+	"github.com/ssbc/go-ssb/sbot"
+
+	"decentral1se/rss-butt-plug/feed"
+)
+
+// itemUpdate returns a reply to the root post of an already published item
+// when its content changed since it was published, or nil if it didn't. Items
+// published before publish-updates was enabled only get their hash recorded.
+func itemUpdate(ctx context.Context, item *gofeed.Item, feedLink, link, root string, blobs feed.BlobPutter, cfg feed.Config, state *State) (map[string]interface{}, error) {
+	hash, err := feed.ItemHash(ctx, item, feedLink, blobs, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("itemUpdate: %w", err)
+	}
+
+	previous, known := state.swapHash(link, hash)
+	if !known || previous == hash {
+		return nil, nil
+	}
+
+	log.Printf("itemUpdate: %s was updated, publishing a correction", link)
+
+	message, err := feed.ItemMessage(ctx, item, feedLink, link, blobs, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("itemUpdate: %w", err)
+	}
+
+	text, ok := message["text"].(string)
+	if !ok {
+		text = fmt.Sprintf("%s", message["summary"])
+	}
+
+	readMore := "\n\n[Read more](" + link + ")"
+	text = "**Updated:** this post was edited at the source.\n\n" + text
+	if limit := feed.PostLimit(cfg); len(text) > limit {
+		text = feed.Summarise(text, feed.Config{SummaryCharacters: limit - len(readMore)}) + readMore
+	}
+
+	update := map[string]interface{}{
+		"type":   "post",
+		"link":   link,
+		"root":   root,
+		"branch": root,
+		"text":   text,
+	}
+
+	if warning, ok := message["contentWarning"]; ok {
+		update["contentWarning"] = warning
+	}
+
+	return update, nil
+}
+
+// getNewRSSPosts gathers new posts from a RSS feed.
+func getNewRSSPosts(ctx context.Context, parsed gofeed.Feed, posts []Post, blobs feed.BlobPutter, cfg feed.Config, state *State) ([]map[string]interface{}, error) {
+	var messages []map[string]interface{}
+
+	state.mu.Lock()
+	resetSeq := state.Feed(cfg.Feed).ResetSeq
+	state.mu.Unlock()
+
+	postedLinks := make(map[string]bool)
+	rootKeys := make(map[string]string)
+	for _, post := range posts {
+		if post.Seq < resetSeq {
+			continue
+		}
+
+		link := feed.StripTrackingParams(post.Link, cfg.TrackingParams)
+		postedLinks[link] = true
+		if post.Root == "" && (post.Type == "post" || post.Type == "blog") {
+			// the latest root, a republished item replaces its earlier post
+			rootKeys[link] = post.Key
+		}
+	}
+
+	state.mu.Lock()
+	feedState := state.Feed(cfg.Feed)
+	for _, queued := range append(feedState.Queue, feedState.Digest...) {
+		if link, ok := queued["link"].(string); ok {
+			postedLinks[link] = true
+		}
+	}
+	for _, pending := range feedState.Pending {
+		if pending.Link != "" {
+			postedLinks[pending.Link] = true
+		}
+	}
+
+	firstRun := feedState.LastPoll.IsZero()
+	for _, item := range parsed.Items {
+		link := feed.StripTrackingParams(item.Link, cfg.TrackingParams)
+		if postedLinks[link] || state.Ignored[link] {
+			firstRun = false
+			break
+		}
+	}
+
+	items := feed.ChronologicalItems(parsed.Items)
+
+	ignored := make(map[string]bool)
+	for idx, item := range items {
+		link := feed.StripTrackingParams(item.Link, cfg.TrackingParams)
+		if state.Ignored[link] {
+			ignored[link] = true
+		} else if firstRun && cfg.InitialBackfillLimit > 0 && idx < len(items)-cfg.InitialBackfillLimit && !postedLinks[link] {
+			log.Printf("getNewRSSPosts: ignoring %s, over the initial backfill limit", link)
+			state.Ignored[link] = true
+			ignored[link] = true
+		}
+	}
+	state.mu.Unlock()
+
+	for _, item := range items {
+		link := feed.StripTrackingParams(item.Link, cfg.TrackingParams)
+		if postedLinks[link] {
+			if root := rootKeys[link]; cfg.PublishUpdates && root != "" {
+				update, err := itemUpdate(ctx, item, parsed.Link, link, root, blobs, cfg, state)
+				if err != nil {
+					return messages, fmt.Errorf("getNewRSSPosts: %w", err)
+				}
+				if update != nil {
+					messages = append(messages, update)
+				}
+				continue
+			}
+
+			log.Printf("getNewRSSPosts: skipping %s, already posted", link)
+			continue
+		}
+
+		if ignored[link] {
+			continue
+		}
+
+		if published := feed.ItemPublished(item); cfg.MaxItemAge > 0 && published != nil && time.Since(*published) > cfg.MaxItemAge {
+			log.Printf("getNewRSSPosts: skipping %s, older than %s", link, cfg.MaxItemAge)
+			continue
+		}
+
+		if feed.PublishedBefore(item, cfg) {
+			log.Printf("getNewRSSPosts: skipping %s, published before %s", link, cfg.Since)
+			continue
+		}
+
+		if publish, reason := feed.FilterItem(item, cfg.Filters); !publish {
+			log.Printf("getNewRSSPosts: skipping %s, %s", link, reason)
+			continue
+		}
+
+		if publish, reason := feed.FilterLanguage(item, cfg.Language); !publish {
+			log.Printf("getNewRSSPosts: skipping %s, %s", link, reason)
+			continue
+		}
+
+		message, err := feed.ItemMessage(ctx, item, parsed.Link, link, blobs, cfg)
+		if err != nil {
+			return messages, fmt.Errorf("getNewRSSPosts: %w", err)
+		}
+
+		if cfg.PublishUpdates {
+			hash, err := feed.ItemHash(ctx, item, parsed.Link, blobs, cfg)
+			if err != nil {
+				return messages, fmt.Errorf("getNewRSSPosts: %w", err)
+			}
+			state.swapHash(link, hash)
+		}
+
+		messages = append(messages, message)
+	}
+
+	if err := state.Save(); err != nil {
+		return messages, fmt.Errorf("getNewRSSPosts: %w", err)
+	}
+
+	return messages, nil
+}
+
+// CreateAboutMessage publishes an about message with accompanying avatar, if available in config).
+// An existing about message is only superseded when force is set. For a metafeed, the about message
+// is about the subfeed of the feed, which is only created when state isn't read-only.
+func CreateAboutMessage(ctx context.Context, pub *sbot.Sbot, blobs feed.BlobPutter, posts []Post, parsed gofeed.Feed, avatar string, cfg feed.Config, force bool, state *State) (map[string]interface{}, bool, error) {
+	self, err := author(pub, cfg.Feed, !state.ReadOnly)
+	if err != nil {
+		return nil, false, fmt.Errorf("CreateAboutMessage: %w", err)
+	}
+
+	for _, post := range posts {
+		if post.Type == "about" && post.Author == self.String() && !force {
+			log.Printf("CreateAboutMessage: skipping about message post, already done")
+			return nil, false, nil
+		}
+	}
+
+	message := map[string]interface{}{
+		"type":        "about",
+		"about":       self,
+		"name":        parsed.Title,
+		"description": aboutDescription(parsed, cfg),
+	}
+
+	if avatar != "" {
+		srcReader, err := feed.GetImage(ctx, avatar, cfg)
+		if err != nil {
+			return nil, false, fmt.Errorf("CreateAboutMessage: %w", err)
+		}
+
+		ref, err := feed.PutBlob(ctx, blobs, srcReader, cfg)
+		if err != nil {
+			return nil, false, fmt.Errorf("CreateAboutMessage: %w", err)
+		}
+
+		message["image"] = ref.String()
+	} else if ref, ok := fallbackAvatar(ctx, blobs, parsed, cfg); ok {
+		message["image"] = ref
+	}
+
+	log.Printf("CreateAboutMessage: creating about message post")
+
+	return message, true, nil
+}
+
+// aboutDescription returns the profile description: the configured
+// description followed by a blurb which explains that the account is an
+// automated mirror and how to reach the operator.
+func aboutDescription(parsed gofeed.Feed, cfg feed.Config) string {
+	var description strings.Builder
+	if cfg.Description != "" {
+		description.WriteString(strings.TrimSpace(cfg.Description) + "\n\n")
+	}
+
+	source := cfg.Feed
+	if parsed.Link != "" {
+		source = parsed.Link
+	}
+
+	fmt.Fprintf(&description, "This is an automated account which mirrors %s into the Scuttleverse with [rss-butt-plug](https://git.coopcloud.tech/decentral1se/rss-butt-plug).", source)
+
+	if cfg.Operator != "" {
+		fmt.Fprintf(&description, " It is run by %s, get in touch there.", operatorLink(cfg.Operator))
+	}
+
+	return description.String()
+}
+
+// operatorLink returns the operator contact as markdown, linking SSB
+// identities.
+func operatorLink(operator string) string {
+	if _, err := refs.ParseFeedRef(operator); err == nil {
+		return "[" + operator + "](" + operator + ")"
+	}
+
+	return operator
+}
+
+// IntroductionMessage returns the introduction post which explains the mirror
+// to new followers, when introduction is enabled and it wasn't published yet.
+// It is recorded as published in the state store.
+func IntroductionMessage(parsed gofeed.Feed, cfg feed.Config, state *State) (map[string]interface{}, bool, error) {
+	if !cfg.Introduction || state.Introduced {
+		return nil, false, nil
+	}
+
+	data := feed.IntroData{
+		Title:       parsed.Title,
+		Description: strings.Join(strings.Fields(parsed.Description), " "),
+		Link:        parsed.Link,
+		Feed:        cfg.Feed,
+		Cadence:     feed.Cadence(cfg),
+	}
+
+	if data.Link == "" {
+		data.Link = cfg.Feed
+	}
+
+	if cfg.Operator != "" {
+		data.Operator = operatorLink(cfg.Operator)
+	}
+
+	text, err := feed.RenderIntroduction(cfg, data)
+	if err != nil {
+		return nil, false, fmt.Errorf("IntroductionMessage: %w", err)
+	}
+
+	state.Introduced = true
+
+	log.Printf("IntroductionMessage: creating introduction post")
+
+	return map[string]interface{}{
+		"type": "post",
+		"text": text,
+	}, true, nil
+}
+
+// fallbackAvatar stores the image of the feed, or else the icon of its
+// website, as the avatar when none is configured. It reports whether one was
+// found.
+func fallbackAvatar(ctx context.Context, blobs feed.BlobPutter, parsed gofeed.Feed, cfg feed.Config) (string, bool) {
+	var candidates []string
+	if parsed.Image != nil && parsed.Image.URL != "" {
+		if imageURL, err := url.Parse(parsed.Image.URL); err == nil {
+			if siteURL, err := url.Parse(parsed.Link); err == nil {
+				imageURL = siteURL.ResolveReference(imageURL)
+			}
+			candidates = append(candidates, imageURL.String())
+		}
+	}
+
+	if parsed.Link != "" {
+		icons, err := feed.SiteIcons(ctx, parsed.Link, cfg)
+		if err != nil {
+			log.Printf("fallbackAvatar: %s", err)
+		}
+		candidates = append(candidates, icons...)
+	}
+
+	for _, candidate := range candidates {
+		srcReader, err := feed.GetImage(ctx, candidate, cfg)
+		if err != nil {
+			log.Printf("fallbackAvatar: %s", err)
+			continue
+		}
+
+		ref, err := feed.PutBlob(ctx, blobs, srcReader, cfg)
+		if err != nil {
+			log.Printf("fallbackAvatar: %s", err)
+			continue
+		}
+
+		log.Printf("fallbackAvatar: using %s as avatar", candidate)
+
+		return ref.String(), true
+	}
+
+	return "", false
+}
+
+// IsFence reports whether a markdown line opens or closes a fenced code block.
+func IsFence(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	return strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~")
+}
+
+// markdownBlocks splits markdown into blocks which should be kept together
+// when chunking: paragraphs, lists, blob image lines and entire fenced code
+// blocks (including the blank lines inside them).
+func markdownBlocks(content string) []string {
+	var blocks []string
+	var current []string
+
+	inFence := false
+	for _, line := range strings.Split(content, "\n") {
+		if IsFence(line) {
+			inFence = !inFence
+		}
+
+		if !inFence && strings.TrimSpace(line) == "" {
+			if len(current) > 0 {
+				blocks = append(blocks, strings.Join(current, "\n"))
+				current = nil
+			}
+			continue
+		}
+
+		current = append(current, line)
+	}
+
+	if len(current) > 0 {
+		blocks = append(blocks, strings.Join(current, "\n"))
+	}
+
+	return blocks
+}
+
+// hardSplit splits a single line which is longer than limit. It prefers to
+// split on whitespace, never splits inside a markdown image and never splits
+// a multi-byte character.
+func hardSplit(line string, limit int) []string {
+	var pieces []string
+
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+
+		if space := strings.LastIndexAny(line[:cut], " \t"); space > limit/2 {
+			cut = space
+		}
+
+		for _, match := range feed.MarkdownImageRegex.FindAllStringIndex(line, -1) {
+			if match[0] < cut && cut < match[1] && match[0] > 0 {
+				cut = match[0]
+			}
+		}
+
+		pieces = append(pieces, strings.TrimRight(line[:cut], " \t"))
+		line = strings.TrimLeft(line[cut:], " \t")
+	}
+
+	if line != "" {
+		pieces = append(pieces, line)
+	}
+
+	return pieces
+}
+
+// packLines greedily joins lines with a newline into pieces of at most limit
+// bytes. Lines longer than limit are hard split.
+func packLines(lines []string, limit int) []string {
+	var pieces []string
+	var current []string
+
+	size := 0
+	for _, line := range lines {
+		parts := hardSplit(line, limit)
+		if len(parts) == 0 {
+			parts = []string{""}
+		}
+
+		for _, part := range parts {
+			if len(current) > 0 && size+1+len(part) > limit {
+				pieces = append(pieces, strings.Join(current, "\n"))
+				current, size = nil, 0
+			}
+
+			if len(current) > 0 {
+				size++
+			}
+			current = append(current, part)
+			size += len(part)
+		}
+	}
+
+	if len(current) > 0 {
+		pieces = append(pieces, strings.Join(current, "\n"))
+	}
+
+	return pieces
+}
+
+// splitBlock splits a block which is larger than limit. Fenced code blocks are
+// closed at the end of every piece and re-opened at the start of the next.
+func splitBlock(block string, limit int) []string {
+	lines := strings.Split(block, "\n")
+
+	if !IsFence(lines[0]) {
+		return packLines(lines, limit)
+	}
+
+	opening := strings.TrimSpace(lines[0])
+	closing := opening[:3]
+
+	body := lines[1:]
+	if len(body) > 0 && IsFence(body[len(body)-1]) {
+		body = body[:len(body)-1]
+	}
+
+	var pieces []string
+	for _, piece := range packLines(body, limit-len(opening)-len(closing)-2) {
+		pieces = append(pieces, opening+"\n"+piece+"\n"+closing)
+	}
+
+	return pieces
+}
+
+// chunkMarkdown chunks a full markdown converted RSS post into a thread.
+// Meaning, a series of chunks which fit under limit bytes. Chunks are split on
+// paragraph boundaries and never inside fenced code blocks or image refs.
+func chunkMarkdown(content string, limit int) []string {
+	var chunks []string
+	var current string
+
+	for _, block := range markdownBlocks(content) {
+		pieces := []string{block}
+		if len(block) > limit {
+			pieces = splitBlock(block, limit)
+		}
+
+		for _, piece := range pieces {
+			if current != "" && len(current)+2+len(piece) > limit {
+				chunks = append(chunks, current)
+				current = ""
+			}
+
+			if current == "" {
+				current = piece
+			} else {
+				current += "\n\n" + piece
+			}
+		}
+	}
+
+	if current != "" {
+		chunks = append(chunks, current)
+	}
+
+	return chunks
+}
+
+// threadMarkerLength is the space reserved in every thread chunk for the
+// "(part N/M)" marker.
+const threadMarkerLength = len("\n\n*(part 999/999)*")
+
+// threadMarker renders the "(part N/M)" marker of a thread chunk.
+func threadMarker(part, total int) string {
+	return fmt.Sprintf("*(part %d/%d)*", part, total)
+}
+
+// ThreadChunks splits the text of a post which is too long into the texts of
+// the thread messages, including their "(part N/M)" markers. No text is
+// longer than limit.
+func ThreadChunks(text string, limit int) []string {
+	chunks := chunkMarkdown(text, limit-threadMarkerLength)
+
+	var texts []string
+	for idx, chunk := range chunks {
+		if idx == 0 {
+			texts = append(texts, chunk+"\n\n"+threadMarker(1, len(chunks)))
+			continue
+		}
+		texts = append(texts, threadMarker(idx+1, len(chunks))+"\n\n"+chunk)
+	}
+
+	return texts
+}
+
+// publishAsThread posts a message as a series of linked messages. This is
+// useful when the content of the RSS post is too long. Every reply links the
+// root and the previous message (branch) so that clients render the thread in
+// order and every message carries a "(part N/M)" marker. The progress is
+// recorded in the state store before anything is published. The key of the
+// root message and the number of messages are returned.
+func publishAsThread(publish publisher, message map[string]interface{}, cfg feed.Config, state *State) (string, int, error) {
+	link := message["link"].(string)
+
+	thread := &ThreadProgress{
+		Feed:   cfg.Feed,
+		Chunks: ThreadChunks(message["text"].(string), feed.PostLimit(cfg)),
+		Fields: make(map[string]interface{}),
+	}
+	for key, value := range message {
+		if key != "type" && key != "link" && key != "text" {
+			thread.Fields[key] = value
+		}
+	}
+
+	state.Threads[link] = thread
+	if err := state.Save(); err != nil {
+		return "", 0, fmt.Errorf("publishAsThread: %w", err)
+	}
+
+	root, err := continueThread(publish, link, state)
+	if err != nil {
+		return "", 0, fmt.Errorf("publishAsThread: %w", err)
+	}
+
+	return root, len(thread.Chunks), nil
+}
+
+// continueThread publishes the remaining chunks of a thread, recording every
+// published message in the state store. The thread is removed from the state
+// store once it is complete, the key of its root message is returned.
+func continueThread(publish publisher, link string, state *State) (string, error) {
+	thread := state.Threads[link]
+
+	for idx := len(thread.Messages); idx < len(thread.Chunks); idx++ {
+		threadMessage := map[string]interface{}{
+			"type": "post",
+			"link": link,
+			"text": thread.Chunks[idx],
+		}
+
+		if idx == 0 {
+			for key, value := range thread.Fields {
+				threadMessage[key] = value
+			}
+		} else {
+			threadMessage["root"] = thread.Messages[0]
+			threadMessage["branch"] = thread.Messages[idx-1]
+			if warning, ok := thread.Fields["contentWarning"]; ok {
+				threadMessage["contentWarning"] = warning
+			}
+		}
+
+		ref, err := publish.Publish(threadMessage)
+		if err != nil {
+			return "", fmt.Errorf("continueThread: failed to publish: %w", err)
+		}
+
+		thread.Messages = append(thread.Messages, ref.Key().String())
+		if err := state.Save(); err != nil {
+			return "", fmt.Errorf("continueThread: %w", err)
+		}
+	}
+
+	delete(state.Threads, link)
+	if err := state.Save(); err != nil {
+		return "", fmt.Errorf("continueThread: %w", err)
+	}
+
+	return thread.Messages[0], nil
+}
+
+// reconcileThread adopts thread messages which made it into the log but not
+// into the state store, e.g. when the process died right after publishing.
+func reconcileThread(thread *ThreadProgress, link string, posts []Post) {
+	for len(thread.Messages) < len(thread.Chunks) {
+		idx := len(thread.Messages)
+
+		var found string
+		for _, post := range posts {
+			if post.Link != link || post.Text != thread.Chunks[idx] {
+				continue
+			}
+			if idx > 0 && post.Root != thread.Messages[0] {
+				continue
+			}
+			found = post.Key
+		}
+
+		if found == "" {
+			return
+		}
+
+		thread.Messages = append(thread.Messages, found)
+	}
+}
+
+// resumeThreads completes the threads of a feed which were partially
+// published before the process died, instead of duplicating or truncating
+// them. The links of the resumed threads are returned.
+func resumeThreads(publish publisher, pub *sbot.Sbot, feedURL string, state *State) (map[string]bool, error) {
+	resumed := make(map[string]bool)
+
+	if len(state.Threads) == 0 {
+		return resumed, nil
+	}
+
+	posts, err := MessagesFromLog(pub)
+	if err != nil {
+		return resumed, fmt.Errorf("resumeThreads: %w", err)
+	}
+
+	for link, thread := range state.Threads {
+		if thread.Feed != "" && thread.Feed != feedURL {
+			continue
+		}
+
+		reconcileThread(thread, link, posts)
+
+		log.Printf("resumeThreads: resuming thread for %s at part %d/%d", link, len(thread.Messages)+1, len(thread.Chunks))
+
+		if _, err := continueThread(publish, link, state); err != nil {
+			return resumed, fmt.Errorf("resumeThreads: %w", err)
+		}
+
+		resumed[link] = true
+	}
+
+	return resumed, nil
+}
+
+// PostMessagesToLog posts messages to the local user feed. Partially
+// published threads are completed first. Messages are queued in the state
+// store and at most max-posts-per-cycle posts are published per call, spaced
+// out by publish-spacing and only during the publish-window. The remainder is
+// published on subsequent calls, as is everything after the message in flight
+// when ctx is cancelled. The messages of a moderated feed are only queued once
+// they're approved.
+func PostMessagesToLog(ctx context.Context, messages []map[string]interface{}, pub *sbot.Sbot, cfg feed.Config, state *State) error {
+	as, err := author(pub, cfg.Feed, true)
+	if err != nil {
+		return fmt.Errorf("PostMessagesToLog: %w", err)
+	}
+
+	publish, err := openPublisher(pub, as)
+	if err != nil {
+		return fmt.Errorf("PostMessagesToLog: %w", err)
+	}
+
+	// threads of a private group only exist once the group does
+	threads := publish
+	if cfg.PrivateGroup.Name == "" || state.Feed(cfg.Feed).Group != "" {
+		if threads, err = postPublisher(pub, publish, cfg, state); err != nil {
+			return fmt.Errorf("PostMessagesToLog: %w", err)
+		}
+	}
+
+	resumed, err := resumeThreads(threads, pub, cfg.Feed, state)
+	if err != nil {
+		return fmt.Errorf("PostMessagesToLog: %w", err)
+	}
+
+	messages = holdForApproval(cfg, state, messages)
+
+	feedState := state.Feed(cfg.Feed)
+	feedState.Queue = append(feedState.Queue, messages...)
+	if cfg.Digest != "" {
+		var queue []map[string]interface{}
+		for _, message := range feedState.Queue {
+			if isDigestItem(message) {
+				feedState.Digest = append(feedState.Digest, message)
+			} else {
+				queue = append(queue, message)
+			}
+		}
+		feedState.Queue = queue
+	}
+	if err := state.Save(); err != nil {
+		return fmt.Errorf("PostMessagesToLog: %w", err)
+	}
+
+	published := 0
+	for len(feedState.Queue) > 0 {
+		message := feedState.Queue[0]
+
+		if link, ok := message["link"].(string); ok && resumed[link] {
+			log.Printf("PostMessagesToLog: skipping %s, thread was resumed", link)
+		} else if message["type"] != "about" && cfg.MaxPostsPerCycle > 0 && published >= cfg.MaxPostsPerCycle {
+			log.Printf("PostMessagesToLog: %d message(s) queued for the next cycle", len(feedState.Queue))
+			break
+		} else if open, opens := feed.PublishWindowOpen(cfg, time.Now()); message["type"] != "about" && !open {
+			log.Printf("PostMessagesToLog: %d message(s) queued until the publish window opens at %s", len(feedState.Queue), opens.Format(time.RFC3339))
+			break
+		} else {
+			if message["type"] != "about" {
+				if published > 0 && cfg.PublishSpacing > 0 {
+					select {
+					case <-time.After(cfg.PublishSpacing):
+					case <-ctx.Done():
+					}
+				}
+
+				if ctx.Err() != nil {
+					log.Printf("PostMessagesToLog: stopping, %d message(s) left in the queue", len(feedState.Queue))
+					return nil
+				}
+				published++
+			}
+
+			target := publish
+			if message["type"] != "about" {
+				if target, err = postPublisher(pub, publish, cfg, state); err != nil {
+					return fmt.Errorf("PostMessagesToLog: %w", err)
+				}
+			}
+
+			item, err := publishMessage(target, message, cfg, state)
+			if err != nil {
+				return fmt.Errorf("PostMessagesToLog: %w", err)
+			}
+
+			publishNostr(cfg.Nostr, message, state, feedState)
+
+			if message["type"] != "about" {
+				feedState.recordItem(item)
+				notifyPublished(cfg, message, item)
+			}
+
+			feedState.Published++
+			feedState.Blobs += countBlobs(message)
+			if link, ok := message["link"].(string); ok {
+				feedState.LastItem = link
+				feedState.LastPublished = time.Now()
+			}
+		}
+
+		feedState.Queue = feedState.Queue[1:]
+		if err := state.Save(); err != nil {
+			return fmt.Errorf("PostMessagesToLog: %w", err)
+		}
+	}
+
+	if cfg.Digest != "" {
+		digest, err := postPublisher(pub, publish, cfg, state)
+		if err != nil {
+			return fmt.Errorf("PostMessagesToLog: %w", err)
+		}
+
+		if err := publishDigest(digest, cfg, state); err != nil {
+			return fmt.Errorf("PostMessagesToLog: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// isDigestItem reports whether a message is a new item, which is collected
+// into the digest in digest mode. About messages, the introduction post and
+// updates of published items are published as usual.
+func isDigestItem(message map[string]interface{}) bool {
+	if _, ok := message["root"]; ok {
+		return false
+	}
+
+	if _, ok := message["link"]; !ok {
+		return false
+	}
+
+	return message["type"] == "post" || message["type"] == "blog"
+}
+
+// digestEntry returns the title and a one-line excerpt of a collected item.
+func digestEntry(message map[string]interface{}) (string, string) {
+	link, _ := message["link"].(string)
+	title, _ := message["title"].(string)
+
+	text, ok := message["summary"].(string)
+	if !ok {
+		text, _ = message["text"].(string)
+	}
+
+	if title == "" && strings.HasPrefix(text, "# ") {
+		line, rest, _ := strings.Cut(text, "\n")
+		title, text = strings.TrimPrefix(line, "# "), rest
+	}
+
+	if title == "" {
+		title = link
+	}
+
+	excerpt := feed.Summarise(text, feed.Config{SummaryParagraphs: 1, SummaryCharacters: feed.DigestExcerptLength})
+	excerpt = strings.Join(strings.Fields(excerpt), " ")
+
+	return title, excerpt
+}
+
+// digestMessage returns a single post which lists the collected items of a
+// feed, in the channel of the feed if one is configured.
+func digestMessage(cfg feed.Config, items []map[string]interface{}) map[string]interface{} {
+	var text strings.Builder
+	fmt.Fprintf(&text, "# Digest: %d new item(s)\n\n", len(items))
+
+	var warnings []string
+	for _, item := range items {
+		if warning, ok := item["contentWarning"].(string); ok && !containsString(warnings, warning) {
+			warnings = append(warnings, warning)
+		}
+
+		title, excerpt := digestEntry(item)
+		fmt.Fprintf(&text, "- **[%s](%s)**", title, item["link"])
+		if excerpt != "" {
+			fmt.Fprintf(&text, ": %s", excerpt)
+		}
+		text.WriteString("\n")
+	}
+
+	fmt.Fprintf(&text, "\n---\n[Feed](%s)\n", cfg.Feed)
+
+	message := map[string]interface{}{
+		"type": "post",
+		"link": cfg.Feed,
+		"text": text.String(),
+	}
+
+	if cfg.Channel != "" {
+		message["channel"] = cfg.Channel
+	}
+
+	if len(warnings) > 0 {
+		message["contentWarning"] = strings.Join(warnings, ", ")
+	}
+
+	return message
+}
+
+// containsString reports whether values contains value.
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// publishDigest publishes the collected items of a feed as a single post when
+// its digest is due. The links of the items are recorded as ignored, so that
+// they're not picked up again.
+func publishDigest(publish publisher, cfg feed.Config, state *State) error {
+	// the feeds are fetched concurrently, which reads the digest and the
+	// ignored links with the lock held
+	state.mu.Lock()
+	feedState := state.Feed(cfg.Feed)
+	digest := feedState.Digest
+	state.mu.Unlock()
+
+	now := time.Now()
+	if feedState.LastDigest.IsZero() {
+		state.mu.Lock()
+		feedState.LastDigest = now
+		state.mu.Unlock()
+
+		if err := state.Save(); err != nil {
+			return fmt.Errorf("publishDigest: %w", err)
+		}
+		return nil
+	}
+
+	if now.Before(feed.NextDigest(cfg, feedState.LastDigest)) {
+		return nil
+	}
+
+	if len(digest) > 0 {
+		log.Printf("publishDigest: publishing digest of %d item(s) of %s", len(digest), cfg.Feed)
+
+		message := digestMessage(cfg, digest)
+		item, err := publishMessage(publish, message, cfg, state)
+		if err != nil {
+			return fmt.Errorf("publishDigest: %w", err)
+		}
+		item.Type = "digest"
+		notifyPublished(cfg, message, item)
+
+		publishNostr(cfg.Nostr, message, state, feedState)
+
+		state.mu.Lock()
+		feedState.recordItem(item)
+		for _, item := range digest {
+			if link, ok := item["link"].(string); ok {
+				state.Ignored[link] = true
+				feedState.LastItem = link
+			}
+		}
+
+		feedState.Published++
+		feedState.Blobs += countBlobs(message)
+		feedState.LastPublished = now
+		feedState.Digest = nil
+		state.mu.Unlock()
+	}
+
+	state.mu.Lock()
+	feedState.LastDigest = now
+	state.mu.Unlock()
+
+	if err := state.Save(); err != nil {
+		return fmt.Errorf("publishDigest: %w", err)
+	}
+
+	return nil
+}
+
+// blobRefRegex matches blob references.
+var blobRefRegex = regexp.MustCompile(`&[A-Za-z0-9+/]{43}=\.sha256`)
+
+// marshalUnescaped marshals v to JSON without escaping "&", so that blob
+// references can be found in the output.
+func marshalUnescaped(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(v); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// blobRefs returns the distinct blobs referenced by a message.
+func blobRefs(message map[string]interface{}) []string {
+	contents, err := marshalUnescaped(message)
+	if err != nil {
+		return nil
+	}
+
+	var found []string
+	seen := make(map[string]bool)
+	for _, ref := range blobRefRegex.FindAllString(string(contents), -1) {
+		if !seen[ref] {
+			seen[ref] = true
+			found = append(found, ref)
+		}
+	}
+
+	return found
+}
+
+// countBlobs returns the number of distinct blobs referenced by a message.
+func countBlobs(message map[string]interface{}) int {
+	return len(blobRefs(message))
+}
+
+// publishMessage publishes a single message of a feed, turning posts which are
+// too long into threads. The published item is returned.
+func publishMessage(publish publisher, message map[string]interface{}, cfg feed.Config, state *State) (PublishedItem, error) {
+	item := PublishedItem{Messages: 1, Blobs: blobRefs(message)}
+	item.Type, _ = message["type"].(string)
+	item.Link, _ = message["link"].(string)
+	item.Channel, _ = message["channel"].(string)
+	if _, ok := message["root"]; ok {
+		item.Type = "update"
+	}
+
+	if message["type"] == "post" {
+		log.Printf("publishMessage: publishing %s to log", message["link"])
+
+		if len(message["text"].(string)) > feed.PostLimit(cfg) {
+			log.Printf("publishMessage: turning content of %s into thread, too long", message["link"])
+			root, messages, err := publishAsThread(publish, message, cfg, state)
+			if err != nil {
+				return item, fmt.Errorf("publishMessage: unable to thread content for %s: %w", message["link"], err)
+			}
+			item.Key, item.Messages, item.Published = root, messages, time.Now()
+			return item, nil
+		}
+	}
+
+	ref, err := publish.Publish(message)
+	if err != nil {
+		return item, fmt.Errorf("publishMessage: failed to publish: %w", err)
+	}
+	item.Key, item.Published = ref.Key().String(), time.Now()
+
+	return item, nil
+}
+
+// FeedPoll is the result of fetching and converting a feed.
+type FeedPoll struct {
+	Feed     gofeed.Feed
+	Messages []map[string]interface{}
+	Err      error
+}
+
+// FetchFeeds fetches and converts the new posts of several feeds at the same
+// time, with at most concurrency feeds in flight. Results are returned in the
+// order of feedCfgs so that they can be published in a stable order. Fetches
+// in flight are cancelled with ctx.
+func FetchFeeds(ctx context.Context, feedCfgs []feed.Config, concurrency int, pub *sbot.Sbot, blobs feed.BlobPutter, state *State) ([]FeedPoll, error) {
+	posts, err := MessagesFromLog(pub)
+	if err != nil {
+		return nil, fmt.Errorf("FetchFeeds: %w", err)
+	}
+
+	log.Printf("FetchFeeds: retrieved %d posts from log", len(posts))
+
+	polls := make([]FeedPoll, len(feedCfgs))
+	sem := make(chan struct{}, concurrency)
+
+	var wg sync.WaitGroup
+	for idx := range feedCfgs {
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func(idx int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			feedCfg := feedCfgs[idx]
+
+			state.mu.Lock()
+			feedURL := feedCfg.Feed
+			if moved := state.Feed(feedCfg.Feed).MovedTo; moved != "" {
+				feedURL = moved
+			}
+			state.mu.Unlock()
+
+			parsed, err := feed.Fetch(ctx, feedCfg, feedURL)
+			if err != nil {
+				polls[idx].Err = fmt.Errorf("FetchFeeds: %w", err)
+				return
+			}
+
+			if moved := parsed.Custom["permanentRedirect"]; moved != "" && moved != feedURL {
+				log.Printf("FetchFeeds: warning: %s moved permanently to %s, polling that from now on", feedURL, moved)
+
+				state.mu.Lock()
+				state.Feed(feedCfg.Feed).MovedTo = moved
+				state.mu.Unlock()
+			}
+
+			log.Printf("FetchFeeds: parsed %s", feedCfg.Feed)
+
+			messages, err := getNewRSSPosts(ctx, parsed, posts, blobs, feedCfg, state)
+			if err != nil {
+				polls[idx].Err = fmt.Errorf("FetchFeeds: %w", err)
+				return
+			}
+
+			polls[idx] = FeedPoll{Feed: parsed, Messages: messages}
+		}(idx)
+	}
+
+	wg.Wait()
+
+	return polls, nil
+}
@@ -0,0 +1,117 @@
+package publish
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/mmcdole/gofeed"
+	"github.com/ssbc/go-ssb/sbot"
+
+	"decentral1se/rss-butt-plug/feed"
+)
+
+// findItem returns the item of a configured feed whose link or GUID is
+// target, together with the feed and its config. Feeds which moved are
+// fetched from their new URL.
+func findItem(ctx context.Context, feedCfgs []feed.Config, target string, state *State) (*gofeed.Item, gofeed.Feed, feed.Config, error) {
+	for _, cfg := range feedCfgs {
+		feedURL := cfg.Feed
+		if moved := state.Feed(cfg.Feed).MovedTo; moved != "" {
+			feedURL = moved
+		}
+
+		parsed, err := feed.Parse(ctx, feedURL, cfg)
+		if err != nil {
+			return nil, parsed, cfg, fmt.Errorf("findItem: %w", err)
+		}
+
+		for _, item := range parsed.Items {
+			if item.Link == target || item.GUID == target || feed.StripTrackingParams(item.Link, cfg.TrackingParams) == target {
+				return item, parsed, cfg, nil
+			}
+		}
+	}
+
+	return nil, gofeed.Feed{}, feed.Config{}, fmt.Errorf("findItem: no item with link or GUID %s in the configured feeds", target)
+}
+
+// Republish converts the item with the link or GUID target again and
+// publishes it as a new post which references the post it replaces, e.g. when
+// a conversion bug mangled the original. Later corrections reply to the new
+// post. It returns the link of the item.
+func Republish(ctx context.Context, pub *sbot.Sbot, blobs feed.BlobPutter, feedCfgs []feed.Config, target string, state *State) (string, error) {
+	item, parsed, cfg, err := findItem(ctx, feedCfgs, target, state)
+	if err != nil {
+		return "", fmt.Errorf("Republish: %w", err)
+	}
+
+	link := feed.StripTrackingParams(item.Link, cfg.TrackingParams)
+
+	posts, err := MessagesFromLog(pub)
+	if err != nil {
+		return link, fmt.Errorf("Republish: %w", err)
+	}
+
+	var original string
+	for _, post := range posts {
+		if post.Root == "" && (post.Type == "post" || post.Type == "blog") && feed.StripTrackingParams(post.Link, cfg.TrackingParams) == link {
+			original = post.Key
+		}
+	}
+
+	message, err := feed.ItemMessage(ctx, item, parsed.Link, link, blobs, cfg)
+	if err != nil {
+		return link, fmt.Errorf("Republish: %w", err)
+	}
+
+	if original != "" {
+		note := fmt.Sprintf("**Republished:** this replaces [the earlier post](%s) of this item.\n\n", original)
+		if text, ok := message["text"].(string); ok {
+			message["text"] = note + text
+		} else if summary, ok := message["summary"].(string); ok {
+			message["summary"] = note + summary
+		}
+
+		mentions, _ := message["mentions"].([]map[string]string)
+		message["mentions"] = append(mentions, map[string]string{"link": original})
+	} else {
+		log.Printf("Republish: %s wasn't published before, publishing it", link)
+	}
+
+	as, err := author(pub, cfg.Feed, true)
+	if err != nil {
+		return link, fmt.Errorf("Republish: %w", err)
+	}
+
+	public, err := openPublisher(pub, as)
+	if err != nil {
+		return link, fmt.Errorf("Republish: %w", err)
+	}
+
+	publish, err := postPublisher(pub, public, cfg, state)
+	if err != nil {
+		return link, fmt.Errorf("Republish: %w", err)
+	}
+
+	published, err := publishMessage(publish, message, cfg, state)
+	if err != nil {
+		return link, fmt.Errorf("Republish: %w", err)
+	}
+	state.Feed(cfg.Feed).recordItem(published)
+	notifyPublished(cfg, message, published)
+
+	if cfg.PublishUpdates {
+		hash, err := feed.ItemHash(ctx, item, parsed.Link, blobs, cfg)
+		if err != nil {
+			return link, fmt.Errorf("Republish: %w", err)
+		}
+		state.swapHash(link, hash)
+	}
+
+	if err := state.Save(); err != nil {
+		return link, fmt.Errorf("Republish: %w", err)
+	}
+
+	return link, nil
+}
@@ -0,0 +1,48 @@
+package publish
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/ssbc/go-ssb/sbot"
+
+	"decentral1se/rss-butt-plug/feed"
+)
+
+// ResetFeed clears the state of a feed, so that its items are published again
+// on the next poll as if it was a new feed. The posts in the log stay, but
+// they don't count as published any more. Queued messages, unfinished threads
+// and the ignored items and content hashes of the current items are dropped,
+// the private group of the feed is kept.
+func ResetFeed(ctx context.Context, pub *sbot.Sbot, cfg feed.Config, state *State) error {
+	previous := state.Feed(cfg.Feed)
+	state.Feeds[cfg.Feed] = &FeedState{
+		Group:        previous.Group,
+		GroupMembers: previous.GroupMembers,
+		ResetSeq:     pub.ReceiveLog.Seq() + 1,
+	}
+
+	for link, thread := range state.Threads {
+		if thread.Feed == cfg.Feed {
+			delete(state.Threads, link)
+		}
+	}
+
+	parsed, err := feed.Parse(ctx, cfg.Feed, cfg)
+	if err != nil {
+		log.Printf("ResetFeed: keeping ignored items and hashes of %s: %s", cfg.Feed, err)
+	} else {
+		for _, item := range parsed.Items {
+			link := feed.StripTrackingParams(item.Link, cfg.TrackingParams)
+			delete(state.Ignored, link)
+			delete(state.Hashes, link)
+		}
+	}
+
+	if err := state.Save(); err != nil {
+		return fmt.Errorf("ResetFeed: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,467 @@
+package publish
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ssbc/go-luigi"
+	refs "github.com/ssbc/go-ssb-refs"
+	"github.com/ssbc/go-ssb/sbot"
+	"github.com/ssbc/margaret"
+	"github.com/yuin/goldmark"
+)
+
+// reverseFeedLength is the number of posts served by the reverse feed.
+const reverseFeedLength = 50
+
+// feedEntry is a published post, as served by the reverse feed.
+type feedEntry struct {
+	Key       string
+	Title     string
+	Link      string
+	Markdown  string
+	Published time.Time
+}
+
+// ownPosts are the posts and blogs published by the pub identity (and its
+// subfeeds), read incrementally from the log so that serving the reverse feed
+// doesn't scan the whole log on every request. Only the blobs which the
+// public posts refer to are served.
+type ownPosts struct {
+	pub *sbot.Sbot
+
+	mu      sync.Mutex
+	nextSeq int64
+	self    map[string]bool
+	name    string
+	entries []*feedEntry
+	roots   map[string]*feedEntry
+	blobs   map[string]bool
+}
+
+// newOwnPosts returns the own posts of the pub, they're read on update.
+func newOwnPosts(pub *sbot.Sbot) *ownPosts {
+	return &ownPosts{
+		pub:   pub,
+		name:  pub.KeyPair.ID().String(),
+		roots: make(map[string]*feedEntry),
+		blobs: make(map[string]bool),
+	}
+}
+
+// update reads the messages which were appended to the log since the last
+// update. Thread replies are folded into their root post.
+func (o *ownPosts) update() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.self == nil {
+		self, err := ownFeeds(o.pub)
+		if err != nil {
+			return fmt.Errorf("update: %w", err)
+		}
+		o.self = self
+	} else if MetafeedMode(o.pub) {
+		listed, err := o.pub.MetaFeeds.ListSubFeeds(o.pub.KeyPair.ID())
+		if err != nil {
+			return fmt.Errorf("update: unable to list subfeeds: %w", err)
+		}
+		for _, entry := range listed {
+			o.self[entry.Feed.String()] = true
+		}
+	}
+
+	src, err := o.pub.ReceiveLog.Query(margaret.SeqWrap(true), margaret.Gte(o.nextSeq))
+	if err != nil {
+		return fmt.Errorf("update: unable to query log: %w", err)
+	}
+
+	for {
+		v, err := src.Next(context.Background())
+		if luigi.IsEOS(err) {
+			break
+		}
+
+		wrapped, ok := v.(margaret.SeqWrapper)
+		if !ok {
+			continue
+		}
+		o.nextSeq = wrapped.Seq() + 1
+
+		message, ok := wrapped.Value().(refs.Message)
+		if !ok || !o.self[message.Author().String()] {
+			continue
+		}
+
+		var content struct {
+			Type    string `json:"type"`
+			Link    string `json:"link"`
+			Text    string `json:"text"`
+			Root    string `json:"root"`
+			Name    string `json:"name"`
+			Title   string `json:"title"`
+			Summary string `json:"summary"`
+			Blog    string `json:"blog"`
+		}
+		if err := json.Unmarshal(message.ContentBytes(), &content); err != nil {
+			// private posts are boxed strings, they're never served
+			continue
+		}
+
+		switch {
+		case content.Type == "about" && content.Name != "":
+			o.name = content.Name
+		case content.Type == "post" && content.Root != "":
+			if root, ok := o.roots[content.Root]; ok {
+				root.Markdown += "\n\n" + stripThreadMarker(content.Text)
+				referencedBlobs([]byte(content.Text), o.blobs)
+			}
+		case content.Type == "post":
+			entry := &feedEntry{
+				Key:       message.Key().String(),
+				Link:      content.Link,
+				Markdown:  stripThreadMarker(content.Text),
+				Published: publishedAt(message),
+			}
+			o.entries = append(o.entries, entry)
+			o.roots[entry.Key] = entry
+			referencedBlobs([]byte(content.Text), o.blobs)
+		case content.Type == "blog":
+			markdown := content.Summary
+			if ref, err := refs.ParseBlobRef(content.Blog); err == nil {
+				if blob, err := o.pub.BlobStore.Get(ref); err == nil {
+					if body, err := io.ReadAll(blob); err == nil {
+						markdown = string(body)
+					}
+					blob.Close()
+				}
+				o.blobs[ref.String()] = true
+			}
+			referencedBlobs([]byte(markdown), o.blobs)
+			o.entries = append(o.entries, &feedEntry{
+				Key:       message.Key().String(),
+				Title:     content.Title,
+				Link:      content.Link,
+				Markdown:  markdown,
+				Published: publishedAt(message),
+			})
+		}
+	}
+
+	return nil
+}
+
+// latest returns the name of the pub and its latest limit (0 for all)
+// entries, newest first. Entries without a title are titled by their
+// leading heading, or their link.
+func (o *ownPosts) latest(limit int) (string, []*feedEntry) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	var entries []*feedEntry
+	for idx := len(o.entries) - 1; idx >= 0; idx-- {
+		if limit > 0 && len(entries) == limit {
+			break
+		}
+
+		entry := *o.entries[idx]
+		if entry.Title == "" {
+			if heading, rest, _ := strings.Cut(entry.Markdown, "\n"); strings.HasPrefix(heading, "# ") {
+				entry.Title = strings.TrimPrefix(heading, "# ")
+				entry.Markdown = strings.TrimSpace(rest)
+			} else {
+				entry.Title = entry.Link
+			}
+		}
+		entries = append(entries, &entry)
+	}
+
+	return o.name, entries
+}
+
+// published reports whether a public post refers to the blob ref.
+func (o *ownPosts) published(ref string) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return o.blobs[ref]
+}
+
+// ownEntries retrieves the latest limit (0 for all) posts and blogs published
+// by the pub identity (and its subfeeds), newest first. Thread replies are
+// folded into their root post.
+func ownEntries(pub *sbot.Sbot, limit int) (string, []*feedEntry, error) {
+	posts := newOwnPosts(pub)
+	if err := posts.update(); err != nil {
+		return pub.KeyPair.ID().String(), nil, fmt.Errorf("ownEntries: %w", err)
+	}
+
+	name, entries := posts.latest(limit)
+
+	return name, entries, nil
+}
+
+// publishedAt returns the claimed timestamp of a message, or when it was
+// appended to the log if the claimed timestamp can't be read.
+func publishedAt(message refs.Message) time.Time {
+	if claimed := message.Claimed(); claimed.Unix() > 0 {
+		return claimed
+	}
+
+	return message.Received()
+}
+
+// threadMarkerRegex matches the part markers appended to the first chunk of a
+// thread and prepended to the other chunks.
+var threadMarkerRegex = regexp.MustCompile(`^\s*\*\(part \d+/\d+\)\*\n*|\n*\*\(part \d+/\d+\)\*\s*$`)
+
+// stripThreadMarker removes the part marker of a thread chunk.
+func stripThreadMarker(text string) string {
+	return threadMarkerRegex.ReplaceAllString(text, "")
+}
+
+// blobLinkRegex matches blob references used as markdown link targets.
+var blobLinkRegex = regexp.MustCompile(`\]\((&[A-Za-z0-9+/]+=*\.sha256)`)
+
+// entryHTML renders the markdown of a feed entry to HTML, pointing blob
+// references to the URLs returned by blobURL.
+func entryHTML(markdown string, blobURL func(ref string) string) string {
+	markdown = blobLinkRegex.ReplaceAllStringFunc(markdown, func(match string) string {
+		return "](" + blobURL(strings.TrimPrefix(match, "]("))
+	})
+
+	var rendered bytes.Buffer
+	if err := goldmark.Convert([]byte(markdown), &rendered); err != nil {
+		return html.EscapeString(markdown)
+	}
+
+	return rendered.String()
+}
+
+// blobEndpoint returns a function which points blob references to the blob
+// endpoint of the reverse feed server at baseURL.
+func blobEndpoint(baseURL string) func(ref string) string {
+	return func(ref string) string {
+		return baseURL + "/blobs/" + url.PathEscape(ref)
+	}
+}
+
+// requestBaseURL returns the public base URL of the reverse feed server.
+func requestBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+
+	return scheme + "://" + r.Host
+}
+
+// rssDocument is a RSS 2.0 document.
+type rssDocument struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+// rssChannel is the channel of a RSS 2.0 document.
+type rssChannel struct {
+	Title       string    `xml:"title"`
+	Link        string    `xml:"link"`
+	Description string    `xml:"description"`
+	Items       []rssItem `xml:"item"`
+}
+
+// rssItem is an item of a RSS 2.0 document.
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link,omitempty"`
+	Description string  `xml:"description"`
+	GUID        rssGUID `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+}
+
+// rssGUID is the guid of a RSS 2.0 item.
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+// atomFeed is an Atom document.
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Link    atomLink    `xml:"link"`
+	Author  atomAuthor  `xml:"author"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+// atomLink is a link of an Atom document.
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+// atomAuthor is the author of an Atom document.
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+// atomContent is the content of an Atom entry.
+type atomContent struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+// atomEntry is an entry of an Atom document.
+type atomEntry struct {
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Link    *atomLink   `xml:"link,omitempty"`
+	Content atomContent `xml:"content"`
+}
+
+// ServeFeed serves the posts of the pub identity as RSS (/feed.xml) and Atom
+// (/atom.xml) feeds, along with the blobs they reference (/blobs/<ref>). It
+// only returns when the server fails.
+func ServeFeed(pub *sbot.Sbot, addr string) error {
+	log.Printf("ServeFeed: serving RSS/Atom feeds on %s", addr)
+
+	if err := http.ListenAndServe(addr, feedHandler(pub)); err != nil {
+		return fmt.Errorf("ServeFeed: %w", err)
+	}
+
+	return nil
+}
+
+// feedHandler returns the handler of the reverse feeds served by ServeFeed.
+func feedHandler(pub *sbot.Sbot) http.Handler {
+	posts := newOwnPosts(pub)
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/feed.xml", func(w http.ResponseWriter, r *http.Request) {
+		if err := posts.update(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		name, entries := posts.latest(reverseFeedLength)
+
+		baseURL := requestBaseURL(r)
+		doc := rssDocument{
+			Version: "2.0",
+			Channel: rssChannel{Title: name, Link: baseURL + "/feed.xml", Description: name + " on SSB"},
+		}
+
+		for _, entry := range entries {
+			doc.Channel.Items = append(doc.Channel.Items, rssItem{
+				Title:       entry.Title,
+				Link:        entry.Link,
+				Description: entryHTML(entry.Markdown, blobEndpoint(baseURL)),
+				GUID:        rssGUID{Value: entry.Key},
+				PubDate:     entry.Published.UTC().Format(time.RFC1123Z),
+			})
+		}
+
+		writeXML(w, "application/rss+xml", doc)
+	})
+
+	mux.HandleFunc("/atom.xml", func(w http.ResponseWriter, r *http.Request) {
+		if err := posts.update(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		name, entries := posts.latest(reverseFeedLength)
+
+		baseURL := requestBaseURL(r)
+		doc := atomFeed{
+			Title:   name,
+			ID:      baseURL + "/atom.xml",
+			Updated: time.Now().UTC().Format(time.RFC3339),
+			Link:    atomLink{Rel: "self", Href: baseURL + "/atom.xml"},
+			Author:  atomAuthor{Name: name},
+		}
+		if len(entries) > 0 {
+			doc.Updated = entries[0].Published.UTC().Format(time.RFC3339)
+		}
+
+		for _, entry := range entries {
+			atom := atomEntry{
+				Title:   entry.Title,
+				ID:      baseURL + "/atom.xml#" + url.QueryEscape(entry.Key),
+				Updated: entry.Published.UTC().Format(time.RFC3339),
+				Content: atomContent{Type: "html", Value: entryHTML(entry.Markdown, blobEndpoint(baseURL))},
+			}
+			if entry.Link != "" {
+				atom.Link = &atomLink{Href: entry.Link}
+			}
+			doc.Entries = append(doc.Entries, atom)
+		}
+
+		writeXML(w, "application/atom+xml", doc)
+	})
+
+	mux.HandleFunc("/blobs/", func(w http.ResponseWriter, r *http.Request) {
+		ref, err := refs.ParseBlobRef(strings.TrimPrefix(r.URL.Path, "/blobs/"))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		// replicated blobs and those of private posts aren't served
+		if err := posts.update(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !posts.published(ref.String()) {
+			http.NotFound(w, r)
+			return
+		}
+
+		blob, err := pub.BlobStore.Get(ref)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		defer blob.Close()
+
+		contents, err := io.ReadAll(blob)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", http.DetectContentType(contents))
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		w.Write(contents)
+	})
+
+	return mux
+}
+
+// writeXML writes an XML document as a HTTP response.
+func writeXML(w http.ResponseWriter, contentType string, doc interface{}) {
+	contents, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType+"; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	w.Write(contents)
+}
@@ -0,0 +1,104 @@
+package publish
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestOwnPostsUpdate(t *testing.T) {
+	pub := newTestPub(t, false)
+
+	image := putTestBlob(t, pub, "image of a thread")
+	secret := putTestBlob(t, pub, "image of a private post")
+
+	publishTest(t, pub, map[string]interface{}{"type": "about", "about": pub.KeyPair.ID().String(), "name": "Example"})
+	root := publishTest(t, pub, map[string]interface{}{
+		"type": "post", "link": "https://example.org/1",
+		"text": "# Thread\n\n![image](" + image + ")\n\n*(part 1/2)*",
+	})
+	publishTest(t, pub, map[string]interface{}{"type": "post", "root": root, "text": "*(part 2/2)*\n\nthe end"})
+	if err := sendPrivate(pub, pub.KeyPair.ID().String(), "![image]("+secret+")"); err != nil {
+		t.Fatal(err)
+	}
+
+	posts := newOwnPosts(pub)
+	if err := posts.update(); err != nil {
+		t.Fatal(err)
+	}
+
+	name, entries := posts.latest(0)
+	if name != "Example" {
+		t.Errorf("latest() name = %q, want the name of the about message", name)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("latest() = %d entries, want the thread folded into one", len(entries))
+	}
+	if entries[0].Key != root || entries[0].Title != "Thread" || !strings.HasSuffix(entries[0].Markdown, "the end") ||
+		strings.Contains(entries[0].Markdown, "part 1/2") {
+		t.Errorf("latest() = %+v, want the thread without its part markers", entries[0])
+	}
+
+	if !posts.published(image) || posts.published(secret) {
+		t.Errorf("published(%s) = %t, published(%s) = %t, want only the image of the public post",
+			image, posts.published(image), secret, posts.published(secret))
+	}
+
+	// only the messages which were appended since are read
+	publishTest(t, pub, map[string]interface{}{"type": "post", "link": "https://example.org/2", "text": "# Second"})
+	if err := posts.update(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, entries := posts.latest(0); len(entries) != 2 || entries[0].Title != "Second" || entries[1].Key != root {
+		t.Errorf("latest() = %v after an update, want the new post first", entries)
+	}
+	if _, entries := posts.latest(1); len(entries) != 1 {
+		t.Errorf("latest(1) = %d entries, want 1", len(entries))
+	}
+}
+
+func TestFeedHandler(t *testing.T) {
+	pub := newTestPub(t, false)
+
+	image := putTestBlob(t, pub, "image of a post")
+	stray := putTestBlob(t, pub, "replicated image")
+	publishTest(t, pub, map[string]interface{}{
+		"type": "post", "link": "https://example.org/1", "text": "# Post\n\n![image](" + image + ")",
+	})
+
+	server := httptest.NewServer(feedHandler(pub))
+	defer server.Close()
+
+	get := func(path string) (int, string) {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	status, body := get("/feed.xml")
+	if status != http.StatusOK || !strings.Contains(body, "<title>Post</title>") ||
+		!strings.Contains(body, server.URL+"/blobs/") {
+		t.Errorf("GET /feed.xml = %d %s, want the post with its image served from /blobs/", status, body)
+	}
+
+	if status, body := get("/atom.xml"); status != http.StatusOK || !strings.Contains(body, "https://example.org/1") {
+		t.Errorf("GET /atom.xml = %d %s, want the post", status, body)
+	}
+
+	if status, body := get("/blobs/" + url.PathEscape(image)); status != http.StatusOK || body != "image of a post" {
+		t.Errorf("GET /blobs/<image> = %d %q, want the image", status, body)
+	}
+
+	if status, _ := get("/blobs/" + url.PathEscape(stray)); status != http.StatusNotFound {
+		t.Errorf("GET /blobs/<stray> = %d, want 404 for a blob which no public post refers to", status)
+	}
+}
@@ -0,0 +1,335 @@
+package publish
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ssbc/go-luigi"
+	refs "github.com/ssbc/go-ssb-refs"
+	"github.com/ssbc/go-ssb/sbot"
+	"github.com/ssbc/margaret"
+)
+
+// Post is a ssb post message.
+type Post struct {
+	Key    string `json:"-"`
+	Author string `json:"-"`
+	Seq    int64  `json:"-"`
+	Type   string `json:"type"`
+	Link   string `json:"link"`
+	Text   string `json:"text"`
+	Root   string `json:"root,omitempty"`
+}
+
+// State is the rss-butt-plug state store. It is persisted as JSON in the data
+// directory and keeps track of things which can't be derived from the log.
+type State struct {
+	Threads map[string]*ThreadProgress `json:"threads,omitempty"`
+	Ignored map[string]bool            `json:"ignored,omitempty"`
+	Feeds   map[string]*FeedState      `json:"feeds,omitempty"`
+	Hashes  map[string]string          `json:"hashes,omitempty"`
+
+	// Introduced records that the introduction post was published.
+	Introduced bool `json:"introduced,omitempty"`
+
+	// LastSummary is when the last monthly summary post was published (or
+	// the count for the first one started).
+	LastSummary time.Time `json:"lastSummary"`
+
+	// NotifySeq is the receive log position up to which replies and mentions
+	// were forwarded.
+	NotifySeq int64 `json:"notifySeq,omitempty"`
+
+	// OwnMessages are the keys of the messages published by the pub, which
+	// replies are told apart by. It's nil until notifications are enabled.
+	OwnMessages map[string]bool `json:"ownMessages"`
+
+	// ModerationSeq is the receive log position from which on the private
+	// messages of the moderators are read next.
+	ModerationSeq int64 `json:"moderationSeq,omitempty"`
+
+	// FollowSeq is the receive log position from which on contact messages
+	// are read next by FollowBack. Followers are the identities which follow
+	// (or unfollowed) the pub, Followed the ones which the pub follows (or
+	// unfollowed), as of FollowSeq.
+	FollowSeq int64           `json:"followSeq,omitempty"`
+	Followers map[string]bool `json:"followers,omitempty"`
+	Followed  map[string]bool `json:"followed,omitempty"`
+
+	// Queue is only read to migrate state files from before per-feed queues.
+	Queue []map[string]interface{} `json:"queue,omitempty"`
+
+	// ReadOnly states are never persisted, e.g. in dry-run mode.
+	ReadOnly bool `json:"-"`
+
+	path string
+	mu   sync.Mutex
+}
+
+// FeedState is the state of a single feed, keyed by feed URL in the state
+// store. LastPoll is the last successful poll, LastPublished the time at which
+// LastItem was published. Published and Blobs count the messages and the blobs
+// referenced by them which were published for the feed.
+type FeedState struct {
+	LastPoll      time.Time                `json:"lastPoll"`
+	LastItem      string                   `json:"lastItem,omitempty"`
+	LastPublished time.Time                `json:"lastPublished"`
+	Published     int                      `json:"published,omitempty"`
+	Blobs         int                      `json:"blobs,omitempty"`
+	Failures      int                      `json:"failures,omitempty"`
+	LastError     string                   `json:"lastError,omitempty"`
+	Queue         []map[string]interface{} `json:"queue,omitempty"`
+
+	// DeadSince is the first of the failed polls in a row which suggest that
+	// the feed is gone, Paused is set once it's gone for dead-after.
+	DeadSince time.Time `json:"deadSince"`
+	Paused    bool      `json:"paused,omitempty"`
+
+	// MovedTo is the URL which the feed permanently redirects to, it's polled
+	// instead of the configured one.
+	MovedTo string `json:"movedTo,omitempty"`
+
+	// Digest holds the items collected for the next digest, which is due
+	// according to the digest schedule after LastDigest.
+	Digest     []map[string]interface{} `json:"digest,omitempty"`
+	LastDigest time.Time                `json:"lastDigest"`
+
+	// Group is the private group which the posts are published to, with the
+	// members which were added to it.
+	Group        string   `json:"group,omitempty"`
+	GroupMembers []string `json:"groupMembers,omitempty"`
+
+	// ResetSeq is the receive log position at which the feed was reset, the
+	// posts before it don't count as published.
+	ResetSeq int64 `json:"resetSeq,omitempty"`
+
+	// Items are the latest published items, oldest first.
+	Items []PublishedItem `json:"items,omitempty"`
+
+	// NostrErrors are the errors of the relays which the last broadcast to
+	// failed, keyed by relay. They're only accessed with the lock held.
+	NostrErrors map[string]string `json:"nostrErrors,omitempty"`
+
+	// Pending are the messages of a moderated feed which wait for approval,
+	// they're only accessed with the lock held.
+	Pending []PendingItem `json:"pending,omitempty"`
+}
+
+// maxPublishedItems is the number of published items which are kept per feed
+// in the state store.
+const maxPublishedItems = 500
+
+// PublishedItem records a published message of a feed: its key (the root
+// message of a thread), the number of messages it took and the blobs it
+// refers to. Type is the message type, "update" or "digest".
+type PublishedItem struct {
+	Type      string    `json:"type"`
+	Link      string    `json:"link,omitempty"`
+	Channel   string    `json:"channel,omitempty"`
+	Key       string    `json:"key"`
+	Published time.Time `json:"published"`
+	Messages  int       `json:"messages"`
+	Blobs     []string  `json:"blobs,omitempty"`
+}
+
+// recordItem records a published item, dropping the oldest one when there
+// are more than maxPublishedItems.
+func (f *FeedState) recordItem(item PublishedItem) {
+	f.Items = append(f.Items, item)
+	if len(f.Items) > maxPublishedItems {
+		f.Items = f.Items[len(f.Items)-maxPublishedItems:]
+	}
+}
+
+// ThreadProgress records the publication progress of a thread, so that a
+// partially published thread can be completed after a crash. Feed is the URL
+// of the feed whose identity publishes the thread.
+type ThreadProgress struct {
+	Feed     string                 `json:"feed,omitempty"`
+	Chunks   []string               `json:"chunks"`
+	Messages []string               `json:"messages,omitempty"`
+	Fields   map[string]interface{} `json:"fields,omitempty"`
+}
+
+// stateFile is the name of the state store file in the data directory.
+const stateFile = "state.json"
+
+// MessagesFromLog retrieves all messages from the user log.
+func MessagesFromLog(pub *sbot.Sbot) ([]Post, error) {
+	var posts []Post
+
+	src, err := pub.ReceiveLog.Query(margaret.SeqWrap(true))
+	if err != nil {
+		return posts, fmt.Errorf("MessagesFromLog: unable to query log: %w", err)
+	}
+
+	for {
+		var post Post
+
+		v, err := src.Next(context.Background())
+		if luigi.IsEOS(err) {
+			break
+		}
+
+		wrapped := v.(margaret.SeqWrapper)
+		message := wrapped.Value().(refs.Message)
+		if message.Author().Algo() == refs.RefAlgoFeedBendyButt {
+			// metafeeds only hold bencoded subfeed announcements
+			continue
+		}
+
+		content := message.ContentBytes()
+		if len(content) > 0 && content[0] == '"' {
+			// private messages, e.g. notifications, are boxed strings. Own
+			// posts to a private group or to recipients are decrypted, so
+			// that they're known as published
+			if !message.Author().Equal(pub.KeyPair.ID()) {
+				continue
+			}
+
+			plaintext, err := pub.Groups.DecryptMessage(message)
+			if err != nil {
+				continue
+			}
+			content = plaintext
+		}
+
+		if err = json.Unmarshal(content, &post); err != nil {
+			return posts, fmt.Errorf("MessagesFromLog: unable to unmarshal %s: %w", string(content), err)
+		}
+
+		post.Key = message.Key().String()
+		post.Author = message.Author().String()
+		post.Seq = wrapped.Seq()
+
+		posts = append(posts, post)
+	}
+
+	return posts, nil
+}
+
+// LoadState loads the state store from the data directory. A missing state
+// file results in an empty state. Queues of state files from before per-feed
+// queues are migrated to the queue of firstFeed.
+func LoadState(dataDir, firstFeed string) (*State, error) {
+	dataDir, err := filepath.Abs(dataDir)
+	if err != nil {
+		return nil, fmt.Errorf("LoadState: unable to convert %s to an absolute path: %w", dataDir, err)
+	}
+
+	state := &State{path: filepath.Join(dataDir, stateFile)}
+
+	contents, err := os.ReadFile(state.path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("LoadState: unable to read %s: %w", state.path, err)
+	}
+
+	if len(contents) > 0 {
+		if err := json.Unmarshal(contents, state); err != nil {
+			return nil, fmt.Errorf("LoadState: unable to unmarshal %s: %w", state.path, err)
+		}
+	}
+
+	if state.Threads == nil {
+		state.Threads = make(map[string]*ThreadProgress)
+	}
+
+	if state.Ignored == nil {
+		state.Ignored = make(map[string]bool)
+	}
+
+	if state.Feeds == nil {
+		state.Feeds = make(map[string]*FeedState)
+	}
+
+	if state.Hashes == nil {
+		state.Hashes = make(map[string]string)
+	}
+
+	if state.Followers == nil {
+		state.Followers = make(map[string]bool)
+	}
+
+	if state.Followed == nil {
+		state.Followed = make(map[string]bool)
+	}
+
+	if len(state.Queue) > 0 && firstFeed != "" {
+		feedState := state.Feed(firstFeed)
+		feedState.Queue = append(state.Queue, feedState.Queue...)
+		state.Queue = nil
+	}
+
+	return state, nil
+}
+
+// Feed returns the state of a feed, creating it when missing. Callers which
+// run concurrently must hold the lock.
+func (s *State) Feed(url string) *FeedState {
+	feedState, ok := s.Feeds[url]
+	if !ok {
+		feedState = &FeedState{}
+		s.Feeds[url] = feedState
+	}
+
+	return feedState
+}
+
+// NostrErrors returns the errors of the Nostr relays which the last broadcast
+// of a feed failed to reach, sorted.
+func (s *State) NostrErrors(url string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var errors []string
+	for _, err := range s.Feed(url).NostrErrors {
+		errors = append(errors, err)
+	}
+	sort.Strings(errors)
+
+	return errors
+}
+
+// swapHash records the content hash of a published item, returning the
+// previously recorded hash (if any).
+func (s *State) swapHash(link, hash string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	previous, known := s.Hashes[link]
+	s.Hashes[link] = hash
+
+	return previous, known
+}
+
+// Save atomically persists the state store to disk, unless it is read-only.
+func (s *State) Save() error {
+	if s.ReadOnly {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	contents, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Save: unable to marshal state: %w", err)
+	}
+
+	tmpPath := s.path + ".tmp"
+	if err := os.WriteFile(tmpPath, contents, 0600); err != nil {
+		return fmt.Errorf("Save: unable to write %s: %w", tmpPath, err)
+	}
+
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return fmt.Errorf("Save: unable to rename %s: %w", tmpPath, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,184 @@
+package publish
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"sort"
+	"text/template"
+	"time"
+
+	"github.com/ssbc/go-ssb/sbot"
+
+	"decentral1se/rss-butt-plug/feed"
+)
+
+// maxSummaryChannels is the number of channels listed in the summary post.
+const maxSummaryChannels = 5
+
+// SummaryCount is the number of items mirrored for a feed or to a channel.
+type SummaryCount struct {
+	Name  string
+	Items int
+}
+
+// SummaryData is the data available to the summary template. Items are the
+// mirrored items (posts, blogs and digests) of the month, Updates the
+// republished changes of earlier items. Feeds and Channels are sorted by
+// number of items.
+type SummaryData struct {
+	Month    string
+	Items    int
+	Updates  int
+	Feeds    []SummaryCount
+	Channels []SummaryCount
+	Invite   string
+	Operator string
+}
+
+// defaultSummaryTemplate is the default layout of the summary post, see
+// SummaryData for the available fields.
+const defaultSummaryTemplate = `# {{ .Month }} on this mirror
+
+{{ .Items }} item(s) were mirrored into the Scuttleverse{{ if .Updates }} and {{ .Updates }} update(s) of earlier items published{{ end }}.
+{{ if gt (len .Feeds) 1 }}
+{{ range .Feeds }}- {{ .Name }}: {{ .Items }}
+{{ end }}{{ end }}{{ if .Channels }}
+Top channels: {{ range $idx, $channel := .Channels }}{{ if $idx }}, {{ end }}#{{ $channel.Name }} ({{ $channel.Items }}){{ end }}
+{{ end }}{{ if .Invite }}
+Use this invite to connect to the pub and follow along: ` + "`{{ .Invite }}`" + `
+{{ end }}{{ if .Operator }}
+It is run by {{ .Operator }}, get in touch there for questions or problems.
+{{ end }}`
+
+// sortedCounts turns counts into a list sorted by number of items, then name.
+func sortedCounts(counts map[string]int) []SummaryCount {
+	sorted := []SummaryCount{}
+	for name, items := range counts {
+		sorted = append(sorted, SummaryCount{Name: name, Items: items})
+	}
+
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Items != sorted[j].Items {
+			return sorted[i].Items > sorted[j].Items
+		}
+		return sorted[i].Name < sorted[j].Name
+	})
+
+	return sorted
+}
+
+// summaryData counts the items of the public feeds which were published in
+// [since, until) from the state store.
+func summaryData(feedCfgs []feed.Config, since, until time.Time, state *State) SummaryData {
+	data := SummaryData{Month: since.Format("January 2006")}
+
+	feeds := make(map[string]int)
+	channels := make(map[string]int)
+	for _, feedCfg := range feedCfgs {
+		// the summary is public, private feeds stay private
+		if feedCfg.PrivateGroup.Name != "" || len(feedCfg.Recipients) > 0 {
+			continue
+		}
+
+		for _, item := range state.Feed(feedCfg.Feed).Items {
+			if item.Published.Before(since) || !item.Published.Before(until) {
+				continue
+			}
+
+			if item.Type == "update" {
+				data.Updates++
+				continue
+			}
+
+			data.Items++
+			feeds[feedCfg.Feed]++
+			if item.Channel != "" {
+				channels[item.Channel]++
+			}
+		}
+	}
+
+	data.Feeds = sortedCounts(feeds)
+	data.Channels = sortedCounts(channels)
+	if len(data.Channels) > maxSummaryChannels {
+		data.Channels = data.Channels[:maxSummaryChannels]
+	}
+
+	return data
+}
+
+// renderSummary renders the summary post with the configured (or default)
+// summary template.
+func renderSummary(cfg feed.Config, data SummaryData) (string, error) {
+	layout := cfg.SummaryTemplate
+	if layout == "" {
+		layout = defaultSummaryTemplate
+	}
+
+	tmpl, err := template.New("summary").Parse(layout)
+	if err != nil {
+		return "", fmt.Errorf("renderSummary: unable to parse template: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("renderSummary: unable to render template: %w", err)
+	}
+
+	return buf.String(), nil
+}
+
+// PublishSummary publishes a post which summarises the activity of the
+// previous month, once the month has turned. The first call only starts the
+// count. It's published by the main identity and mentions invite, if not
+// empty.
+func PublishSummary(pub *sbot.Sbot, cfg feed.Config, feedCfgs []feed.Config, invite string, state *State) error {
+	now := time.Now()
+	if state.LastSummary.IsZero() {
+		state.LastSummary = now
+		if err := state.Save(); err != nil {
+			return fmt.Errorf("PublishSummary: %w", err)
+		}
+		return nil
+	}
+
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	if !state.LastSummary.Before(monthStart) {
+		return nil
+	}
+
+	data := summaryData(feedCfgs, monthStart.AddDate(0, -1, 0), monthStart, state)
+	data.Invite = invite
+	if cfg.Operator != "" {
+		data.Operator = operatorLink(cfg.Operator)
+	}
+
+	text, err := renderSummary(cfg, data)
+	if err != nil {
+		return fmt.Errorf("PublishSummary: %w", err)
+	}
+
+	self, err := author(pub, mainPurpose, true)
+	if err != nil {
+		return fmt.Errorf("PublishSummary: %w", err)
+	}
+
+	publish, err := openPublisher(pub, self)
+	if err != nil {
+		return fmt.Errorf("PublishSummary: %w", err)
+	}
+
+	log.Printf("PublishSummary: publishing the summary of %s", data.Month)
+
+	if _, err := publish.Publish(map[string]interface{}{"type": "post", "text": text}); err != nil {
+		return fmt.Errorf("PublishSummary: failed to publish: %w", err)
+	}
+
+	state.LastSummary = now
+	if err := state.Save(); err != nil {
+		return fmt.Errorf("PublishSummary: %w", err)
+	}
+
+	return nil
+}
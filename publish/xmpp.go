@@ -0,0 +1,326 @@
+package publish
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"decentral1se/rss-butt-plug/feed"
+)
+
+// xmppTimeout is how long a XMPP notice may take, from connecting to the
+// server until closing the stream.
+const xmppTimeout = 30 * time.Second
+
+// xmppResource is the resource which is bound for sending notices.
+const xmppResource = "rss-butt-plug"
+
+// xmppFeatures are the stream features offered by the server.
+type xmppFeatures struct {
+	StartTLS   *struct{} `xml:"urn:ietf:params:xml:ns:xmpp-tls starttls"`
+	Mechanisms []string  `xml:"urn:ietf:params:xml:ns:xmpp-sasl mechanisms>mechanism"`
+	Bind       *struct{} `xml:"urn:ietf:params:xml:ns:xmpp-bind bind"`
+	Session    *struct {
+		Optional *struct{} `xml:"optional"`
+	} `xml:"urn:ietf:params:xml:ns:xmpp-session session"`
+}
+
+// xmppConn is a client stream to a XMPP server.
+type xmppConn struct {
+	conn    net.Conn
+	decoder *xml.Decoder
+	domain  string
+}
+
+// xmppServer returns the address of the XMPP server of a domain, according to
+// its SRV record, falling back to the domain itself.
+func xmppServer(domain string) string {
+	if _, addrs, err := net.LookupSRV("xmpp-client", "tcp", domain); err == nil && len(addrs) > 0 {
+		return net.JoinHostPort(strings.TrimSuffix(addrs[0].Target, "."), fmt.Sprint(addrs[0].Port))
+	}
+
+	return net.JoinHostPort(domain, "5222")
+}
+
+// send writes raw XML to the stream.
+func (c *xmppConn) send(format string, args ...interface{}) error {
+	if _, err := fmt.Fprintf(c.conn, format, args...); err != nil {
+		return fmt.Errorf("send: %w", err)
+	}
+
+	return nil
+}
+
+// next returns the next top-level element of the stream.
+func (c *xmppConn) next() (xml.StartElement, error) {
+	for {
+		token, err := c.decoder.Token()
+		if err != nil {
+			return xml.StartElement{}, fmt.Errorf("next: unable to read stream: %w", err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			if t.Name.Local == "stream" {
+				continue
+			}
+			return t, nil
+		case xml.EndElement:
+			if t.Name.Local == "stream" {
+				return xml.StartElement{}, fmt.Errorf("next: server closed the stream")
+			}
+		}
+	}
+}
+
+// open (re)starts the stream and returns the features offered by the server.
+func (c *xmppConn) open() (xmppFeatures, error) {
+	var features xmppFeatures
+
+	c.decoder = xml.NewDecoder(c.conn)
+	if err := c.send("<?xml version='1.0'?><stream:stream to='%s' xmlns='jabber:client' "+
+		"xmlns:stream='http://etherx.jabber.org/streams' version='1.0'>", xmlEscape(c.domain)); err != nil {
+		return features, fmt.Errorf("open: %w", err)
+	}
+
+	element, err := c.next()
+	if err != nil {
+		return features, fmt.Errorf("open: %w", err)
+	}
+
+	if element.Name.Local != "features" {
+		return features, fmt.Errorf("open: expected stream features, got %s", element.Name.Local)
+	}
+
+	if err := c.decoder.DecodeElement(&features, &element); err != nil {
+		return features, fmt.Errorf("open: unable to decode stream features: %w", err)
+	}
+
+	return features, nil
+}
+
+// expect reads the next element, which must be named name, and returns it
+// decoded into v (if not nil).
+func (c *xmppConn) expect(name string, v interface{}) error {
+	element, err := c.next()
+	if err != nil {
+		return fmt.Errorf("expect: %w", err)
+	}
+
+	if element.Name.Local != name {
+		c.decoder.Skip()
+		return fmt.Errorf("expect: expected %s, got %s", name, element.Name.Local)
+	}
+
+	if v == nil {
+		return c.decoder.Skip()
+	}
+
+	return c.decoder.DecodeElement(v, &element)
+}
+
+// iq sends an iq of type set and waits for its result.
+func (c *xmppConn) iq(id, payload string) error {
+	if err := c.send("<iq type='set' id='%s'>%s</iq>", id, payload); err != nil {
+		return fmt.Errorf("iq: %w", err)
+	}
+
+	var result struct {
+		Type string `xml:"type,attr"`
+	}
+	if err := c.expect("iq", &result); err != nil {
+		return fmt.Errorf("iq: %w", err)
+	}
+
+	if result.Type != "result" {
+		return fmt.Errorf("iq: %s failed with %s", id, result.Type)
+	}
+
+	return nil
+}
+
+// xmlEscape escapes text for XML character data and attribute values.
+func xmlEscape(text string) string {
+	var escaped strings.Builder
+	xml.EscapeText(&escaped, []byte(text))
+	return strings.ReplaceAll(escaped.String(), "'", "&#39;")
+}
+
+// xmppLogin connects to the server of the account, secures the stream with
+// STARTTLS, authenticates with SASL PLAIN and binds a resource. The server
+// certificate is verified with roots, or the system roots when nil.
+func xmppLogin(cfg feed.XMPPConfig, deadline time.Time, roots *x509.CertPool) (*xmppConn, error) {
+	local, domain, _ := strings.Cut(cfg.JID, "@")
+
+	server := cfg.Server
+	if server == "" {
+		server = xmppServer(domain)
+	}
+
+	conn, err := net.DialTimeout("tcp", server, xmppTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("xmppLogin: unable to connect to %s: %w", server, err)
+	}
+	conn.SetDeadline(deadline)
+
+	c := &xmppConn{conn: conn, domain: domain}
+
+	features, err := c.open()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("xmppLogin: %w", err)
+	}
+
+	if features.StartTLS == nil {
+		conn.Close()
+		return nil, fmt.Errorf("xmppLogin: %s doesn't offer STARTTLS", server)
+	}
+
+	if err := c.send("<starttls xmlns='urn:ietf:params:xml:ns:xmpp-tls'/>"); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("xmppLogin: %w", err)
+	}
+
+	if err := c.expect("proceed", nil); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("xmppLogin: STARTTLS: %w", err)
+	}
+
+	tlsConn := tls.Client(conn, &tls.Config{ServerName: domain, RootCAs: roots})
+	tlsConn.SetDeadline(deadline)
+	if err := tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("xmppLogin: TLS handshake with %s: %w", server, err)
+	}
+	c.conn = tlsConn
+
+	if features, err = c.open(); err != nil {
+		c.conn.Close()
+		return nil, fmt.Errorf("xmppLogin: %w", err)
+	}
+
+	plain := false
+	for _, mechanism := range features.Mechanisms {
+		plain = plain || mechanism == "PLAIN"
+	}
+	if !plain {
+		c.conn.Close()
+		return nil, fmt.Errorf("xmppLogin: %s doesn't offer SASL PLAIN", server)
+	}
+
+	credentials := base64.StdEncoding.EncodeToString([]byte("\x00" + local + "\x00" + cfg.Password))
+	if err := c.send("<auth xmlns='urn:ietf:params:xml:ns:xmpp-sasl' mechanism='PLAIN'>%s</auth>", credentials); err != nil {
+		c.conn.Close()
+		return nil, fmt.Errorf("xmppLogin: %w", err)
+	}
+
+	if err := c.expect("success", nil); err != nil {
+		c.conn.Close()
+		return nil, fmt.Errorf("xmppLogin: unable to authenticate as %s: %w", cfg.JID, err)
+	}
+
+	if features, err = c.open(); err != nil {
+		c.conn.Close()
+		return nil, fmt.Errorf("xmppLogin: %w", err)
+	}
+
+	if features.Bind == nil {
+		c.conn.Close()
+		return nil, fmt.Errorf("xmppLogin: %s doesn't offer resource binding", server)
+	}
+
+	if err := c.iq("bind", "<bind xmlns='urn:ietf:params:xml:ns:xmpp-bind'><resource>"+
+		xmppResource+"</resource></bind>"); err != nil {
+		c.conn.Close()
+		return nil, fmt.Errorf("xmppLogin: %w", err)
+	}
+
+	if features.Session != nil && features.Session.Optional == nil {
+		if err := c.iq("session", "<session xmlns='urn:ietf:params:xml:ns:xmpp-session'/>"); err != nil {
+			c.conn.Close()
+			return nil, fmt.Errorf("xmppLogin: %w", err)
+		}
+	}
+
+	return c, nil
+}
+
+// joinRoom enters a multi-user chat without its history and waits for the
+// presence of the account in it.
+func (c *xmppConn) joinRoom(room, nick string) error {
+	occupant := room + "/" + nick
+	if err := c.send("<presence to='%s'><x xmlns='http://jabber.org/protocol/muc'>"+
+		"<history maxchars='0'/></x></presence>", xmlEscape(occupant)); err != nil {
+		return fmt.Errorf("joinRoom: %w", err)
+	}
+
+	for {
+		element, err := c.next()
+		if err != nil {
+			return fmt.Errorf("joinRoom: %w", err)
+		}
+
+		var presence struct {
+			From string `xml:"from,attr"`
+			Type string `xml:"type,attr"`
+		}
+		if element.Name.Local != "presence" {
+			c.decoder.Skip()
+			continue
+		}
+
+		if err := c.decoder.DecodeElement(&presence, &element); err != nil {
+			return fmt.Errorf("joinRoom: %w", err)
+		}
+
+		if presence.From == occupant {
+			if presence.Type == "error" {
+				return fmt.Errorf("joinRoom: unable to join %s as %s", room, nick)
+			}
+			return nil
+		}
+	}
+}
+
+// sendXMPP sends a one-line notice to the contact or multi-user chat of a
+// XMPP account. It connects for every notice, which is fine at the rate items
+// are published.
+func sendXMPP(cfg feed.XMPPConfig, text string) error {
+	return sendXMPPWith(cfg, text, nil)
+}
+
+// sendXMPPWith is sendXMPP with the roots which the server certificate is
+// verified with.
+func sendXMPPWith(cfg feed.XMPPConfig, text string, roots *x509.CertPool) error {
+	c, err := xmppLogin(cfg, time.Now().Add(xmppTimeout), roots)
+	if err != nil {
+		return fmt.Errorf("sendXMPPWith: %w", err)
+	}
+	defer c.conn.Close()
+
+	to, kind := cfg.To, "chat"
+	if cfg.Room != "" {
+		if err := c.joinRoom(cfg.Room, cfg.Nick); err != nil {
+			return fmt.Errorf("sendXMPPWith: %w", err)
+		}
+		to, kind = cfg.Room, "groupchat"
+	}
+
+	text = strings.Join(strings.Fields(text), " ")
+	if err := c.send("<message to='%s' type='%s'><body>%s</body></message></stream:stream>",
+		xmlEscape(to), kind, xmlEscape(text)); err != nil {
+		return fmt.Errorf("sendXMPPWith: %w", err)
+	}
+
+	// wait for the server to close the stream, so that the message isn't lost
+	// to a reset connection
+	io.Copy(io.Discard, c.conn)
+
+	return nil
+}
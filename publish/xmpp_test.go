@@ -0,0 +1,190 @@
+package publish
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"decentral1se/rss-butt-plug/feed"
+)
+
+// fakeXMPPServer is a XMPP server which accepts a single client: it offers
+// STARTTLS, SASL PLAIN and resource binding and records the message sent.
+type fakeXMPPServer struct {
+	tls     *tls.Config
+	conn    net.Conn
+	decoder *xml.Decoder
+	auth    string
+	message struct{ To, Type, Body string }
+}
+
+// open reads the stream header of the client and answers with features.
+func (s *fakeXMPPServer) open(features string) error {
+	s.decoder = xml.NewDecoder(s.conn)
+	if _, err := s.next(); err != nil {
+		return err
+	}
+
+	_, err := fmt.Fprintf(s.conn, "<?xml version='1.0'?><stream:stream xmlns='jabber:client' "+
+		"xmlns:stream='http://etherx.jabber.org/streams' from='example.com' id='1' version='1.0'>"+
+		"<stream:features>%s</stream:features>", features)
+	return err
+}
+
+// next returns the next start element sent by the client.
+func (s *fakeXMPPServer) next() (xml.StartElement, error) {
+	for {
+		token, err := s.decoder.Token()
+		if err != nil {
+			return xml.StartElement{}, err
+		}
+
+		if element, ok := token.(xml.StartElement); ok {
+			return element, nil
+		}
+	}
+}
+
+// expect reads the next element of the client, which must be named name, into
+// v.
+func (s *fakeXMPPServer) expect(name string, v interface{}) error {
+	element, err := s.next()
+	if err != nil {
+		return err
+	}
+
+	if element.Name.Local != name {
+		return fmt.Errorf("expected %s, got %s", name, element.Name.Local)
+	}
+
+	return s.decoder.DecodeElement(v, &element)
+}
+
+// serve accepts a client and runs a stream with it.
+func (s *fakeXMPPServer) serve(listener net.Listener) error {
+	conn, err := listener.Accept()
+	if err != nil {
+		return err
+	}
+	s.conn = conn
+	defer conn.Close()
+
+	if err := s.open("<starttls xmlns='urn:ietf:params:xml:ns:xmpp-tls'><required/></starttls>"); err != nil {
+		return err
+	}
+
+	var starttls struct{}
+	if err := s.expect("starttls", &starttls); err != nil {
+		return err
+	}
+	fmt.Fprint(s.conn, "<proceed xmlns='urn:ietf:params:xml:ns:xmpp-tls'/>")
+
+	tlsConn := tls.Server(conn, s.tls)
+	if err := tlsConn.Handshake(); err != nil {
+		return err
+	}
+	s.conn = tlsConn
+
+	if err := s.open("<mechanisms xmlns='urn:ietf:params:xml:ns:xmpp-sasl'><mechanism>PLAIN</mechanism></mechanisms>"); err != nil {
+		return err
+	}
+
+	var auth struct {
+		Mechanism string `xml:"mechanism,attr"`
+		Data      string `xml:",chardata"`
+	}
+	if err := s.expect("auth", &auth); err != nil {
+		return err
+	}
+	credentials, _ := base64.StdEncoding.DecodeString(auth.Data)
+	s.auth = auth.Mechanism + ":" + string(credentials)
+	fmt.Fprint(s.conn, "<success xmlns='urn:ietf:params:xml:ns:xmpp-sasl'/>")
+
+	if err := s.open("<bind xmlns='urn:ietf:params:xml:ns:xmpp-bind'/>"); err != nil {
+		return err
+	}
+
+	var bind struct {
+		ID       string `xml:"id,attr"`
+		Resource string `xml:"bind>resource"`
+	}
+	if err := s.expect("iq", &bind); err != nil {
+		return err
+	}
+	fmt.Fprintf(s.conn, "<iq type='result' id='%s'><bind xmlns='urn:ietf:params:xml:ns:xmpp-bind'>"+
+		"<jid>bot@example.com/%s</jid></bind></iq>", bind.ID, bind.Resource)
+
+	var message struct {
+		To   string `xml:"to,attr"`
+		Type string `xml:"type,attr"`
+		Body string `xml:"body"`
+	}
+	if err := s.expect("message", &message); err != nil {
+		return err
+	}
+	s.message.To, s.message.Type, s.message.Body = message.To, message.Type, message.Body
+
+	// the client closes its stream after the message
+	for {
+		token, err := s.decoder.Token()
+		if err == io.EOF {
+			return fmt.Errorf("the client didn't close the stream")
+		} else if err != nil {
+			return err
+		}
+
+		if end, ok := token.(xml.EndElement); ok && end.Name.Local == "stream" {
+			fmt.Fprint(s.conn, "</stream:stream>")
+			return nil
+		}
+	}
+}
+
+func TestSendXMPP(t *testing.T) {
+	// httptest has a certificate for example.com
+	certServer := httptest.NewTLSServer(http.NotFoundHandler())
+	roots := certServer.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs
+	certificates := certServer.TLS.Certificates
+	certServer.Close()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	server := &fakeXMPPServer{tls: &tls.Config{Certificates: certificates}}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.serve(listener)
+	}()
+
+	cfg := feed.XMPPConfig{
+		JID:      "bot@example.com",
+		Password: "s3cret",
+		Server:   listener.Addr().String(),
+		To:       "me@example.com",
+	}
+	if err := sendXMPPWith(cfg, "New post <3\nhttps://example.org/post", roots); err != nil {
+		t.Fatalf("sendXMPPWith() error = %s", err)
+	}
+
+	if err := <-errs; err != nil {
+		t.Fatalf("fake server: %s", err)
+	}
+
+	if want := "PLAIN:\x00bot\x00s3cret"; server.auth != want {
+		t.Errorf("auth = %q, want %q", server.auth, want)
+	}
+
+	want := struct{ To, Type, Body string }{"me@example.com", "chat", "New post <3 https://example.org/post"}
+	if server.message != want {
+		t.Errorf("message = %+v, want %+v", server.message, want)
+	}
+}
@@ -2,57 +2,88 @@
 package main
 
 import (
-	"bytes"
+	"bufio"
 	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 	"syscall"
+	"text/tabwriter"
 	"time"
 
-	md "github.com/JohannesKaufmann/html-to-markdown"
-	"github.com/PuerkitoBio/goquery"
+	"github.com/BurntSushi/toml"
 	"github.com/mmcdole/gofeed"
-	"github.com/ssbc/go-luigi"
-	"github.com/ssbc/go-ssb"
-	refs "github.com/ssbc/go-ssb-refs"
-	ssbClient "github.com/ssbc/go-ssb/client"
-	"github.com/ssbc/go-ssb/message"
 	"github.com/ssbc/go-ssb/sbot"
 	"gopkg.in/yaml.v2"
+
+	"decentral1se/rss-butt-plug/feed"
+	"decentral1se/rss-butt-plug/pub"
+	"decentral1se/rss-butt-plug/publish"
 )
 
-// Config is a rss-butt-plug config file.
+// Config is a rss-butt-plug config file. The top-level feed options apply to
+// the single "feed" and act as defaults for the entries of "feeds".
 type Config struct {
-	DataDir string `yaml:"data-dir"`
-	Feed    string `yaml:"feed"`
-	Addr    string `yaml:"addr"`
-	Port    string `yaml:"port"`
-	WsPort  string `yaml:"ws-port"`
-	ShsCap  string `yaml:"shs-cap"`
-	Hops    uint   `yaml:"hops"`
-	Poll    int    `yaml:"poll"`
-	Avatar  string `yaml:"avatar,omitempty"`
-}
+	Sbot pub.Config `yaml:",inline"`
 
-// Post is a ssb post message.
-type Post struct {
-	Type string `json:"type"`
-	Link string `json:"link"`
-	Text string `json:"text"`
-	Root string `json:"root,omitempty"`
+	Avatar string `yaml:"avatar,omitempty"`
+
+	FollowBack      bool     `yaml:"follow-back,omitempty"`
+	FollowBackAllow []string `yaml:"follow-back-allow,omitempty"`
+
+	Notify     publish.NotifyConfig     `yaml:"notify,omitempty"`
+	Alert      publish.AlertConfig      `yaml:"alert,omitempty"`
+	Moderation publish.ModerationConfig `yaml:"moderation,omitempty"`
+
+	Concurrency int    `yaml:"concurrency,omitempty"`
+	FeedAddr    string `yaml:"feed-addr,omitempty"`
+	AdminAddr   string `yaml:"admin-addr,omitempty"`
+
+	AdminToken     string `yaml:"admin-token,omitempty"`
+	AdminTokenEnv  string `yaml:"admin-token-env,omitempty"`
+	AdminTokenFile string `yaml:"admin-token-file,omitempty"`
+
+	feed.Config `yaml:",inline"`
+
+	RawFeeds []yaml.MapSlice `yaml:"feeds,omitempty"`
+	Feeds    []feed.Config   `yaml:"-"`
 }
 
 // help is the rss-butt-plug CLI help output.
 const help = `rss-butt-plug [options] [<feed>]
+rss-butt-plug [options] init [-feed <url>] [-data-dir <dir>] [-check] [-force]
+rss-butt-plug [options] invite create [-uses N] [-note "..."]
+rss-butt-plug [options] invite list
+rss-butt-plug [options] invite revoke <id>
+rss-butt-plug [options] status [-json]
+rss-butt-plug [options] list [-feed <feed>] [-json]
+rss-butt-plug [options] pending [-json]
+rss-butt-plug [options] approve <id>...
+rss-butt-plug [options] reject <id>...
+rss-butt-plug [options] health [-factor N]
+rss-butt-plug [options] blobs gc [-dry-run]
+rss-butt-plug [options] repo check [-repair]
+rss-butt-plug [options] repo compact
+rss-butt-plug [options] key export [<file>]
+rss-butt-plug [options] key import [-force] <file>
+rss-butt-plug [options] republish <item-link-or-guid>
+rss-butt-plug [options] feed reset [-blobs] <feed>
+rss-butt-plug [options] feed resume <feed>
+rss-butt-plug [options] export <dir>
 
 A SSB client which "plugs" a RSS feed into the Scuttleverse.
 
@@ -68,137 +99,419 @@ shs-cap: "1KHLiKZvAvjbY1ziZEHMXawbCEIM6qwjCDm3VYRan/s="
 hops: 1
 poll: 5
 avatar: https://images.opencollective.com/secure-scuttlebutt-consortium/676f245/logo/256.png
+max-blob-size: 5242880
 
 Arguments:
-  <feed>    a feed to test parsing
+  <feed>    a feed to preview, the first item unless -item or -all is given
+
+Commands:
+  init      write a new config file (-c), generate the keypair in the
+            data-dir and print the identity, -check fetches and converts the
+            first item of the feed first; asks for the feed on a terminal
+  invite    manage the invites of the running pub through its admin API
+            (admin-addr must be configured)
+  status    print the health of the configured feeds from the state store:
+            last successful poll, last published item, queued messages,
+            consecutive failures and the messages and blobs published
+  list      print the latest published items of the configured feeds (or
+            -feed) from the state store, with their message keys, number of
+            thread messages and blobs
+  pending   print the posts of moderated feeds which wait for approval from
+            the state store
+  approve   publish pending posts, through the admin API of the running pub
+            if admin-addr is configured (else stop rss-butt-plug first)
+  reject    drop pending posts, like approve
+  health    exit 0 only if a feed was polled successfully within -factor
+            (3) times its poll interval and the go-sbot answers on its UNIX
+            socket, e.g. for Docker HEALTHCHECK or Kubernetes probes
+  blobs     delete the blobs which no published or queued message refers to
+            (stop rss-butt-plug first), -dry-run only reports them
+  repo      check the consistency of the log and indexes (-repair nulls the
+            messages of broken feeds) or drop and rebuild the indexes
+            (stop rss-butt-plug first)
+  key       export the keypair of the pub identity or import an existing
+            SSB secret file as the pub identity (stop rss-butt-plug first)
+  republish convert an item again and publish it as a new post referencing
+            the earlier one, e.g. after a conversion bug mangled it (stop
+            rss-butt-plug first)
+  feed      reset the state of a configured feed so that its items are
+            published again, -blobs also deletes the unreferenced blobs, or
+            resume a feed which was paused because it was gone (stop
+            rss-butt-plug first)
+  export    write the published posts and their blobs to <dir> as a static
+            site and a JSON archive (stop rss-butt-plug first)
 
 Options:
-  -h    output help
-  -c    path to config file
+  -h       output help
+  -c       path to config file (YAML, or TOML / JSON by extension)
+  -once    poll all feeds once and exit (exit code 2 if a feed failed)
+  -dry-run print the messages which would be published and exit
+  -item    the item (1 is the first) of <feed> to preview
+  -all     preview all items of <feed>
 `
 
-// maxPostLength is a post limit set by rss-butt-plug which is smaller than the
-// actual max post length of 8192, to allow some buffer. Any RSS post with a
-// greater length will be split up into threads.
-const maxPostLength = 7000
+// defaultConcurrency is the default number of feeds which are fetched and
+// converted at the same time.
+const defaultConcurrency = 4
+
+// publicInviteUses is the number of uses of the public invite, which is minted
+// at the first start and reused after. It's high so as to make the invite
+// usable to more people, it's more a public share invite in that sense.
+const publicInviteUses = 666
+
+// defaultAlertFailures is the default number of consecutive failed polls of a
+// feed which raise an alert.
+const defaultAlertFailures = 3
+
+// alertImageMisses is the number of consecutive "404 Not Found" responses for
+// an image which raise an alert.
+const alertImageMisses = 3
+
+// defaultHealthFactor is the default number of poll intervals within which a
+// successful poll must have happened for the health subcommand.
+const defaultHealthFactor = 3
+
+// healthTimeout is how long the go-sbot gets to answer the health subcommand.
+const healthTimeout = 5 * time.Second
+
+// pausedDue is the due time of paused feeds, they're only polled on request
+// through the admin API.
+var pausedDue = time.Now().AddDate(100, 0, 0)
+
+// imageCacheDir is the directory in the data directory which caches the
+// downloaded images.
+const imageCacheDir = "image-cache"
 
 var helpFlag bool
+var onceFlag bool
+var dryRunFlag bool
+var itemFlag int
+var allFlag bool
 var debugFlag bool
 var configFlag string
 
 // handleCliFlags parses CLI flags.
 func handleCliFlags() error {
 	flag.BoolVar(&helpFlag, "h", false, "output help")
+	flag.BoolVar(&onceFlag, "once", false, "poll all feeds once and exit")
+	flag.BoolVar(&dryRunFlag, "dry-run", false, "print the messages which would be published and exit")
+	flag.IntVar(&itemFlag, "item", 1, "the item of <feed> to preview")
+	flag.BoolVar(&allFlag, "all", false, "preview all items of <feed>")
 	flag.StringVar(&configFlag, "c", "rss-butt-plug.yaml", "config file")
 	flag.Parse()
 
 	return nil
 }
 
-// parseRSSFeed parses an entire RSS feed into memory.
-func parseRSSFeed(url string) (gofeed.Feed, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
-	defer cancel()
+// ANSI escape sequences used by the terminal preview.
+const (
+	ansiReset     = "\x1b[0m"
+	ansiBold      = "\x1b[1m"
+	ansiDim       = "\x1b[2m"
+	ansiItalic    = "\x1b[3m"
+	ansiUnderline = "\x1b[4m"
+	ansiReverse   = "\x1b[7m"
+	ansiCyan      = "\x1b[36m"
+)
 
-	feedParser := gofeed.NewParser()
-	feed, err := feedParser.ParseURLWithContext(url, ctx)
-	if err != nil {
-		return gofeed.Feed{}, fmt.Errorf("unable to parse %s: %w", url, err)
+var (
+	termHeadingRegex = regexp.MustCompile(`^#{1,6}\s+(.*)$`)
+	termQuoteRegex   = regexp.MustCompile(`^>\s?(.*)$`)
+	termListRegex    = regexp.MustCompile(`^(\s*)[-*+]\s+(.*)$`)
+	termRuleRegex    = regexp.MustCompile(`^\s*([-*_]\s*){3,}$`)
+	termImageRegex   = regexp.MustCompile(`!\[([^\]]*)\]\(([^)\s]+)[^)]*\)`)
+	termLinkRegex    = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)[^)]*\)`)
+	termBoldRegex    = regexp.MustCompile(`\*\*([^*]+)\*\*`)
+	termItalicRegex  = regexp.MustCompile(`\*([^*\s][^*]*)\*`)
+	termCodeRegex    = regexp.MustCompile("`([^`]+)`")
+)
+
+// isTerminal reports whether f is a terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// terminalInline renders the inline markdown of a line with ANSI styles.
+func terminalInline(line string) string {
+	line = termImageRegex.ReplaceAllString(line, ansiCyan+"[image: $1]"+ansiReset)
+	line = termLinkRegex.ReplaceAllString(line, ansiUnderline+"$1"+ansiReset+ansiDim+" ($2)"+ansiReset)
+	line = termCodeRegex.ReplaceAllString(line, ansiReverse+"$1"+ansiReset)
+	line = termBoldRegex.ReplaceAllString(line, ansiBold+"$1"+ansiReset)
+	line = termItalicRegex.ReplaceAllString(line, ansiItalic+"$1"+ansiReset)
+	return line
+}
+
+// terminalMarkdown renders markdown for reading in a terminal: headings are
+// bold, links and images are spelled out and code blocks are dimmed.
+func terminalMarkdown(markdown string) string {
+	var rendered []string
+
+	inFence := false
+	for _, line := range strings.Split(markdown, "\n") {
+		if publish.IsFence(line) {
+			inFence = !inFence
+			rendered = append(rendered, ansiDim+line+ansiReset)
+			continue
+		}
+
+		if inFence {
+			rendered = append(rendered, ansiDim+line+ansiReset)
+			continue
+		}
+
+		if match := termHeadingRegex.FindStringSubmatch(line); match != nil {
+			rendered = append(rendered, ansiBold+ansiUnderline+match[1]+ansiReset)
+		} else if termRuleRegex.MatchString(line) {
+			rendered = append(rendered, ansiDim+strings.Repeat("─", 40)+ansiReset)
+		} else if match := termQuoteRegex.FindStringSubmatch(line); match != nil {
+			rendered = append(rendered, ansiDim+"│ "+ansiReset+terminalInline(match[1]))
+		} else if match := termListRegex.FindStringSubmatch(line); match != nil {
+			rendered = append(rendered, match[1]+"• "+terminalInline(match[2]))
+		} else {
+			rendered = append(rendered, terminalInline(line))
+		}
 	}
 
-	return *feed, nil
+	return strings.Join(rendered, "\n")
 }
 
-// getImage retrieves an image from the internet.
-func getImage(url string) (io.Reader, error) {
-	response, err := http.Get(url)
+// previewFeed converts items of a feed like a poll does and prints them,
+// together with their length and how they would be published. Only the item
+// at index (1 is the first) is converted unless all is set. Image blobs are
+// hashed but not stored.
+func previewFeed(w io.Writer, testFeed string, cfg feed.Config, index int, all bool) error {
+	parsed, err := feed.Parse(context.Background(), testFeed, cfg)
 	if err != nil {
-		return nil, fmt.Errorf("getImage: unable to retrieve %s: %w", url, err)
+		return fmt.Errorf("previewFeed: %w", err)
 	}
-	defer response.Body.Close()
 
-	if response.StatusCode != 200 {
-		return nil, fmt.Errorf("getImage: unable to retrieve %s: HTTP %d", url, response.StatusCode)
+	items := parsed.Items
+	if !all {
+		if index < 1 || index > len(items) {
+			return fmt.Errorf("previewFeed: %s has %d item(s), no item %d", testFeed, len(items), index)
+		}
+		items = items[index-1 : index]
 	}
 
-	body, error := ioutil.ReadAll(response.Body)
-	if error != nil {
-		return nil, fmt.Errorf("getImage: unable to read response body: %w", err)
+	terminal := isTerminal(os.Stdout) && os.Getenv("NO_COLOR") == ""
+
+	for idx, item := range items {
+		number := index + idx
+		if all {
+			number = idx + 1
+		}
+
+		link := feed.StripTrackingParams(item.Link, cfg.TrackingParams)
+		message, err := feed.ItemMessage(context.Background(), item, parsed.Link, link, feed.HashBlobs{}, cfg)
+		if err != nil {
+			return fmt.Errorf("previewFeed: %w", err)
+		}
+
+		text, _ := message["text"].(string)
+		if message["type"] == "blog" {
+			text, _ = message["summary"].(string)
+		}
+
+		if terminal {
+			text = terminalMarkdown(text)
+		}
+
+		fmt.Fprintf(w, "==> [%d/%d] %s\n\n%s\n\n", number, len(parsed.Items), item.Title, text)
+
+		if message["type"] == "blog" {
+			fmt.Fprintf(w, "--> published as a blog message, body stored as blob %s\n\n", message["blog"])
+			continue
+		}
+
+		content := message["text"].(string)
+		if limit := feed.PostLimit(cfg); len(content) > limit {
+			fmt.Fprintf(w, "--> %d characters, published as a thread of %d messages\n\n", len(content), len(publish.ThreadChunks(content, limit)))
+			continue
+		}
+
+		fmt.Fprintf(w, "--> %d characters, published as a single message\n\n", len(content))
 	}
 
-	return bytes.NewReader(body), nil
+	return nil
 }
 
-// htmlToMarkdown converts HTML to Markdown. Image links are processed into
-// blob refs for SSB client readers.
-func htmlToMarkdown(content string, pub *sbot.Sbot, postBlobs bool) (string, error) {
-	var markdown string
+// envPrefix is the prefix of environment variables which override config
+// options, e.g. RSS_BUTT_PLUG_DATA_DIR for data-dir. Nested options are
+// separated by a double underscore, e.g. RSS_BUTT_PLUG_TOR__CONTROL_ADDR.
+const envPrefix = "RSS_BUTT_PLUG_"
+
+// envVarRegex matches ${VAR} references in the config file.
+var envVarRegex = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// envOverrides returns the config overrides from the environment, keyed by
+// the path of the option.
+func envOverrides() map[string]string {
+	overrides := make(map[string]string)
+	for _, env := range os.Environ() {
+		name, value, _ := strings.Cut(env, "=")
+		if !strings.HasPrefix(name, envPrefix) || name == envPrefix {
+			continue
+		}
 
-	converter := md.NewConverter("", true, nil)
+		key := strings.ToLower(strings.TrimPrefix(name, envPrefix))
+		key = strings.ReplaceAll(key, "__", ".")
+		key = strings.ReplaceAll(key, "_", "-")
+		overrides[key] = value
+	}
 
-	converter.AddRules(
-		md.Rule{
-			Filter: []string{"img"},
-			Replacement: func(content string, selec *goquery.Selection, opt *md.Options) *string {
-				if postBlobs {
-					src, _ := selec.Attr("src")
-					srcReader, err := getImage(src)
-					if err != nil {
-						log.Fatal(fmt.Errorf("htmlToMarkdown: %w", err))
-					}
+	return overrides
+}
 
-					ref, err := pub.BlobStore.Put(srcReader)
-					if err != nil {
-						log.Fatal(fmt.Errorf("htmlToMarkdown: %w", err))
-					}
+// setOption sets the option at path (keys separated by dots) in doc.
+func setOption(doc yaml.MapSlice, path []string, value interface{}) yaml.MapSlice {
+	for idx := range doc {
+		if doc[idx].Key != path[0] {
+			continue
+		}
 
-					log.Printf("htmlToMarkdown: successfully posted %s as blob", src)
+		if len(path) == 1 {
+			doc[idx].Value = value
+			return doc
+		}
+
+		nested, _ := doc[idx].Value.(yaml.MapSlice)
+		doc[idx].Value = setOption(nested, path[1:], value)
+		return doc
+	}
+
+	if len(path) == 1 {
+		return append(doc, yaml.MapItem{Key: path[0], Value: value})
+	}
+
+	return append(doc, yaml.MapItem{Key: path[0], Value: setOption(nil, path[1:], value)})
+}
+
+// optionType returns the type of the config option at path (keys separated
+// by dots) in t, or nil when there's no such option.
+func optionType(t reflect.Type, path []string) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Map:
+		if len(path) == 1 {
+			return t.Elem()
+		}
+		return optionType(t.Elem(), path[1:])
+	case reflect.Struct:
+		for idx := 0; idx < t.NumField(); idx++ {
+			field := t.Field(idx)
+			name, opts, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+			if name == "-" || !field.IsExported() {
+				continue
+			}
 
-					return md.String("![](" + ref.String() + ")")
+			if strings.Contains(opts, "inline") {
+				if found := optionType(field.Type, path); found != nil {
+					return found
 				}
+				continue
+			}
+
+			if name == "" {
+				name = strings.ToLower(field.Name)
+			}
 
-				return nil
-			},
-		},
-	)
+			if name != path[0] {
+				continue
+			}
 
-	markdown, err := converter.ConvertString(content)
-	if err != nil {
-		return markdown, fmt.Errorf("htmlToMarkdown: unable to convert html to markdown: %w", err)
+			if len(path) == 1 {
+				return field.Type
+			}
+			return optionType(field.Type, path[1:])
+		}
 	}
 
-	return markdown, nil
+	return nil
 }
 
-// firstRSSPost retrieves the post content of the first message of a RSS feed.
-func firstRSSPost(testFeed string, pub *sbot.Sbot) (string, error) {
-	var markdown string
+// applyEnv expands ${VAR} references in the config file and applies the
+// overrides from the environment.
+func applyEnv(conf []byte) ([]byte, error) {
+	var missing []string
+	conf = envVarRegex.ReplaceAllFunc(conf, func(ref []byte) []byte {
+		name := string(ref[2 : len(ref)-1])
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			missing = append(missing, name)
+		}
+		return []byte(value)
+	})
+
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("applyEnv: environment variable %s is not set", strings.Join(missing, ", "))
+	}
 
-	feed, err := parseRSSFeed(testFeed)
-	if err != nil {
-		return markdown, fmt.Errorf("firstRSSPost: %w", err)
+	overrides := envOverrides()
+	if len(overrides) == 0 {
+		return conf, nil
+	}
+
+	var doc yaml.MapSlice
+	if err := yaml.Unmarshal(conf, &doc); err != nil {
+		return nil, fmt.Errorf("applyEnv: unable to unmarshal config: %w", err)
 	}
 
-	for _, feed := range feed.Items {
-		content := feed.Content
-		if feed.Content == "" {
-			content = feed.Description
+	for key, raw := range overrides {
+		path := strings.Split(key, ".")
+
+		// values of other than string options are YAML, so that numbers,
+		// booleans and lists work too. Strings are taken as they are, a
+		// password like 0123 must not turn into a number
+		var value interface{} = raw
+		if target := optionType(reflect.TypeOf(Config{}), path); target == nil || target.Kind() != reflect.String {
+			if err := yaml.Unmarshal([]byte(raw), &value); err != nil || value == nil {
+				value = raw
+			}
 		}
 
-		log.Printf("firstRSSPost: converting '%s' to markdown", feed.Title)
+		doc = setOption(doc, path, value)
+	}
+
+	conf, err := yaml.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("applyEnv: unable to marshal config: %w", err)
+	}
 
-		markdown, err = htmlToMarkdown(content, pub, false)
-		if err != nil {
-			return markdown, fmt.Errorf("firstRSSPost: %w", err)
+	return conf, nil
+}
+
+// toYAML converts a TOML or JSON config file, detected by the extension of
+// path, to YAML so that all formats share the same options and checks.
+func toYAML(path string, conf []byte) ([]byte, error) {
+	var doc map[string]interface{}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".toml":
+		if err := toml.Unmarshal(conf, &doc); err != nil {
+			return nil, fmt.Errorf("toYAML: unable to unmarshal %s: %w", path, err)
+		}
+	case ".json":
+		if err := json.Unmarshal(conf, &doc); err != nil {
+			return nil, fmt.Errorf("toYAML: unable to unmarshal %s: %w", path, err)
 		}
+	default:
+		return conf, nil
+	}
 
-		return markdown, err
+	conf, err := yaml.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("toYAML: unable to marshal %s: %w", path, err)
 	}
 
-	return markdown, fmt.Errorf("firstRSSPost: %w", err)
+	return conf, nil
 }
 
-// loadYAMLConfig loads a rss-butt-plug YAML user config.
+// loadYAMLConfig loads a rss-butt-plug YAML, TOML or JSON user config.
+// Without a config file, the config is taken from the environment alone.
 func loadYAMLConfig() (Config, error) {
 	var cfg Config
 
@@ -208,414 +521,1990 @@ func loadYAMLConfig() (Config, error) {
 	}
 
 	conf, err := os.ReadFile(configPath)
-	if err != nil {
+	if err != nil && !(os.IsNotExist(err) && len(envOverrides()) > 0) {
 		return Config{}, fmt.Errorf("loadYAMLConfig: unable to read %s: %w", configFlag, err)
 	}
 
-	err = yaml.UnmarshalStrict(conf, &cfg)
-	if err != nil {
-		return Config{}, fmt.Errorf("loadYAMLConfig: unable to unmarshal %s: %w", string(conf), err)
+	if len(conf) > 0 {
+		conf, err = toYAML(configPath, conf)
+		if err != nil {
+			return Config{}, fmt.Errorf("loadYAMLConfig: %w", err)
+		}
 	}
 
-	return cfg, nil
-}
-
-// generatePublicInvite generates an invite by speaking to a local go-sbot instance. It
-// uses a high "uses" value (666) so as to make the invite usable to more
-// people. It's more a public share invite in that sense.
-func generatePublicInvite(pub *sbot.Sbot) (string, error) {
-	var token string
-
-	client, err := ssbClient.NewTCP(pub.KeyPair, pub.Network.GetListenAddr())
+	conf, err = applyEnv(conf)
 	if err != nil {
-		return token, fmt.Errorf("generatePublicInvite: unable to initalise TCP client: %w", err)
+		return Config{}, fmt.Errorf("loadYAMLConfig: %w", err)
 	}
 
-	token, err = client.InviteCreate(message.InviteCreateArgs{Uses: 666})
+	err = yaml.UnmarshalStrict(conf, &cfg)
 	if err != nil {
-		return token, fmt.Errorf("generatePublicInvite: unable to create invite: %w", err)
+		return Config{}, fmt.Errorf("loadYAMLConfig: unable to unmarshal %s: %w", string(conf), err)
 	}
 
-	if err := client.Close(); err != nil {
-		return token, fmt.Errorf("generatePublicInvite: unable to close TCP client: %w", err)
+	if err := resolveFeeds(&cfg); err != nil {
+		return Config{}, fmt.Errorf("loadYAMLConfig: %w", err)
 	}
 
-	if strings.Contains(token, "[::]") {
-		token = strings.Replace(token, "[::]", "localhost", 1)
+	if err := publish.ValidateNotify(cfg.Notify); err != nil {
+		return Config{}, fmt.Errorf("loadYAMLConfig: %w", err)
 	}
 
-	return token, nil
-}
+	for _, feedCfg := range cfg.Feeds {
+		if cfg.Sbot.MetaFeeds && feedCfg.PrivateGroup.Name != "" {
+			return Config{}, fmt.Errorf("loadYAMLConfig: %s: private-group can't be used with metafeeds", feedCfg.Feed)
+		}
 
-// messagesFromLog retrieves all messages from the user log.
-func messagesFromLog(pub *sbot.Sbot) ([]Post, error) {
-	var posts []Post
+		if cfg.Sbot.MetaFeeds && len(feedCfg.Recipients) > 0 {
+			return Config{}, fmt.Errorf("loadYAMLConfig: %s: recipients can't be used with metafeeds", feedCfg.Feed)
+		}
+	}
 
-	src, err := pub.ReceiveLog.Query()
-	if err != nil {
-		return posts, fmt.Errorf("messagesFromLog: unable to query log: %w", err)
+	if err := publish.ValidateAlert(&cfg.Alert); err != nil {
+		return Config{}, fmt.Errorf("loadYAMLConfig: %w", err)
 	}
 
-	for {
-		var post Post
+	if err := publish.ValidateModeration(cfg.Moderation); err != nil {
+		return Config{}, fmt.Errorf("loadYAMLConfig: %w", err)
+	}
 
-		v, err := src.Next(context.Background())
-		if luigi.IsEOS(err) {
-			break
-		}
+	if err := resolveAdmin(&cfg); err != nil {
+		return Config{}, fmt.Errorf("loadYAMLConfig: %w", err)
+	}
 
-		message := v.(refs.Message)
-		content := message.ContentBytes()
-		if err = json.Unmarshal(content, &post); err != nil {
-			return posts, fmt.Errorf("messagesFromLog: unable to unmarshal %s: %w", string(content), err)
-		}
+	if cfg.Alert.Failures <= 0 {
+		cfg.Alert.Failures = defaultAlertFailures
+	}
 
-		posts = append(posts, post)
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = defaultConcurrency
 	}
 
-	return posts, nil
+	return cfg, nil
 }
 
-// newSbot instantiates a new go-sbot instance.
-func newSbot(cfg Config) (*sbot.Sbot, error) {
-	dataDir, err := filepath.Abs(cfg.DataDir)
-	if err != nil {
-		return nil, fmt.Errorf("newSbot: unable to convert %s to an absolute path: %w", cfg.DataDir, err)
-	}
-
-	sbotOpts := []sbot.Option{
-		sbot.EnableAdvertismentBroadcasts(true),
-		sbot.EnableAdvertismentDialing(true),
-		sbot.LateOption(sbot.WithUNIXSocket()),
-		sbot.WithHops(cfg.Hops),
-		sbot.WithListenAddr(fmt.Sprintf(":%s", cfg.Port)),
-		sbot.WithRepoPath(dataDir),
-		sbot.WithWebsocketAddress(fmt.Sprintf(":%s", cfg.WsPort)),
+// resolveFeeds builds the list of configured feeds. Each entry of "feeds"
+// starts from the top-level feed options and overrides them. Without a
+// "feeds" list, the top-level "feed" is the only feed.
+func resolveFeeds(cfg *Config) error {
+	if len(cfg.RawFeeds) == 0 {
+		cfg.Feeds = []feed.Config{cfg.Config}
 	}
 
-	pub, err := sbot.New(sbotOpts...)
+	defaults, err := yaml.Marshal(cfg.Config)
 	if err != nil {
-		return nil, fmt.Errorf("newSbot: unable to initialise sbot: %w", err)
+		return fmt.Errorf("resolveFeeds: unable to marshal feed defaults: %w", err)
 	}
 
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
-	go func() {
-		<-c
-
-		pub.Shutdown()
-		time.Sleep(2 * time.Second)
+	for idx, raw := range cfg.RawFeeds {
+		var feedCfg feed.Config
+		if err := yaml.Unmarshal(defaults, &feedCfg); err != nil {
+			return fmt.Errorf("resolveFeeds: unable to unmarshal feed defaults: %w", err)
+		}
 
-		if err := pub.Close(); err != nil {
-			log.Fatal(fmt.Errorf("newSbot: %w", err))
+		entry, err := yaml.Marshal(raw)
+		if err != nil {
+			return fmt.Errorf("resolveFeeds: unable to marshal feed %d: %w", idx+1, err)
 		}
 
-		time.Sleep(2 * time.Second)
-		os.Exit(0)
-	}()
+		if err := yaml.UnmarshalStrict(entry, &feedCfg); err != nil {
+			return fmt.Errorf("resolveFeeds: unable to unmarshal feed %d: %w", idx+1, err)
+		}
 
-	return pub, nil
-}
+		cfg.Feeds = append(cfg.Feeds, feedCfg)
+	}
 
-// serveSbot serves a go-sbot over the network.
-func serveSbot(pub *sbot.Sbot) {
-	for {
-		ctx := context.TODO()
-		err := pub.Network.Serve(ctx)
-		if err != nil {
-			log.Fatal(fmt.Errorf("serveSbot: %w", err))
+	for idx := range cfg.Feeds {
+		if err := feed.ValidateConfig(&cfg.Feeds[idx]); err != nil {
+			return fmt.Errorf("resolveFeeds: %w", err)
 		}
 
-		time.Sleep(1 * time.Second)
-
-		select {
-		case <-ctx.Done():
-			err := pub.Close()
-			if err != nil {
-				log.Fatal(fmt.Errorf("serveSbot: %w", err))
-			}
-		default:
+		if err := publish.ValidateNostr(cfg.Feeds[idx].Nostr); err != nil {
+			return fmt.Errorf("resolveFeeds: %s: %w", cfg.Feeds[idx].Feed, err)
 		}
 	}
+
+	cfg.Config = cfg.Feeds[0]
+
+	return nil
 }
 
-// getNewRSSPosts gathers new posts from a RSS feed.
-func getNewRSSPosts(feed gofeed.Feed, posts []Post, pub *sbot.Sbot) ([]map[string]interface{}, error) {
-	var messages []map[string]interface{}
+// printMessages prints the messages which would be published for a feed,
+// with posts which are too long shown as the thread they would become.
+func printMessages(w io.Writer, cfg feed.Config, messages []map[string]interface{}) error {
+	fmt.Fprintf(w, "==> %s: %d message(s)\n", cfg.Feed, len(messages))
 
-	for idx := len(feed.Items) - 1; idx >= 0; idx-- {
-		feed := feed.Items[idx]
-		alreadyPosted := false
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
 
-		for _, post := range posts {
-			if feed.Link == post.Link {
-				alreadyPosted = true
-				break
+	for _, message := range messages {
+		text, ok := message["text"].(string)
+		if message["type"] != "post" || !ok || len(text) <= feed.PostLimit(cfg) {
+			if err := encoder.Encode(message); err != nil {
+				return fmt.Errorf("printMessages: unable to encode message: %w", err)
 			}
-		}
-
-		if alreadyPosted {
-			log.Printf("getNewRSSPosts: skipping %s, already posted", feed.Link)
 			continue
 		}
 
-		feedContent := feed.Content
-		if feed.Content == "" {
-			feedContent = feed.Description
-		}
-
-		log.Printf("getNewRSSPosts: converting '%s' to markdown", feed.Title)
-
-		markdown, err := htmlToMarkdown(feedContent, pub, true)
-		if err != nil {
-			return messages, fmt.Errorf("getNewRSSPosts: %w", err)
-		}
-
-		content := fmt.Sprintf("# %s\n", feed.Title)
+		chunks := publish.ThreadChunks(text, feed.PostLimit(cfg))
+		fmt.Fprintf(w, "--> %s: thread of %d messages\n", message["link"], len(chunks))
 
-		if feed.Image != nil {
-			srcReader, err := getImage(feed.Image.URL)
-			if err != nil {
-				return messages, fmt.Errorf("getNewRSSPosts: %w", err)
+		for idx, chunk := range chunks {
+			threadMessage := map[string]interface{}{
+				"type": "post",
+				"link": message["link"],
+				"text": chunk,
 			}
 
-			ref, err := pub.BlobStore.Put(srcReader)
-			if err != nil {
-				return messages, fmt.Errorf("getNewRSSPosts: unable to upload blob: %w", err)
+			if idx == 0 {
+				for key, value := range message {
+					if key != "text" {
+						threadMessage[key] = value
+					}
+				}
+			} else {
+				threadMessage["root"] = "%<part 1>"
+				threadMessage["branch"] = fmt.Sprintf("%%<part %d>", idx)
+				if warning, ok := message["contentWarning"]; ok {
+					threadMessage["contentWarning"] = warning
+				}
 			}
 
-			content += "\n![](" + ref.String() + ")\n"
+			if err := encoder.Encode(threadMessage); err != nil {
+				return fmt.Errorf("printMessages: unable to encode message: %w", err)
+			}
 		}
-
-		content += markdown
-		content += "\n---\n[Clearnet link](" + feed.Link + ")\n"
-
-		messages = append(messages, map[string]interface{}{
-			"type": "post",
-			"link": feed.Link,
-			"text": content,
-		})
 	}
 
-	return messages, nil
+	return nil
 }
 
-// createAboutMessage publishes an about message with accompanying avatar, if available in config).
-func createAboutMessage(pub *sbot.Sbot, posts []Post, feed gofeed.Feed, cfg Config) (map[string]interface{}, bool, error) {
-	for _, post := range posts {
-		if post.Type == "about" {
-			log.Printf("createAboutMessage: skipping about message post, already done")
-			return nil, false, nil
-		}
+// aboutOf returns the avatar and the config of the about message published for
+// the feed at idx. The pub identity is about the first feed, unless it's a
+// metafeed: then every feed has its own subfeed, with the site icon of the
+// feed as its avatar.
+func aboutOf(bot *sbot.Sbot, cfg Config, idx int) (string, feed.Config) {
+	if publish.MetafeedMode(bot) {
+		return "", cfg.Feeds[idx]
 	}
 
-	message := map[string]interface{}{
-		"type":  "about",
-		"about": pub.KeyPair.ID(),
-		"name":  feed.Title,
+	return cfg.Avatar, cfg.Config
+}
+
+// dryRun fetches and converts all feeds like a poll does, but prints the
+// messages instead of publishing them. It returns the number of feeds which
+// failed.
+func dryRun(ctx context.Context, bot *sbot.Sbot, cfg Config, state *publish.State) (int, error) {
+	polls, err := publish.FetchFeeds(ctx, cfg.Feeds, cfg.Concurrency, bot, feed.HashBlobs{}, state)
+	if err != nil {
+		return 0, fmt.Errorf("dryRun: %w", err)
 	}
 
-	if cfg.Avatar != "" {
-		srcReader, err := getImage(cfg.Avatar)
-		if err != nil {
-			return nil, false, fmt.Errorf("createAboutMessage: %w", err)
+	failed := 0
+	for idx, poll := range polls {
+		if poll.Err != nil {
+			log.Printf("dryRun: %s", poll.Err)
+			failed++
+			continue
 		}
 
-		ref, err := pub.BlobStore.Put(srcReader)
-		if err != nil {
-			return nil, false, fmt.Errorf("createAboutMessage: unable to post blob: %w", err)
+		messages := poll.Messages
+		if idx == 0 || publish.MetafeedMode(bot) {
+			posts, err := publish.MessagesFromLog(bot)
+			if err != nil {
+				return failed, fmt.Errorf("dryRun: %w", err)
+			}
+
+			avatar, aboutCfg := aboutOf(bot, cfg, idx)
+			aboutMessage, posted, err := publish.CreateAboutMessage(ctx, bot, feed.HashBlobs{}, posts, poll.Feed, avatar, aboutCfg, false, state)
+			if err != nil {
+				return failed, fmt.Errorf("dryRun: %w", err)
+			}
+
+			introMessage, introduced, err := publish.IntroductionMessage(poll.Feed, cfg.Config, state)
+			if err != nil {
+				return failed, fmt.Errorf("dryRun: %w", err)
+			}
+			if introduced {
+				messages = append([]map[string]interface{}{introMessage}, messages...)
+			}
+
+			if posted {
+				messages = append([]map[string]interface{}{aboutMessage}, messages...)
+			}
 		}
 
-		message["image"] = ref.String()
+		if err := printMessages(os.Stdout, cfg.Feeds[idx], messages); err != nil {
+			return failed, fmt.Errorf("dryRun: %w", err)
+		}
 	}
 
-	log.Printf("createAboutMessage: creating about message post")
+	return failed, nil
+}
+
+// feedHealth is the health of a feed, as recorded in the state store.
+type feedHealth struct {
+	Feed          string    `json:"feed"`
+	LastPoll      time.Time `json:"lastPoll"`
+	LastItem      string    `json:"lastItem,omitempty"`
+	LastPublished time.Time `json:"lastPublished"`
+	Queued        int       `json:"queued"`
+	Failures      int       `json:"failures"`
+	LastError     string    `json:"lastError,omitempty"`
+	NostrErrors   []string  `json:"nostrErrors,omitempty"`
+	Published     int       `json:"published"`
+	Blobs         int       `json:"blobs"`
+	Paused        bool      `json:"paused,omitempty"`
+	DeadSince     time.Time `json:"deadSince"`
+	MovedTo       string    `json:"movedTo,omitempty"`
+}
 
-	return message, true, nil
+// newFeedHealth returns the health of a feed from its state.
+func newFeedHealth(feedURL string, state *publish.State) feedHealth {
+	feedState := state.Feed(feedURL)
+	return feedHealth{
+		Feed:          feedURL,
+		LastPoll:      feedState.LastPoll,
+		LastItem:      feedState.LastItem,
+		LastPublished: feedState.LastPublished,
+		Queued:        len(feedState.Queue),
+		Failures:      feedState.Failures,
+		LastError:     feedState.LastError,
+		NostrErrors:   state.NostrErrors(feedURL),
+		Published:     feedState.Published,
+		Blobs:         feedState.Blobs,
+		Paused:        feedState.Paused,
+		DeadSince:     feedState.DeadSince,
+		MovedTo:       feedState.MovedTo,
+	}
 }
 
-// chunkByLine chunks a full markdown converted RSS post into a thread.
-// Meaning, a series of chunks which fit under the max post size of a ssb post.
-func chunkByLine(content string) []string {
-	var chunks []string
+// feedStatus is the status of a feed, as reported by the admin API.
+type feedStatus struct {
+	feedHealth
+	NextPoll time.Time `json:"nextPoll"`
+}
 
-	toChunk := content
-	for toChunk != "" {
-		if len(toChunk) <= maxPostLength {
-			chunks = append(chunks, toChunk)
-			toChunk = ""
-			continue
-		}
+// statusBoard holds a snapshot of the feed statuses, the items held for
+// approval and the disk usage, which the scheduler updates and the admin API
+// reads.
+type statusBoard struct {
+	mu      sync.Mutex
+	feeds   []feedStatus
+	pending []publish.PendingEntry
+	disk    pub.DiskUsage
+}
 
-		chunkIdx := maxPostLength
-		for toChunk[chunkIdx] != 10 {
-			chunkIdx--
+// update replaces the snapshot with the current state of the feeds.
+func (b *statusBoard) update(feedCfgs []feed.Config, due []time.Time, state *publish.State) {
+	feeds := make([]feedStatus, len(feedCfgs))
+	for idx, feedCfg := range feedCfgs {
+		feeds[idx] = feedStatus{
+			feedHealth: newFeedHealth(feedCfg.Feed, state),
+			NextPoll:   due[idx],
 		}
-
-		chunks = append(chunks, toChunk[:chunkIdx])
-		toChunk = toChunk[chunkIdx:]
 	}
 
-	return chunks
+	pending := publish.ListPending(state)
+
+	b.mu.Lock()
+	b.feeds = feeds
+	b.pending = pending
+	b.mu.Unlock()
 }
 
-// publishAsThread posts a message as a series of linked messages. This is
-// useful when the content of the RSS post is too long.
-func publishAsThread(publish ssb.Publisher, message map[string]interface{}) error {
-	chunks := chunkByLine(message["text"].(string))
+// resolveAdmin reads the token of the admin API. Without a token, the admin
+// API is only served on a loopback address, anyone who reaches it can mint
+// invites and approve held posts.
+func resolveAdmin(cfg *Config) error {
+	token, err := feed.ResolveSecret(cfg.AdminToken, cfg.AdminTokenEnv, cfg.AdminTokenFile)
+	if err != nil {
+		return fmt.Errorf("resolveAdmin: admin-token: %w", err)
+	}
+	cfg.AdminToken = token
 
-	root := map[string]interface{}{
-		"type": "post",
-		"link": message["link"],
-		"text": chunks[0],
+	if cfg.AdminAddr == "" || cfg.AdminToken != "" {
+		return nil
 	}
 
-	ref, err := publish.Publish(root)
+	host, _, err := net.SplitHostPort(cfg.AdminAddr)
 	if err != nil {
-		return fmt.Errorf("publishAsThread: failed to publish: %w", err)
+		return fmt.Errorf("resolveAdmin: invalid admin-addr %s: %w", cfg.AdminAddr, err)
 	}
 
-	for _, chunk := range chunks[1:] {
-		threadReply := map[string]interface{}{
-			"type": "post",
-			"link": message["link"],
-			"text": chunk,
-			"root": ref.Key().String(),
-		}
-		_, err := publish.Publish(threadReply)
-		if err != nil {
-			return fmt.Errorf("publishAsThread: failed to publish: %w", err)
-		}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return fmt.Errorf("resolveAdmin: admin-addr %s is not a loopback address, configure an admin-token", cfg.AdminAddr)
 	}
 
 	return nil
 }
 
-// postMessagesToLog posts messages to the local user feed.
-func postMessagesToLog(messages []map[string]interface{}, pub *sbot.Sbot) error {
-	publish, err := message.OpenPublishLog(pub.ReceiveLog, pub.Users, pub.KeyPair)
-	if err != nil {
-		return fmt.Errorf("postMessagesToLog: failed to open publish log: %w", err)
+// requireToken refuses the requests to the admin API which don't carry the
+// admin token as a bearer token, except /healthz for the health probes.
+func requireToken(token string, next http.Handler) http.Handler {
+	if token == "" {
+		return next
 	}
 
-	for _, message := range messages {
-		if message["type"] == "post" {
-			log.Printf("postMessagesToLog: publishing %s to log", message["link"])
+	expected := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
 
-			if len(message["text"].(string)) > maxPostLength {
-				log.Printf("postMessagesToLog: turning content of %s into thread, too long", message["link"])
-				if err := publishAsThread(publish, message); err != nil {
-					return fmt.Errorf("postMessagesToLog: unable to thread content for %s: %w", message["link"], err)
-				}
-				continue
-			}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// serveAdmin serves the HTTP status and admin API: /healthz, /feeds, /disk, /poll
+// (POST, optionally ?feed=<url>), /invite (POST, optionally ?uses=<n>&note=<note>),
+// /invites, /invite/revoke (POST, ?id=<id>), /pending and /approve and /reject
+// (POST, ?id=<id>). Invites are minted for inviteHost and invitePort. The
+// decisions are applied by the scheduler, which owns the state store. With a
+// token, all but /healthz need it as a bearer token.
+func serveAdmin(addr, token string, bot *sbot.Sbot, invites *pub.Invites, inviteHost, invitePort string, board *statusBoard, pollNow chan<- string, decisions chan<- adminDecision) {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+
+	mux.HandleFunc("/feeds", func(w http.ResponseWriter, r *http.Request) {
+		board.mu.Lock()
+		feeds := board.feeds
+		board.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(feeds)
+	})
+
+	mux.HandleFunc("/disk", func(w http.ResponseWriter, r *http.Request) {
+		board.mu.Lock()
+		disk := board.disk
+		board.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(disk)
+	})
+
+	mux.HandleFunc("/poll", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
 		}
 
-		_, err := publish.Publish(message)
-		if err != nil {
-			return fmt.Errorf("postMessagesToLog: failed to publish: %w", err)
+		select {
+		case pollNow <- r.URL.Query().Get("feed"):
+		default:
 		}
-	}
 
-	return nil
-}
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprintln(w, "poll requested")
+	})
 
-// main is the main CLI entrypoint.
-func main() {
-	handleCliFlags()
+	mux.HandleFunc("/invite", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
 
-	if helpFlag {
-		fmt.Printf(help)
-		os.Exit(0)
-	}
+		uses := uint64(publicInviteUses)
+		if raw := r.URL.Query().Get("uses"); raw != "" {
+			var err error
+			uses, err = strconv.ParseUint(raw, 10, 32)
+			if err != nil || uses == 0 {
+				http.Error(w, "uses must be a positive number", http.StatusBadRequest)
+				return
+			}
+		}
 
-	cfg, err := loadYAMLConfig()
-	if err != nil {
-		log.Fatal(err)
-	}
+		token, err := invites.Create(bot, inviteHost, invitePort, uint(uses), r.URL.Query().Get("note"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 
-	log.Printf("loaded %s", configFlag)
+		fmt.Fprintln(w, token)
+	})
 
-	pub, err := newSbot(cfg)
-	if err != nil {
-		log.Fatal(err)
-	}
+	mux.HandleFunc("/invites", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(invites.List())
+	})
 
-	args := os.Args[1:]
-	if len(args) > 0 {
-		markdown, err := firstRSSPost(args[0], pub)
-		if err != nil {
-			log.Fatal(err)
+	mux.HandleFunc("/invite/revoke", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
 		}
-		fmt.Println(markdown)
-		return
-	}
 
-	go serveSbot(pub)
+		if err := invites.Revoke(r.URL.Query().Get("id")); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
 
-	log.Print("main: bootstrapped internally managed go-sbot")
+		fmt.Fprintln(w, "invite revoked")
+	})
 
-	feed, err := parseRSSFeed(cfg.Feed)
-	if err != nil {
-		log.Fatal(err)
-	}
+	mux.HandleFunc("/pending", func(w http.ResponseWriter, r *http.Request) {
+		board.mu.Lock()
+		entries := board.pending
+		board.mu.Unlock()
 
-	log.Printf("main: parsed %s", cfg.Feed)
+		if entries == nil {
+			entries = []publish.PendingEntry{}
+		}
 
-	posts, err := messagesFromLog(pub)
-	if err != nil {
-		log.Fatal(err)
-	}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(entries)
+	})
 
-	log.Printf("main: retrieved %d posts from log", len(posts))
+	mux.HandleFunc("/approve", decideHandler(decisions, true))
+	mux.HandleFunc("/reject", decideHandler(decisions, false))
 
-	var messages []map[string]interface{}
+	log.Printf("serveAdmin: serving admin API on %s", addr)
 
-	aboutMessage, posted, err := createAboutMessage(pub, posts, feed, cfg)
-	if err != nil {
-		log.Fatal(err)
-	}
-	if posted {
-		messages = append(messages, aboutMessage)
+	if err := http.ListenAndServe(addr, requireToken(token, mux)); err != nil {
+		log.Fatal(fmt.Errorf("serveAdmin: %w", err))
 	}
+}
 
-	newRSSPosts, err := getNewRSSPosts(feed, posts, pub)
+// adminDecision is an approval or rejection of a held item through the admin
+// API, which the scheduler answers on result.
+type adminDecision struct {
+	id      string
+	approve bool
+	result  chan<- decisionResult
+}
+
+// decisionResult is the outcome of an adminDecision.
+type decisionResult struct {
+	entry publish.PendingEntry
+	err   error
+}
+
+// decideHandler handles /approve (or /reject). The decision is handed to the
+// scheduler, it's answered when the scheduler is idle.
+func decideHandler(decisions chan<- adminDecision, approve bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		result := make(chan decisionResult, 1)
+		select {
+		case decisions <- adminDecision{id: r.URL.Query().Get("id"), approve: approve, result: result}:
+		case <-r.Context().Done():
+			return
+		}
+
+		var decided decisionResult
+		select {
+		case decided = <-result:
+		case <-r.Context().Done():
+			return
+		}
+
+		if decided.err != nil {
+			http.Error(w, decided.err.Error(), http.StatusNotFound)
+			return
+		}
+
+		fmt.Fprintf(w, "%s %s: %s\n", decided.entry.Decision, decided.entry.ID, decided.entry.Title)
+	}
+}
+
+// applyDecision applies a decision of the admin API to the state store and
+// answers it. It returns the feed of the decided item, which should be polled
+// right away so that it's published, or "" when nothing was decided.
+func applyDecision(state *publish.State, decision adminDecision) string {
+	feedURL, entry, err := publish.Decide(state, decision.id, decision.approve)
+	if err == nil {
+		// the decision is kept even if the pub stops before the feed is
+		// published
+		err = state.Save()
+	}
+
+	decision.result <- decisionResult{entry, err}
+	if err != nil {
+		return ""
+	}
+
+	return feedURL
+}
+
+// adminRequest sends a request to the admin API of the running rss-butt-plug
+// and returns the response body.
+func adminRequest(cfg Config, method, path string, query url.Values) ([]byte, error) {
+	adminAddr := cfg.AdminAddr
+	if adminAddr == "" {
+		return nil, fmt.Errorf("adminRequest: admin-addr must be configured to speak to the running pub")
+	}
+
+	if strings.HasPrefix(adminAddr, ":") {
+		adminAddr = "localhost" + adminAddr
+	}
+
+	endpoint := fmt.Sprintf("http://%s%s?%s", adminAddr, path, query.Encode())
+	req, err := http.NewRequest(method, endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("adminRequest: %w", err)
+	}
+
+	if cfg.AdminToken != "" {
+		req.Header.Set("Authorization", "Bearer "+cfg.AdminToken)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("adminRequest: unable to reach the running pub: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("adminRequest: unable to read response: %w", err)
+	}
+
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("adminRequest: %s %s: %s", method, path, strings.TrimSpace(string(body)))
+	}
+
+	return body, nil
+}
+
+// inviteCommand runs the invite subcommand against the admin API of the
+// running rss-butt-plug.
+func inviteCommand(w io.Writer, cfg Config, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("inviteCommand: missing invite command (create, list or revoke)")
+	}
+
+	switch args[0] {
+	case "create":
+		flags := flag.NewFlagSet("invite create", flag.ContinueOnError)
+		uses := flags.Uint("uses", 1, "how many times the invite can be used")
+		note := flags.String("note", "", "a note to organise invites")
+		if err := flags.Parse(args[1:]); err != nil {
+			return fmt.Errorf("inviteCommand: %w", err)
+		}
+
+		if *uses == 0 {
+			return fmt.Errorf("inviteCommand: -uses must be a positive number")
+		}
+
+		query := url.Values{"uses": {strconv.FormatUint(uint64(*uses), 10)}}
+		if *note != "" {
+			query.Set("note", *note)
+		}
+
+		body, err := adminRequest(cfg, http.MethodPost, "/invite", query)
+		if err != nil {
+			return fmt.Errorf("inviteCommand: %w", err)
+		}
+
+		fmt.Fprint(w, string(body))
+	case "list":
+		body, err := adminRequest(cfg, http.MethodGet, "/invites", nil)
+		if err != nil {
+			return fmt.Errorf("inviteCommand: %w", err)
+		}
+
+		var records []pub.InviteRecord
+		if err := json.Unmarshal(body, &records); err != nil {
+			return fmt.Errorf("inviteCommand: unable to unmarshal invites: %w", err)
+		}
+
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tUSES\tCREATED\tREVOKED\tNOTE")
+		for _, record := range records {
+			fmt.Fprintf(tw, "%s\t%d\t%s\t%t\t%s\n", record.ID, record.Uses,
+				record.Created.Format(time.RFC3339), record.Revoked, record.Note)
+		}
+		tw.Flush()
+	case "revoke":
+		if len(args) != 2 {
+			return fmt.Errorf("inviteCommand: usage: invite revoke <id>")
+		}
+
+		body, err := adminRequest(cfg, http.MethodPost, "/invite/revoke", url.Values{"id": {args[1]}})
+		if err != nil {
+			return fmt.Errorf("inviteCommand: %w", err)
+		}
+
+		fmt.Fprint(w, string(body))
+	default:
+		return fmt.Errorf("inviteCommand: unknown invite command %s", args[0])
+	}
+
+	return nil
+}
+
+// formatTime formats t for the status output, "never" when it's zero.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+
+	return t.Format(time.RFC3339)
+}
+
+// statusCommand runs the status subcommand, which prints the health of the
+// configured feeds from the state store. It works whether or not
+// rss-butt-plug is running.
+func statusCommand(w io.Writer, cfg Config, args []string) error {
+	flags := flag.NewFlagSet("status", flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "output JSON")
+	if err := flags.Parse(args); err != nil {
+		return fmt.Errorf("statusCommand: %w", err)
+	}
+
+	if flags.NArg() > 0 {
+		return fmt.Errorf("statusCommand: usage: status [-json]")
+	}
+
+	state, err := publish.LoadState(cfg.Sbot.DataDir, "")
+	if err != nil {
+		return fmt.Errorf("statusCommand: %w", err)
+	}
+
+	feeds := make([]feedHealth, len(cfg.Feeds))
+	for idx, feedCfg := range cfg.Feeds {
+		feeds[idx] = newFeedHealth(feedCfg.Feed, state)
+	}
+
+	disk, err := pub.MeasureDiskUsage(cfg.Sbot)
+	if err != nil {
+		return fmt.Errorf("statusCommand: %w", err)
+	}
+
+	if *asJSON {
+		status := struct {
+			Feeds        []feedHealth  `json:"feeds"`
+			Disk         pub.DiskUsage `json:"disk"`
+			MaxDiskUsage int64         `json:"maxDiskUsage,omitempty"`
+		}{feeds, disk, cfg.Sbot.MaxDiskUsage}
+
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(status); err != nil {
+			return fmt.Errorf("statusCommand: unable to encode status: %w", err)
+		}
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "FEED\tLAST POLL\tLAST PUBLISHED\tQUEUED\tFAILURES\tMESSAGES\tBLOBS\tLAST ITEM")
+	for _, health := range feeds {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%d\t%d\t%s\n", health.Feed, formatTime(health.LastPoll),
+			formatTime(health.LastPublished), health.Queued, health.Failures, health.Published,
+			health.Blobs, health.LastItem)
+	}
+	tw.Flush()
+
+	for _, health := range feeds {
+		if health.MovedTo != "" {
+			fmt.Fprintf(w, "\n%s: moved permanently to %s\n", health.Feed, health.MovedTo)
+		}
+
+		if health.Paused {
+			fmt.Fprintf(w, "\n%s: paused, gone since %s\n", health.Feed, formatTime(health.DeadSince))
+		}
+
+		if health.LastError != "" {
+			fmt.Fprintf(w, "\n%s: %s\n", health.Feed, health.LastError)
+		}
+
+		for _, err := range health.NostrErrors {
+			fmt.Fprintf(w, "\n%s: %s\n", health.Feed, err)
+		}
+	}
+
+	fmt.Fprintf(w, "\ndata-dir: %s (repo %s, blobs %s)", formatSize(disk.Total()), formatSize(disk.Repo), formatSize(disk.Blobs))
+	if cfg.Sbot.MaxDiskUsage > 0 {
+		fmt.Fprintf(w, " of %s", formatSize(cfg.Sbot.MaxDiskUsage))
+	}
+	fmt.Fprintln(w)
+
+	return nil
+}
+
+// listedItem is a published item of a feed, as printed by the list
+// subcommand.
+type listedItem struct {
+	Feed string `json:"feed"`
+	publish.PublishedItem
+}
+
+// listCommand runs the list subcommand, which prints the published items of
+// the configured feeds from the state store. It works whether or not
+// rss-butt-plug is running.
+func listCommand(w io.Writer, cfg Config, args []string) error {
+	flags := flag.NewFlagSet("list", flag.ContinueOnError)
+	feedURL := flags.String("feed", "", "only list the items of this feed")
+	asJSON := flags.Bool("json", false, "output JSON")
+	if err := flags.Parse(args); err != nil {
+		return fmt.Errorf("listCommand: %w", err)
+	}
+
+	if flags.NArg() > 0 {
+		return fmt.Errorf("listCommand: usage: list [-feed <feed>] [-json]")
+	}
+
+	state, err := publish.LoadState(cfg.Sbot.DataDir, "")
+	if err != nil {
+		return fmt.Errorf("listCommand: %w", err)
+	}
+
+	items := []listedItem{}
+	found := false
+	for _, feedCfg := range cfg.Feeds {
+		if *feedURL != "" && feedCfg.Feed != *feedURL {
+			continue
+		}
+		found = true
+
+		for _, item := range state.Feed(feedCfg.Feed).Items {
+			items = append(items, listedItem{Feed: feedCfg.Feed, PublishedItem: item})
+		}
+	}
+
+	if !found {
+		return fmt.Errorf("listCommand: %s is not a configured feed", *feedURL)
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(items); err != nil {
+			return fmt.Errorf("listCommand: unable to encode items: %w", err)
+		}
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "FEED\tPUBLISHED\tTYPE\tKEY\tMESSAGES\tLINK\tBLOBS")
+	for _, item := range items {
+		link := item.Link
+		if link == "" {
+			link = "-"
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n", item.Feed, formatTime(item.Published), item.Type,
+			item.Key, item.Messages, link, strings.Join(item.Blobs, " "))
+	}
+	tw.Flush()
+
+	return nil
+}
+
+// pendingCommand runs the pending subcommand, which prints the posts of
+// moderated feeds which wait for approval from the state store. It works
+// whether or not rss-butt-plug is running.
+func pendingCommand(w io.Writer, cfg Config, args []string) error {
+	flags := flag.NewFlagSet("pending", flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "output JSON")
+	if err := flags.Parse(args); err != nil {
+		return fmt.Errorf("pendingCommand: %w", err)
+	}
+
+	if flags.NArg() > 0 {
+		return fmt.Errorf("pendingCommand: usage: pending [-json]")
+	}
+
+	state, err := publish.LoadState(cfg.Sbot.DataDir, "")
+	if err != nil {
+		return fmt.Errorf("pendingCommand: %w", err)
+	}
+
+	entries := publish.ListPending(state)
+
+	if *asJSON {
+		if entries == nil {
+			entries = []publish.PendingEntry{}
+		}
+
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(entries); err != nil {
+			return fmt.Errorf("pendingCommand: unable to encode pending posts: %w", err)
+		}
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tFEED\tADDED\tDECISION\tTITLE")
+	for _, entry := range entries {
+		decision := entry.Decision
+		if decision == "" {
+			decision = "-"
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", entry.ID, entry.Feed, formatTime(entry.Added), decision, entry.Title)
+	}
+	tw.Flush()
+
+	return nil
+}
+
+// decideCommand runs the approve and reject subcommands. With admin-addr,
+// the decisions go through the admin API of the running rss-butt-plug, else
+// they're written to the state store, which needs the data-dir lock. They
+// take effect the next time the feed is published.
+func decideCommand(w io.Writer, cfg Config, approve bool, args []string) error {
+	command := "reject"
+	if approve {
+		command = "approve"
+	}
+
+	if len(args) == 0 {
+		return fmt.Errorf("decideCommand: usage: %s <id>...", command)
+	}
+
+	if cfg.AdminAddr != "" {
+		for _, id := range args {
+			body, err := adminRequest(cfg, http.MethodPost, "/"+command, url.Values{"id": {id}})
+			if err != nil {
+				return fmt.Errorf("decideCommand: %w", err)
+			}
+
+			fmt.Fprint(w, string(body))
+		}
+		return nil
+	}
+
+	lock, err := pub.Lock(cfg.Sbot)
+	if err != nil {
+		return fmt.Errorf("decideCommand: %w", err)
+	}
+	defer lock.Close()
+
+	state, err := publish.LoadState(cfg.Sbot.DataDir, "")
+	if err != nil {
+		return fmt.Errorf("decideCommand: %w", err)
+	}
+
+	for _, id := range args {
+		_, entry, err := publish.Decide(state, id, approve)
+		if err != nil {
+			return fmt.Errorf("decideCommand: %w", err)
+		}
+
+		fmt.Fprintf(w, "%s %s: %s\n", entry.Decision, entry.ID, entry.Title)
+	}
+
+	if err := state.Save(); err != nil {
+		return fmt.Errorf("decideCommand: %w", err)
+	}
+
+	return nil
+}
+
+// healthCommand runs the health subcommand, which checks that the running
+// rss-butt-plug polls its feeds and that its go-sbot answers. It fails unless
+// a feed was polled successfully within factor times its poll interval, a
+// single broken source doesn't make the daemon unhealthy.
+func healthCommand(w io.Writer, cfg Config, args []string) error {
+	flags := flag.NewFlagSet("health", flag.ContinueOnError)
+	factor := flags.Int("factor", defaultHealthFactor, "number of poll intervals")
+	if err := flags.Parse(args); err != nil {
+		return fmt.Errorf("healthCommand: %w", err)
+	}
+
+	if flags.NArg() > 0 || *factor < 1 {
+		return fmt.Errorf("healthCommand: usage: health [-factor N]")
+	}
+
+	state, err := publish.LoadState(cfg.Sbot.DataDir, "")
+	if err != nil {
+		return fmt.Errorf("healthCommand: %w", err)
+	}
+
+	now := time.Now()
+	polled, active := false, 0
+	for _, feedCfg := range cfg.Feeds {
+		// paused feeds aren't polled any more
+		if state.Feed(feedCfg.Feed).Paused {
+			continue
+		}
+		active++
+
+		lastPoll := state.Feed(feedCfg.Feed).LastPoll
+		maxAge := time.Duration(*factor) * feed.PollInterval(feedCfg, now)
+		if !lastPoll.IsZero() && now.Sub(lastPoll) <= maxAge {
+			polled = true
+			continue
+		}
+
+		fmt.Fprintf(w, "%s: last successful poll %s, expected within %s\n", feedCfg.Feed, formatTime(lastPoll), maxAge)
+	}
+
+	if !polled && active > 0 {
+		return fmt.Errorf("healthCommand: no feed was polled successfully in time")
+	}
+
+	id, err := pub.Ping(cfg.Sbot, healthTimeout)
+	if err != nil {
+		return fmt.Errorf("healthCommand: %w", err)
+	}
+
+	fmt.Fprintf(w, "ok: %s\n", id.String())
+
+	return nil
+}
+
+// formatSize formats a size in bytes for humans.
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// blobsCommand runs the blobs subcommand, which deletes the blobs which no
+// message refers to. rss-butt-plug must not be running.
+func blobsCommand(w io.Writer, bot *sbot.Sbot, state *publish.State, args []string) error {
+	if len(args) == 0 || args[0] != "gc" {
+		return fmt.Errorf("blobsCommand: usage: blobs gc [-dry-run]")
+	}
+
+	flags := flag.NewFlagSet("blobs gc", flag.ContinueOnError)
+	dry := flags.Bool("dry-run", dryRunFlag, "only report the unreferenced blobs")
+	if err := flags.Parse(args[1:]); err != nil {
+		return fmt.Errorf("blobsCommand: %w", err)
+	}
+
+	unreferenced, err := publish.CollectBlobs(bot, state, *dry)
+	if err != nil {
+		return fmt.Errorf("blobsCommand: %w", err)
+	}
+
+	var total int64
+	for _, blob := range unreferenced {
+		fmt.Fprintf(w, "%s\t%s\n", blob.Ref, formatSize(blob.Size))
+		total += blob.Size
+	}
+
+	if *dry {
+		fmt.Fprintf(w, "%d unreferenced blob(s), %s would be freed\n", len(unreferenced), formatSize(total))
+	} else {
+		fmt.Fprintf(w, "deleted %d unreferenced blob(s), freed %s\n", len(unreferenced), formatSize(total))
+	}
+
+	return nil
+}
+
+// exportCommand runs the export subcommand, which writes the published posts
+// to a directory. rss-butt-plug must not be running.
+func exportCommand(w io.Writer, bot *sbot.Sbot, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("exportCommand: usage: export <dir>")
+	}
+
+	exported, err := publish.Export(bot, args[0])
+	if err != nil {
+		return fmt.Errorf("exportCommand: %w", err)
+	}
+
+	fmt.Fprintf(w, "exported %d post(s) to %s\n", exported, args[0])
+
+	return nil
+}
+
+// feedCommand runs the feed subcommand, which resets the state of a feed.
+// rss-butt-plug must not be running.
+func feedCommand(w io.Writer, bot *sbot.Sbot, cfg Config, state *publish.State, args []string) error {
+	if len(args) == 2 && args[0] == "resume" {
+		return resumeFeed(w, cfg, state, args[1])
+	}
+
+	if len(args) == 0 || args[0] != "reset" {
+		return fmt.Errorf("feedCommand: usage: feed reset [-blobs] <feed> or feed resume <feed>")
+	}
+
+	flags := flag.NewFlagSet("feed reset", flag.ContinueOnError)
+	blobs := flags.Bool("blobs", false, "also delete the unreferenced blobs")
+	if err := flags.Parse(args[1:]); err != nil {
+		return fmt.Errorf("feedCommand: %w", err)
+	}
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("feedCommand: usage: feed reset [-blobs] <feed>")
+	}
+
+	var feedCfg *feed.Config
+	for idx := range cfg.Feeds {
+		if cfg.Feeds[idx].Feed == flags.Arg(0) {
+			feedCfg = &cfg.Feeds[idx]
+		}
+	}
+	if feedCfg == nil {
+		return fmt.Errorf("feedCommand: %s is not a configured feed", flags.Arg(0))
+	}
+
+	if err := publish.ResetFeed(context.Background(), bot, *feedCfg, state); err != nil {
+		return fmt.Errorf("feedCommand: %w", err)
+	}
+
+	fmt.Fprintf(w, "reset %s, its items are published again on the next poll\n", feedCfg.Feed)
+
+	if !*blobs {
+		return nil
+	}
+
+	if err := blobsCommand(w, bot, state, []string{"gc"}); err != nil {
+		return fmt.Errorf("feedCommand: %w", err)
+	}
+
+	return nil
+}
+
+// resumeFeed polls a feed which was paused, because it was gone, again.
+func resumeFeed(w io.Writer, cfg Config, state *publish.State, feedURL string) error {
+	found := false
+	for _, feedCfg := range cfg.Feeds {
+		found = found || feedCfg.Feed == feedURL
+	}
+	if !found {
+		return fmt.Errorf("resumeFeed: %s is not a configured feed", feedURL)
+	}
+
+	feedState := state.Feed(feedURL)
+	if !feedState.Paused {
+		return fmt.Errorf("resumeFeed: %s is not paused", feedURL)
+	}
+
+	feedState.DeadSince, feedState.Paused = time.Time{}, false
+	if err := state.Save(); err != nil {
+		return fmt.Errorf("resumeFeed: %w", err)
+	}
+
+	fmt.Fprintf(w, "resumed %s, it's polled again on the next start\n", feedURL)
+
+	return nil
+}
+
+// republishCommand runs the republish subcommand, which converts an item
+// again and publishes it as a new post. rss-butt-plug must not be running.
+func republishCommand(w io.Writer, bot *sbot.Sbot, cfg Config, state *publish.State, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("republishCommand: usage: republish <item-link-or-guid>")
+	}
+
+	link, err := publish.Republish(context.Background(), bot, bot.BlobStore, cfg.Feeds, args[0], state)
+	if err != nil {
+		return fmt.Errorf("republishCommand: %w", err)
+	}
+
+	fmt.Fprintf(w, "republished %s\n", link)
+
+	return nil
+}
+
+// repoCommand runs the repo subcommand, which checks the consistency of the
+// repo in the data directory or rebuilds its indexes. rss-butt-plug must not
+// be running.
+func repoCommand(w io.Writer, cfg pub.Config, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("repoCommand: missing repo command (check or compact)")
+	}
+
+	switch args[0] {
+	case "check":
+		flags := flag.NewFlagSet("repo check", flag.ContinueOnError)
+		repair := flags.Bool("repair", false, "null the messages of inconsistent feeds")
+		if err := flags.Parse(args[1:]); err != nil {
+			return fmt.Errorf("repoCommand: %w", err)
+		}
+
+		problems, err := pub.CheckRepo(cfg, *repair)
+		if err != nil {
+			return fmt.Errorf("repoCommand: %w", err)
+		}
+
+		for _, problem := range problems {
+			fmt.Fprintln(w, problem)
+		}
+
+		switch {
+		case len(problems) == 0:
+			fmt.Fprintln(w, "repo is consistent")
+		case *repair:
+			fmt.Fprintf(w, "repaired %d problem(s), run repo compact to rebuild the indexes\n", len(problems))
+		default:
+			return fmt.Errorf("repoCommand: found %d problem(s), run repo check -repair to fix them", len(problems))
+		}
+	case "compact":
+		if err := pub.CompactRepo(cfg); err != nil {
+			return fmt.Errorf("repoCommand: %w", err)
+		}
+
+		fmt.Fprintln(w, "indexes rebuilt")
+	default:
+		return fmt.Errorf("repoCommand: unknown repo command %s", args[0])
+	}
+
+	return nil
+}
+
+// keyCommand runs the key subcommand, which exports and imports the keypair
+// of the pub identity. rss-butt-plug must not be running when importing.
+func keyCommand(w io.Writer, cfg pub.Config, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("keyCommand: missing key command (export or import)")
+	}
+
+	switch args[0] {
+	case "export":
+		if len(args) > 2 {
+			return fmt.Errorf("keyCommand: usage: key export [<file>]")
+		}
+
+		if len(args) == 1 {
+			if err := pub.ExportKey(cfg, w); err != nil {
+				return fmt.Errorf("keyCommand: %w", err)
+			}
+			return nil
+		}
+
+		file, err := os.OpenFile(args[1], os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			return fmt.Errorf("keyCommand: unable to create %s: %w", args[1], err)
+		}
+
+		if err := pub.ExportKey(cfg, file); err != nil {
+			file.Close()
+			return fmt.Errorf("keyCommand: %w", err)
+		}
+
+		if err := file.Close(); err != nil {
+			return fmt.Errorf("keyCommand: unable to close %s: %w", args[1], err)
+		}
+	case "import":
+		flags := flag.NewFlagSet("key import", flag.ContinueOnError)
+		force := flags.Bool("force", false, "replace an existing identity")
+		if err := flags.Parse(args[1:]); err != nil {
+			return fmt.Errorf("keyCommand: %w", err)
+		}
+
+		if flags.NArg() != 1 {
+			return fmt.Errorf("keyCommand: usage: key import [-force] <file>")
+		}
+
+		id, err := pub.ImportKey(cfg, flags.Arg(0), *force)
+		if err != nil {
+			return fmt.Errorf("keyCommand: %w", err)
+		}
+
+		fmt.Fprintf(w, "imported %s\n", id)
+	default:
+		return fmt.Errorf("keyCommand: unknown key command %s", args[0])
+	}
+
+	return nil
+}
+
+// initConfig is the config file written by the init subcommand.
+type initConfig struct {
+	DataDir string `yaml:"data-dir"`
+	Feed    string `yaml:"feed"`
+	Port    string `yaml:"port"`
+	WsPort  string `yaml:"ws-port"`
+	Hops    uint   `yaml:"hops"`
+	Poll    int    `yaml:"poll"`
+}
+
+// prompt asks for a value on the terminal, returning fallback for an empty
+// answer (or the end of input).
+func prompt(r *bufio.Reader, w io.Writer, question, fallback string) (string, error) {
+	if fallback != "" {
+		fmt.Fprintf(w, "%s [%s]: ", question, fallback)
+	} else {
+		fmt.Fprintf(w, "%s: ", question)
+	}
+
+	answer, err := r.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", fmt.Errorf("prompt: unable to read answer: %w", err)
+	}
+
+	if answer = strings.TrimSpace(answer); answer == "" {
+		return fallback, nil
+	}
+
+	return answer, nil
+}
+
+// initCommand runs the init subcommand, which bootstraps a new instance: it
+// checks the options (and with -check, fetches and converts the first item of
+// the feed), generates the keypair in the data-dir and only then writes the
+// config file. Missing options are asked for on a terminal.
+func initCommand(r io.Reader, w io.Writer, args []string) error {
+	flags := flag.NewFlagSet("init", flag.ContinueOnError)
+	feedURL := flags.String("feed", "", "RSS feed URL")
+	dataDir := flags.String("data-dir", "", "data directory")
+	port := flags.String("port", "8008", "SSB port")
+	wsPort := flags.String("ws-port", "8989", "SSB websocket port")
+	poll := flags.Int("poll", 5, "poll frequency in minutes")
+	check := flags.Bool("check", false, "fetch and convert the first item of the feed")
+	force := flags.Bool("force", false, "overwrite an existing config file")
+	if err := flags.Parse(args); err != nil {
+		return fmt.Errorf("initCommand: %w", err)
+	}
+
+	if flags.NArg() > 0 {
+		return fmt.Errorf("initCommand: usage: init [-feed <url>] [-data-dir <dir>] [-port N] [-ws-port N] [-poll N] [-check] [-force]")
+	}
+
+	configPath, err := filepath.Abs(configFlag)
+	if err != nil {
+		return fmt.Errorf("initCommand: unable to convert %s to an absolute path: %w", configFlag, err)
+	}
+
+	if ext := strings.ToLower(filepath.Ext(configPath)); ext == ".toml" || ext == ".json" {
+		return fmt.Errorf("initCommand: init writes YAML, not %s", ext)
+	}
+
+	if _, err := os.Stat(configPath); err == nil && !*force {
+		return fmt.Errorf("initCommand: %s already exists, use -force to overwrite it", configFlag)
+	}
+
+	if stdin, ok := r.(*os.File); ok && isTerminal(stdin) {
+		reader := bufio.NewReader(r)
+		if *feedURL == "" {
+			if *feedURL, err = prompt(reader, w, "feed URL", ""); err != nil {
+				return fmt.Errorf("initCommand: %w", err)
+			}
+		}
+
+		if *dataDir == "" {
+			if *dataDir, err = prompt(reader, w, "data directory", ".rss-butt-plug"); err != nil {
+				return fmt.Errorf("initCommand: %w", err)
+			}
+		}
+	}
+
+	if *dataDir == "" {
+		*dataDir = ".rss-butt-plug"
+	}
+
+	// the data-dir is written as an absolute path, so that the config works
+	// from any working directory
+	if *dataDir, err = filepath.Abs(*dataDir); err != nil {
+		return fmt.Errorf("initCommand: unable to convert %s to an absolute path: %w", *dataDir, err)
+	}
+
+	written := initConfig{DataDir: *dataDir, Feed: *feedURL, Port: *port, WsPort: *wsPort, Hops: 1, Poll: *poll}
+	sbotCfg := pub.Config{DataDir: written.DataDir, Port: written.Port, WsPort: written.WsPort, Hops: written.Hops}
+
+	feedCfg := feed.Config{Feed: written.Feed, Poll: written.Poll}
+	if err := feed.ValidateConfig(&feedCfg); err != nil {
+		return fmt.Errorf("initCommand: %w", err)
+	}
+
+	if *check {
+		if err := previewFeed(w, feedCfg.Feed, feedCfg, 1, false); err != nil {
+			return fmt.Errorf("initCommand: %w", err)
+		}
+	}
+
+	lock, err := pub.Lock(sbotCfg)
+	if err != nil {
+		return fmt.Errorf("initCommand: %w", err)
+	}
+	defer lock.Close()
+
+	id, err := pub.CreateKey(sbotCfg)
+	if err != nil {
+		return fmt.Errorf("initCommand: %w", err)
+	}
+
+	contents, err := yaml.Marshal(written)
+	if err != nil {
+		return fmt.Errorf("initCommand: unable to marshal config: %w", err)
+	}
+	contents = append([]byte("---\n# written by rss-butt-plug init, see the README for all options\n"), contents...)
+
+	tmpPath := configPath + ".tmp"
+	if err := os.WriteFile(tmpPath, contents, 0600); err != nil {
+		return fmt.Errorf("initCommand: unable to write %s: %w", tmpPath, err)
+	}
+
+	if err := os.Rename(tmpPath, configPath); err != nil {
+		return fmt.Errorf("initCommand: unable to rename %s: %w", tmpPath, err)
+	}
+
+	fmt.Fprintf(w, "wrote %s\nidentity: %s\nstart it with: rss-butt-plug -c %s\n", configFlag, id, configFlag)
+
+	return nil
+}
+
+// reloadConfig reloads the config file. Feeds which are still configured keep
+// their schedule (unless it changed) and new feeds are polled right away. The sbot and HTTP server
+// options only take effect after a restart.
+func reloadConfig(cfg Config, due []time.Time) (Config, []time.Time, error) {
+	reloaded, err := loadYAMLConfig()
+	if err != nil {
+		return cfg, due, fmt.Errorf("reloadConfig: %w", err)
+	}
+
+	if !reflect.DeepEqual(reloaded.Sbot, cfg.Sbot) || reloaded.FeedAddr != cfg.FeedAddr || reloaded.AdminAddr != cfg.AdminAddr {
+		log.Printf("reloadConfig: sbot and HTTP server changes require a restart")
+	}
+
+	previous := make(map[string]int)
+	for idx, feedCfg := range cfg.Feeds {
+		previous[feedCfg.Feed] = idx
+	}
+
+	reloadedDue := make([]time.Time, len(reloaded.Feeds))
+	for idx, feedCfg := range reloaded.Feeds {
+		previousIdx, ok := previous[feedCfg.Feed]
+		if !ok {
+			log.Printf("reloadConfig: added %s", feedCfg.Feed)
+			reloadedDue[idx] = time.Now()
+			continue
+		}
+
+		previousCfg := cfg.Feeds[previousIdx]
+		if feedCfg.Poll != previousCfg.Poll || feedCfg.PollEvery != previousCfg.PollEvery ||
+			feedCfg.Schedule != previousCfg.Schedule || feedCfg.PollJitter != previousCfg.PollJitter {
+			reloadedDue[idx] = feed.NextDue(gofeed.Feed{}, feedCfg, time.Now())
+			continue
+		}
+
+		reloadedDue[idx] = due[previousIdx]
+	}
+
+	log.Printf("reloadConfig: reloaded %s with %d feed(s)", configFlag, len(reloaded.Feeds))
+
+	return reloaded, reloadedDue, nil
+}
+
+// sdNotify sends a state to systemd, failures are only logged.
+func sdNotify(state string) {
+	if err := pub.SdNotify(state); err != nil {
+		log.Printf("main: %s", err)
+	}
+}
+
+// shutdown flushes the state store and closes the go-sbot.
+func shutdown(bot *sbot.Sbot, state *publish.State) {
+	log.Print("main: shutting down")
+	sdNotify("STOPPING=1")
+
+	if err := state.Save(); err != nil {
+		log.Printf("main: %s", err)
+	}
+
+	closeBot(bot)
+}
+
+// closeBot shuts the go-sbot down, exiting when it can't be closed cleanly.
+func closeBot(bot *sbot.Sbot) {
+	if err := pub.Close(bot); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// shareImages makes the feeds share the image state of the pub.
+func shareImages(cfg *Config, images *feed.Images) {
+	cfg.Config.Images = images
+	for idx := range cfg.Feeds {
+		cfg.Feeds[idx].Images = images
+	}
+}
+
+// checkDiskUsage measures the disk usage of the data directory for the admin
+// API and pauses storing blobs while max-disk-usage is exceeded.
+func checkDiskUsage(bot *sbot.Sbot, cfg Config, board *statusBoard) {
+	usage, err := pub.MeasureDiskUsage(cfg.Sbot)
+	if err != nil {
+		log.Printf("checkDiskUsage: %s", err)
+		return
+	}
+
+	board.mu.Lock()
+	board.disk = usage
+	board.mu.Unlock()
+
+	exceeded := cfg.Sbot.MaxDiskUsage > 0 && usage.Total() > cfg.Sbot.MaxDiskUsage
+	if exceeded == cfg.Images.BlobsPaused() {
+		return
+	}
+
+	cfg.Images.PauseBlobs(exceeded)
+
+	if !exceeded {
+		log.Printf("checkDiskUsage: data-dir is below max-disk-usage again, storing images as blobs")
+		return
+	}
+
+	text := fmt.Sprintf("data-dir uses %s, more than max-disk-usage (%s), images are linked instead of stored as blobs",
+		formatSize(usage.Total()), formatSize(cfg.Sbot.MaxDiskUsage))
+	log.Printf("checkDiskUsage: %s", text)
+
+	if cfg.Alert.Enabled() {
+		publish.Alert(bot, cfg.Alert, text)
+	}
+}
+
+// main is the main CLI entrypoint.
+func main() {
+	handleCliFlags()
+
+	if helpFlag {
+		fmt.Printf(help)
+		os.Exit(0)
+	}
+
+	args := flag.Args()
+	if len(args) > 0 && args[0] == "init" {
+		if err := initCommand(os.Stdin, os.Stdout, args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	cfg, err := loadYAMLConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("loaded %s", configFlag)
+
+	if len(args) > 0 && args[0] == "invite" {
+		if err := inviteCommand(os.Stdout, cfg, args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if len(args) > 0 && args[0] == "status" {
+		if err := statusCommand(os.Stdout, cfg, args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if len(args) > 0 && args[0] == "list" {
+		if err := listCommand(os.Stdout, cfg, args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if len(args) > 0 && args[0] == "pending" {
+		if err := pendingCommand(os.Stdout, cfg, args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if len(args) > 0 && (args[0] == "approve" || args[0] == "reject") {
+		if err := decideCommand(os.Stdout, cfg, args[0] == "approve", args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if len(args) > 0 && args[0] == "health" {
+		if err := healthCommand(os.Stdout, cfg, args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if len(args) > 0 && (args[0] == "repo" || args[0] == "key") {
+		lock, err := pub.Lock(cfg.Sbot)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer lock.Close()
+	}
+
+	if len(args) > 0 && args[0] == "repo" {
+		if err := repoCommand(os.Stdout, cfg.Sbot, args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if len(args) > 0 && args[0] == "key" {
+		if err := keyCommand(os.Stdout, cfg.Sbot, args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if len(args) > 0 && args[0] != "blobs" && args[0] != "republish" && args[0] != "feed" && args[0] != "export" {
+		if err := previewFeed(os.Stdout, args[0], cfg.Config, itemFlag, allFlag); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	lock, err := pub.Lock(cfg.Sbot)
 	if err != nil {
 		log.Fatal(err)
 	}
+	defer lock.Close()
+
+	var sbotLog io.Writer
+	if cfg.Sbot.Log.File != "" {
+		logFile, err := pub.OpenLog(cfg.Sbot.Log, cfg.Sbot.DataDir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.SetOutput(logFile)
+		sbotLog = logFile
+	}
 
-	for _, newRSSPost := range newRSSPosts {
-		messages = append(messages, newRSSPost)
+	invites, err := pub.LoadInvites(cfg.Sbot.DataDir)
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	if err := postMessagesToLog(messages, pub); err != nil {
+	bot, err := pub.New(cfg.Sbot, invites, sbotLog)
+	if err != nil {
 		log.Fatal(err)
 	}
 
-	token, err := generatePublicInvite(pub)
+	state, err := publish.LoadState(cfg.Sbot.DataDir, cfg.Feeds[0].Feed)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	log.Printf("main: pub invite: %s", token)
+	if len(args) > 0 && args[0] == "blobs" {
+		err := blobsCommand(os.Stdout, bot, state, args[1:])
+		closeBot(bot)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 
-	for {
-		log.Printf("main: going to sleep for %d minutes...", cfg.Poll)
-		time.Sleep(time.Duration(cfg.Poll) * time.Minute)
-		log.Printf("main: waking up to poll %s for new posts", cfg.Feed)
+	if len(args) > 0 && args[0] == "export" {
+		err := exportCommand(os.Stdout, bot, args[1:])
+		closeBot(bot)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if len(args) > 0 && args[0] == "feed" {
+		err := feedCommand(os.Stdout, bot, cfg, state, args[1:])
+		closeBot(bot)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	images, err := feed.NewImages(filepath.Join(cfg.Sbot.DataDir, imageCacheDir))
+	if err != nil {
+		log.Fatal(err)
+	}
+	shareImages(&cfg, images)
+
+	if len(args) > 0 && args[0] == "republish" {
+		err := republishCommand(os.Stdout, bot, cfg, state, args[1:])
+		closeBot(bot)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// ctx is cancelled on SIGINT and SIGTERM, after which the message in
+	// flight is published and the go-sbot is closed. A second signal exits
+	// immediately.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	if dryRunFlag {
+		state.ReadOnly = true
+		failed, err := dryRun(ctx, bot, cfg, state)
+		closeBot(bot)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if failed > 0 {
+			log.Printf("main: %d feed(s) failed", failed)
+			os.Exit(2)
+		}
+		return
+	}
+
+	if !onceFlag {
+		go pub.Serve(ctx, bot)
+	}
+
+	if cfg.FeedAddr != "" && !onceFlag {
+		go func() {
+			if err := publish.ServeFeed(bot, cfg.FeedAddr); err != nil {
+				log.Fatal(err)
+			}
+		}()
+	}
 
-		posts, err := messagesFromLog(pub)
+	log.Print("main: bootstrapped internally managed go-sbot")
+
+	inviteHost, invitePort := cfg.Sbot.PublicHost, cfg.Sbot.PublicPort
+	if cfg.Sbot.Tor.ControlAddr != "" && !onceFlag {
+		invitePort = cfg.Sbot.Port
+		var onion io.Closer
+		inviteHost, onion, err = pub.ServeOnion(cfg.Sbot)
 		if err != nil {
 			log.Fatal(err)
 		}
+		// Tor removes the onion service once its control connection is gone
+		defer onion.Close()
+
+		log.Printf("main: serving go-sbot over Tor at %s", inviteHost)
+	}
+
+	if len(cfg.Sbot.Rooms) > 0 && !onceFlag {
+		if err := pub.JoinRooms(bot, cfg.Sbot); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if len(cfg.Sbot.Peers) > 0 && !onceFlag {
+		if err := pub.ConnectPeers(bot, cfg.Sbot); err != nil {
+			log.Fatal(err)
+		}
+	}
 
-		messages, err := getNewRSSPosts(feed, posts, pub)
+	var publicInvite string
+	if !onceFlag {
+		token, err := invites.PublicInvite(bot, inviteHost, invitePort, publicInviteUses)
 		if err != nil {
 			log.Fatal(err)
 		}
+		publicInvite = token
+
+		log.Printf("main: pub invite: %s", token)
+
+		if err := pub.WriteInviteFile(cfg.Sbot, bot, token, inviteHost, invitePort); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	due := make([]time.Time, len(cfg.Feeds))
+	for idx, feedCfg := range cfg.Feeds {
+		due[idx] = time.Now()
+		if idx > 0 && !onceFlag {
+			due[idx] = due[idx].Add(feed.Jitter(feedCfg.PollJitter))
+		}
+
+		if state.Feed(feedCfg.Feed).Paused {
+			log.Printf("main: %s is paused, it's gone since %s", feedCfg.Feed, state.Feed(feedCfg.Feed).DeadSince.Format(time.RFC3339))
+			due[idx] = pausedDue
+		}
+	}
+
+	board := &statusBoard{}
+	board.update(cfg.Feeds, due, state)
+
+	pollNow := make(chan string, 1)
+	decisions := make(chan adminDecision)
+	if cfg.AdminAddr != "" && !onceFlag {
+		go serveAdmin(cfg.AdminAddr, cfg.AdminToken, bot, invites, inviteHost, invitePort, board, pollNow, decisions)
+	}
+
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+
+	// the systemd watchdog is kept alive from the poll loop, so that a wedged
+	// loop gets the service restarted
+	var watchdog <-chan time.Time
+	if interval := pub.WatchdogInterval(); interval > 0 && !onceFlag {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		watchdog = ticker.C
+	}
+
+	ready, keptAlive, forceAbout := false, false, false
+	aboutChecked := make(map[string]bool)
+	for {
+		next := 0
+		for idx := range due {
+			if due[idx].Before(due[next]) {
+				next = idx
+			}
+		}
 
-		if err := postMessagesToLog(messages, pub); err != nil {
+		if wait := time.Until(due[next]); wait > 0 {
+			// only paused feeds are left, the others were due right away
+			if onceFlag {
+				log.Printf("main: all feeds are paused")
+				closeBot(bot)
+				return
+			}
+
+			if !keptAlive {
+				log.Printf("main: going to sleep for %s...", wait.Round(time.Second))
+			}
+			keptAlive = false
+
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				shutdown(bot, state)
+				return
+			case <-watchdog:
+				timer.Stop()
+				sdNotify("WATCHDOG=1")
+				keptAlive = true
+				continue
+			case feedURL := <-pollNow:
+				timer.Stop()
+				log.Printf("main: poll requested through the admin API")
+				for idx, feedCfg := range cfg.Feeds {
+					if feedURL == "" || feedURL == feedCfg.Feed {
+						due[idx] = time.Now()
+					}
+				}
+			case decision := <-decisions:
+				timer.Stop()
+				feedURL := applyDecision(state, decision)
+				if feedURL == "" {
+					continue
+				}
+
+				for idx, feedCfg := range cfg.Feeds {
+					if feedURL == feedCfg.Feed {
+						due[idx] = time.Now()
+					}
+				}
+				board.update(cfg.Feeds, due, state)
+			case <-reload:
+				timer.Stop()
+				reloaded, reloadedDue, err := reloadConfig(cfg, due)
+				if err != nil {
+					log.Printf("main: keeping the current config: %s", err)
+					continue
+				}
+
+				if reloaded.Avatar != cfg.Avatar || reloaded.Description != cfg.Description || reloaded.Operator != cfg.Operator {
+					aboutChecked, forceAbout = make(map[string]bool), true
+				}
+
+				cfg, due = reloaded, reloadedDue
+				shareImages(&cfg, images)
+				for idx, feedCfg := range cfg.Feeds {
+					if state.Feed(feedCfg.Feed).Paused {
+						due[idx] = pausedDue
+					}
+				}
+				board.update(cfg.Feeds, due, state)
+				continue
+			}
+		}
+
+		var dueIdxs []int
+		var dueCfgs []feed.Config
+		for idx, feedCfg := range cfg.Feeds {
+			if !due[idx].After(time.Now()) {
+				log.Printf("main: waking up to poll %s for new posts", feedCfg.Feed)
+				dueIdxs = append(dueIdxs, idx)
+				dueCfgs = append(dueCfgs, feedCfg)
+			}
+		}
+
+		checkDiskUsage(bot, cfg, board)
+
+		polls, err := publish.FetchFeeds(ctx, dueCfgs, cfg.Concurrency, bot, bot.BlobStore, state)
+		if err != nil {
 			log.Fatal(err)
 		}
+
+		failed := 0
+
+		for pollIdx, poll := range polls {
+			if ctx.Err() != nil {
+				break
+			}
+
+			idx := dueIdxs[pollIdx]
+			feedCfg := cfg.Feeds[idx]
+
+			feedState := state.Feed(feedCfg.Feed)
+
+			if poll.Err != nil {
+				log.Printf("main: %s", poll.Err)
+				failed++
+				feedState.Failures++
+				feedState.LastError = poll.Err.Error()
+
+				paused, err := publish.CheckDeadFeed(bot, feedCfg, state, poll.Err)
+				if err != nil {
+					log.Printf("main: %s", err)
+				}
+
+				if err := state.Save(); err != nil {
+					log.Fatal(err)
+				}
+
+				if cfg.Alert.Enabled() && paused {
+					publish.Alert(bot, cfg.Alert, fmt.Sprintf("%s has been gone since %s and was paused: %s",
+						feedCfg.Feed, feedState.DeadSince.Format(time.RFC3339), poll.Err))
+				}
+
+				if cfg.Alert.Enabled() && feedState.Failures == cfg.Alert.Failures {
+					publish.Alert(bot, cfg.Alert, fmt.Sprintf("%s failed %d consecutive polls: %s",
+						feedCfg.Feed, feedState.Failures, poll.Err))
+				}
+				due[idx] = feed.NextDue(gofeed.Feed{}, feedCfg, time.Now())
+				if feedState.Paused {
+					due[idx] = pausedDue
+				}
+				continue
+			}
+
+			messages := poll.Messages
+			if (idx == 0 || publish.MetafeedMode(bot)) && !aboutChecked[feedCfg.Feed] {
+				posts, err := publish.MessagesFromLog(bot)
+				if err != nil {
+					log.Fatal(err)
+				}
+
+				avatar, aboutCfg := aboutOf(bot, cfg, idx)
+				aboutMessage, posted, err := publish.CreateAboutMessage(ctx, bot, bot.BlobStore, posts, poll.Feed, avatar, aboutCfg, forceAbout, state)
+				if err != nil {
+					log.Fatal(err)
+				}
+
+				introMessage, introduced, err := publish.IntroductionMessage(poll.Feed, cfg.Config, state)
+				if err != nil {
+					log.Fatal(err)
+				}
+				if introduced {
+					messages = append([]map[string]interface{}{introMessage}, messages...)
+				}
+
+				if posted {
+					messages = append([]map[string]interface{}{aboutMessage}, messages...)
+				}
+
+				aboutChecked[feedCfg.Feed] = true
+			}
+
+			if err := publish.PostMessagesToLog(ctx, messages, bot, feedCfg, state); err != nil {
+				// the queue is kept in the state store, publishing it is
+				// tried again on the next poll
+				log.Printf("main: %s", err)
+				failed++
+				feedState.Failures++
+				feedState.LastError = err.Error()
+				if err := state.Save(); err != nil {
+					log.Fatal(err)
+				}
+
+				if cfg.Alert.Enabled() {
+					publish.Alert(bot, cfg.Alert, fmt.Sprintf("publishing %s failed: %s", feedCfg.Feed, err))
+				}
+				due[idx] = feed.NextDue(poll.Feed, feedCfg, time.Now())
+				continue
+			}
+
+			if feedState.Paused {
+				log.Printf("main: %s is back, resuming it", feedCfg.Feed)
+			}
+
+			feedState.LastPoll = time.Now()
+			feedState.Failures = 0
+			feedState.LastError = ""
+			feedState.DeadSince, feedState.Paused = time.Time{}, false
+			if err := state.Save(); err != nil {
+				log.Fatal(err)
+			}
+
+			due[idx] = feed.NextDue(poll.Feed, feedCfg, time.Now())
+
+			if watchdog != nil {
+				sdNotify("WATCHDOG=1")
+			}
+		}
+
+		if ctx.Err() != nil {
+			shutdown(bot, state)
+			return
+		}
+
+		board.update(cfg.Feeds, due, state)
+
+		if !ready {
+			sdNotify("READY=1")
+			ready = true
+		}
+
+		if cfg.Alert.Enabled() {
+			for _, imageURL := range cfg.Images.TakeMissingImages(alertImageMisses) {
+				publish.Alert(bot, cfg.Alert, fmt.Sprintf("image %s was not found %d times in a row", imageURL, alertImageMisses))
+			}
+		}
+
+		if cfg.FollowBack {
+			if _, err := publish.FollowBack(bot, cfg.FollowBackAllow, state); err != nil {
+				log.Printf("main: %s", err)
+			}
+		}
+
+		if cfg.Notify.Enabled() {
+			if err := publish.Notify(bot, cfg.Notify, state); err != nil {
+				log.Printf("main: %s", err)
+			}
+		}
+
+		if cfg.Moderation.Enabled() {
+			decided, err := publish.Moderate(bot, cfg.Moderation, state)
+			if err != nil {
+				log.Printf("main: %s", err)
+			}
+
+			for idx, feedCfg := range cfg.Feeds {
+				for _, feedURL := range decided {
+					if feedURL == feedCfg.Feed {
+						due[idx] = time.Now()
+					}
+				}
+			}
+		}
+
+		if cfg.Summary {
+			if err := publish.PublishSummary(bot, cfg.Config, cfg.Feeds, publicInvite, state); err != nil {
+				log.Printf("main: %s", err)
+			}
+		}
+
+		if onceFlag {
+			closeBot(bot)
+			if failed > 0 {
+				log.Printf("main: %d feed(s) failed", failed)
+				os.Exit(2)
+			}
+			return
+		}
 	}
 }
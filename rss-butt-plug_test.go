@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+
+	"decentral1se/rss-butt-plug/publish"
+)
+
+func TestApplyEnvKeepsStrings(t *testing.T) {
+	t.Setenv("RSS_BUTT_PLUG_ADMIN_TOKEN", "0123")
+	t.Setenv("RSS_BUTT_PLUG_USER_AGENT", "yes")
+	t.Setenv("RSS_BUTT_PLUG_POLL", "10")
+	t.Setenv("RSS_BUTT_PLUG_HEADERS__X_TOKEN", "1e3")
+
+	conf, err := applyEnv([]byte("feed: https://example.org/feed.xml\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cfg Config
+	if err := yaml.UnmarshalStrict(conf, &cfg); err != nil {
+		t.Fatalf("unable to unmarshal %s: %s", conf, err)
+	}
+
+	if cfg.AdminToken != "0123" || cfg.UserAgent != "yes" || cfg.Headers["x-token"] != "1e3" {
+		t.Errorf("string options were changed: admin-token %q, user-agent %q, headers %v", cfg.AdminToken, cfg.UserAgent, cfg.Headers)
+	}
+
+	if cfg.Poll != 10 {
+		t.Errorf("poll = %d, want 10", cfg.Poll)
+	}
+}
+
+func TestApplyEnvMissingVariable(t *testing.T) {
+	_, err := applyEnv([]byte("feed: ${RSS_BUTT_PLUG_TEST_UNSET}\n"))
+	if err == nil || !strings.Contains(err.Error(), "RSS_BUTT_PLUG_TEST_UNSET") {
+		t.Errorf("applyEnv() error = %v, want one naming the unset variable", err)
+	}
+}
+
+func TestAdminDecisionWhilePublishing(t *testing.T) {
+	const feedURL = "https://example.org/feed.xml"
+
+	state, err := publish.LoadState(t.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.Feed(feedURL).Pending = []publish.PendingItem{{ID: "abc", Title: "Held"}}
+
+	decisions := make(chan adminDecision)
+	server := httptest.NewServer(decideHandler(decisions, true))
+	defer server.Close()
+
+	// the scheduler publishes (writing to the state maps) and applies the
+	// decisions in between, like the poll loop
+	stop, done := make(chan struct{}), make(chan struct{})
+	decided := make(chan string, 1)
+	go func() {
+		defer close(done)
+		for idx := 0; ; idx++ {
+			link := fmt.Sprintf("https://example.org/%d", idx)
+			state.Ignored[link] = true
+			state.Feed(link).Published++
+			if err := state.Save(); err != nil {
+				t.Error(err)
+			}
+
+			select {
+			case decision := <-decisions:
+				decided <- applyDecision(state, decision)
+			case <-stop:
+				return
+			default:
+			}
+		}
+	}()
+	defer func() {
+		close(stop)
+		<-done
+	}()
+
+	resp, err := http.Post(server.URL+"?id=abc", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(string(body), "approved abc") {
+		t.Fatalf("POST /approve = %s %s", resp.Status, body)
+	}
+
+	if feed := <-decided; feed != feedURL {
+		t.Errorf("applyDecision() = %q, want %s", feed, feedURL)
+	}
+}